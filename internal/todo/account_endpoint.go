@@ -0,0 +1,99 @@
+package todo
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// AccountEndpoints collects all endpoints which compose the Account service
+type AccountEndpoints struct {
+	RegisterEndpoint endpoint.Endpoint
+	LoginEndpoint    endpoint.Endpoint
+	RefreshEndpoint  endpoint.Endpoint
+	LogoutEndpoint   endpoint.Endpoint
+}
+
+// MakeAccountEndpoints returns an AccountEndpoints struct where each endpoint invokes
+// the corresponding method on the provided AccountService.
+// Requests are validated before they reach the service.
+func MakeAccountEndpoints(s AccountService) AccountEndpoints {
+	validate := ValidatingMiddleware()
+	return AccountEndpoints{
+		RegisterEndpoint: validate(MakeRegisterEndpoint(s)),
+		LoginEndpoint:    validate(MakeLoginEndpoint(s)),
+		RefreshEndpoint:  validate(MakeRefreshEndpoint(s)),
+		LogoutEndpoint:   validate(MakeLogoutEndpoint(s)),
+	}
+}
+
+type RegisterRequest struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	unknownFields []string
+}
+
+type RegisterResponse struct {
+	Account Account `json:"account"`
+}
+
+func MakeRegisterEndpoint(s AccountService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RegisterRequest)
+		account, err := s.Register(ctx, req.Username, req.Password)
+		return RegisterResponse{account}, err
+	}
+}
+
+type LoginRequest struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	unknownFields []string
+}
+
+type LoginResponse struct {
+	Tokens
+}
+
+func MakeLoginEndpoint(s AccountService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(LoginRequest)
+		tokens, err := s.Login(ctx, req.Username, req.Password)
+		return LoginResponse{tokens}, err
+	}
+}
+
+type RefreshRequest struct {
+	RefreshToken  string `json:"refresh_token"`
+	unknownFields []string
+}
+
+type RefreshResponse struct {
+	Tokens
+}
+
+func MakeRefreshEndpoint(s AccountService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RefreshRequest)
+		tokens, err := s.Refresh(ctx, req.RefreshToken)
+		return RefreshResponse{tokens}, err
+	}
+}
+
+// LogoutRequest revokes a refresh token. Everywhere revokes all the user's refresh tokens, signing out their other devices.
+type LogoutRequest struct {
+	RefreshToken  string `json:"refresh_token"`
+	Everywhere    bool   `json:"everywhere"`
+	unknownFields []string
+}
+
+type LogoutResponse struct {
+}
+
+func MakeLogoutEndpoint(s AccountService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(LogoutRequest)
+		err := s.Logout(ctx, req.RefreshToken, req.Everywhere)
+		return LogoutResponse{}, err
+	}
+}
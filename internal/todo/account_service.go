@@ -0,0 +1,278 @@
+package todo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account is a user who signs in with a password
+type Account struct {
+	Username     string    `json:"username"`
+	PasswordHash []byte    `json:"-"`
+	CreatedOn    time.Time `json:"created_on"`
+}
+
+// Tokens authenticate a user. The access token is a JWT accepted by JWTAuth, sent as JWT {token}.
+// When it expires the refresh token is exchanged for new Tokens.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// AccountService registers users & issues the tokens they authenticate with
+type AccountService interface {
+	Register(ctx context.Context, username, password string) (Account, error)
+	Login(ctx context.Context, username, password string) (Tokens, error)
+	// Refresh exchanges a refresh token for new Tokens. Each refresh token can only be used once.
+	Refresh(ctx context.Context, refreshToken string) (Tokens, error)
+	// Logout revokes a refresh token, or every refresh token of its user when everywhere is set
+	Logout(ctx context.Context, refreshToken string, everywhere bool) error
+	// DeleteUser deletes a user's Account & revokes their refresh tokens, for administrators
+	DeleteUser(ctx context.Context, username string) error
+}
+
+var (
+	// ErrUsernameTaken is when registering a username which already has an Account
+	ErrUsernameTaken = &ConflictError{Detail: "Username is already registered"}
+	// ErrInvalidCredentials is when logging in with an unknown username or the wrong password
+	ErrInvalidCredentials = &UnauthorizedError{Detail: "Invalid username or password"}
+	// ErrInvalidRefreshToken is when a refresh token is unknown, expired, revoked or already used
+	ErrInvalidRefreshToken = &UnauthorizedError{Detail: "Invalid refresh token"}
+)
+
+// TokenIssuer mints access tokens for the JWTConfig they'll be checked against
+type TokenIssuer struct {
+	secret   []byte
+	issuer   string
+	audience string
+	ttl      time.Duration
+}
+
+// NewTokenIssuer creates a TokenIssuer minting HS256 tokens which live for ttl
+func NewTokenIssuer(config JWTConfig, ttl time.Duration) (*TokenIssuer, error) {
+	for _, alg := range config.Algorithms {
+		if alg == jwt.SigningMethodHS256.Alg() {
+			return &TokenIssuer{secret: config.Secret, issuer: config.Issuer, audience: config.Audience, ttl: ttl}, nil
+		}
+	}
+	return nil, errors.New("JWT_ALGORITHMS must contain HS256 to issue tokens")
+}
+
+// Issue mints an access token for username
+func (i *TokenIssuer) Issue(username string, now time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"username": username,
+		"sub":      username,
+		"iat":      now.Unix(),
+		"exp":      now.Add(i.ttl).Unix(),
+	}
+	if i.issuer != "" {
+		claims["iss"] = i.issuer
+	}
+	if i.audience != "" {
+		claims["aud"] = i.audience
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(i.secret)
+}
+
+// NewInmemAccountService creates an AccountService which keeps Accounts in memory
+func NewInmemAccountService(issuer *TokenIssuer, refreshTTL time.Duration) AccountService {
+	return &inmemAccountService{
+		issuer:        issuer,
+		refreshTTL:    refreshTTL,
+		cost:          bcrypt.DefaultCost,
+		accounts:      map[string]Account{},
+		refreshTokens: map[string]*refreshToken{},
+	}
+}
+
+// refreshToken is an issued refresh token.
+// Tokens descending from the same login share a family, which is revoked if a used token is replayed.
+type refreshToken struct {
+	username string
+	family   string
+	expires  time.Time
+	used     bool
+}
+
+type inmemAccountService struct {
+	sync.Mutex
+	issuer     *TokenIssuer
+	refreshTTL time.Duration
+	// cost is the bcrypt cost, lowered in tests
+	cost     int
+	accounts map[string]Account
+	// refreshTokens are keyed by the token's hash, so a leak of memory doesn't leak usable tokens
+	refreshTokens map[string]*refreshToken
+
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+}
+
+// normalizeUsername makes usernames case insensitive, as they're usually email addresses
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// Register an Account
+func (s *inmemAccountService) Register(ctx context.Context, username, password string) (Account, error) {
+	// hash before locking, it's deliberately slow
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return Account{}, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	username = normalizeUsername(username)
+	if _, ok := s.accounts[username]; ok {
+		return Account{}, ErrUsernameTaken
+	}
+	account := Account{Username: username, PasswordHash: hash, CreatedOn: time.Now()}
+	s.accounts[username] = account
+	return account, nil
+}
+
+// Login checks a user's password & issues them Tokens
+func (s *inmemAccountService) Login(ctx context.Context, username, password string) (Tokens, error) {
+	s.Lock()
+	account, ok := s.accounts[normalizeUsername(username)]
+	s.Unlock()
+
+	if !ok {
+		// compare anyway, so unknown usernames take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(s.dummyPasswordHash(), []byte(password))
+		return Tokens{}, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)) != nil {
+		return Tokens{}, ErrInvalidCredentials
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.sweepRefreshTokens()
+	return s.issue(account.Username, "")
+}
+
+// Refresh rotates a refresh token.
+// A refresh token which has already been used has probably been stolen, so its whole family is revoked.
+func (s *inmemAccountService) Refresh(ctx context.Context, token string) (Tokens, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	rt, ok := s.refreshTokens[hashRefreshToken(token)]
+	switch {
+	case !ok || time.Now().After(rt.expires):
+		return Tokens{}, ErrInvalidRefreshToken
+	case rt.used:
+		s.revoke(func(other *refreshToken) bool { return other.family == rt.family })
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+	rt.used = true
+	return s.issue(rt.username, rt.family)
+}
+
+// Logout revokes a refresh token's family, or all of its user's refresh tokens
+func (s *inmemAccountService) Logout(ctx context.Context, token string, everywhere bool) error {
+	s.Lock()
+	defer s.Unlock()
+
+	rt, ok := s.refreshTokens[hashRefreshToken(token)]
+	if !ok {
+		return ErrInvalidRefreshToken
+	}
+	if everywhere {
+		s.revoke(func(other *refreshToken) bool { return other.username == rt.username })
+	} else {
+		s.revoke(func(other *refreshToken) bool { return other.family == rt.family })
+	}
+	return nil
+}
+
+// DeleteUser deletes a user's Account & every refresh token issued to them.
+// Access tokens already issued stay valid until they expire.
+func (s *inmemAccountService) DeleteUser(ctx context.Context, username string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	username = normalizeUsername(username)
+	delete(s.accounts, username)
+	s.revoke(func(rt *refreshToken) bool { return rt.username == username })
+	return nil
+}
+
+// issue creates Tokens for username, continuing a refresh token family or starting a new one.
+// s must be locked.
+func (s *inmemAccountService) issue(username, family string) (Tokens, error) {
+	now := time.Now()
+	access, err := s.issuer.Issue(username, now)
+	if err != nil {
+		return Tokens{}, err
+	}
+	refresh, err := randomToken()
+	if err != nil {
+		return Tokens{}, err
+	}
+	if family == "" {
+		family = hashRefreshToken(refresh)
+	}
+	s.refreshTokens[hashRefreshToken(refresh)] = &refreshToken{
+		username: username,
+		family:   family,
+		expires:  now.Add(s.refreshTTL),
+	}
+	return Tokens{
+		AccessToken:  access,
+		TokenType:    "JWT",
+		ExpiresIn:    int(s.issuer.ttl / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// revoke deletes the refresh tokens matching fn. s must be locked.
+func (s *inmemAccountService) revoke(fn func(*refreshToken) bool) {
+	for hash, rt := range s.refreshTokens {
+		if fn(rt) {
+			delete(s.refreshTokens, hash)
+		}
+	}
+}
+
+// sweepRefreshTokens deletes expired refresh tokens. s must be locked.
+func (s *inmemAccountService) sweepRefreshTokens() {
+	now := time.Now()
+	s.revoke(func(rt *refreshToken) bool { return now.After(rt.expires) })
+}
+
+func (s *inmemAccountService) dummyPasswordHash() []byte {
+	s.dummyHashOnce.Do(func() {
+		s.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), s.cost)
+	})
+	return s.dummyHash
+}
+
+// randomToken returns 256 random bits, URL safe encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,149 @@
+package todo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestAccountService creates an AccountService issuing tokens testJWTConfig accepts, hashing cheaply
+func newTestAccountService(t *testing.T) *inmemAccountService {
+	issuer, err := NewTokenIssuer(testJWTConfig(), time.Minute)
+	require.NoError(t, err)
+	s := NewInmemAccountService(issuer, time.Hour).(*inmemAccountService)
+	s.cost = bcrypt.MinCost
+	return s
+}
+
+// TestRegisterAndLogin tests a registered user can log in with their password only
+func TestRegisterAndLogin(t *testing.T) {
+	s := newTestAccountService(t)
+	ctx := context.Background()
+
+	account, err := s.Register(ctx, "Test@Test.com", "correct horse")
+	require.NoError(t, err)
+	require.Equal(t, "test@test.com", account.Username)
+	require.NotContains(t, string(account.PasswordHash), "correct horse")
+
+	_, err = s.Register(ctx, "test@test.com", "another password")
+	require.Equal(t, ErrUsernameTaken, err)
+
+	tokens, err := s.Login(ctx, "TEST@test.com", "correct horse")
+	require.NoError(t, err)
+	require.Equal(t, "JWT", tokens.TokenType)
+	require.Equal(t, 60, tokens.ExpiresIn)
+	claims, err := testJWTConfig().parse(tokens.AccessToken)
+	require.NoError(t, err, "Expected the access token to be accepted by JWTAuth")
+	require.Equal(t, "test@test.com", claims["username"])
+
+	_, err = s.Login(ctx, "test@test.com", "wrong password")
+	require.Equal(t, ErrInvalidCredentials, err)
+	_, err = s.Login(ctx, "nobody@test.com", "correct horse")
+	require.Equal(t, ErrInvalidCredentials, err)
+}
+
+// TestRefreshTokenRotation tests refresh tokens are single use, & replaying one revokes its family
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestAccountService(t)
+	ctx := context.Background()
+	s.Register(ctx, "test@test.com", "correct horse")
+
+	first, err := s.Login(ctx, "test@test.com", "correct horse")
+	require.NoError(t, err)
+	other, err := s.Login(ctx, "test@test.com", "correct horse")
+	require.NoError(t, err)
+
+	second, err := s.Refresh(ctx, first.RefreshToken)
+	require.NoError(t, err)
+	require.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	// the first token was stolen & replayed, so its descendants are revoked too
+	_, err = s.Refresh(ctx, first.RefreshToken)
+	require.Equal(t, ErrInvalidRefreshToken, err)
+	_, err = s.Refresh(ctx, second.RefreshToken)
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	// other logins are unaffected
+	_, err = s.Refresh(ctx, other.RefreshToken)
+	require.NoError(t, err)
+
+	_, err = s.Refresh(ctx, "made up")
+	require.Equal(t, ErrInvalidRefreshToken, err)
+}
+
+// TestRefreshTokenExpiry tests expired refresh tokens are refused & swept
+func TestRefreshTokenExpiry(t *testing.T) {
+	s := newTestAccountService(t)
+	ctx := context.Background()
+	s.Register(ctx, "test@test.com", "correct horse")
+	tokens, _ := s.Login(ctx, "test@test.com", "correct horse")
+
+	for _, rt := range s.refreshTokens {
+		rt.expires = time.Now().Add(-time.Second)
+	}
+	_, err := s.Refresh(ctx, tokens.RefreshToken)
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	s.Login(ctx, "test@test.com", "correct horse")
+	require.Len(t, s.refreshTokens, 1, "Expected the expired token to be swept")
+}
+
+// TestLogout tests logging out revokes a login, or every login of the user
+func TestLogout(t *testing.T) {
+	s := newTestAccountService(t)
+	ctx := context.Background()
+	s.Register(ctx, "test@test.com", "correct horse")
+	s.Register(ctx, "other@test.com", "correct horse")
+
+	phone, _ := s.Login(ctx, "test@test.com", "correct horse")
+	laptop, _ := s.Login(ctx, "test@test.com", "correct horse")
+	tablet, _ := s.Login(ctx, "test@test.com", "correct horse")
+	otherUser, _ := s.Login(ctx, "other@test.com", "correct horse")
+
+	require.NoError(t, s.Logout(ctx, phone.RefreshToken, false))
+	_, err := s.Refresh(ctx, phone.RefreshToken)
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	laptop, err = s.Refresh(ctx, laptop.RefreshToken)
+	require.NoError(t, err)
+	require.NoError(t, s.Logout(ctx, laptop.RefreshToken, true))
+	_, err = s.Refresh(ctx, tablet.RefreshToken)
+	require.Equal(t, ErrInvalidRefreshToken, err, "Expected logging out everywhere to revoke the user's other logins")
+
+	_, err = s.Refresh(ctx, otherUser.RefreshToken)
+	require.NoError(t, err, "Expected other users to stay logged in")
+
+	require.Equal(t, ErrInvalidRefreshToken, s.Logout(ctx, phone.RefreshToken, false))
+}
+
+// TestDeleteAccount tests deleting a user deletes their Account & revokes their logins
+func TestDeleteAccount(t *testing.T) {
+	s := newTestAccountService(t)
+	ctx := context.Background()
+	s.Register(ctx, "test@test.com", "correct horse")
+	s.Register(ctx, "other@test.com", "correct horse")
+	tokens, _ := s.Login(ctx, "test@test.com", "correct horse")
+	other, _ := s.Login(ctx, "other@test.com", "correct horse")
+
+	require.NoError(t, s.DeleteUser(ctx, "Test@test.com"))
+	_, err := s.Refresh(ctx, tokens.RefreshToken)
+	require.Equal(t, ErrInvalidRefreshToken, err)
+	_, err = s.Login(ctx, "test@test.com", "correct horse")
+	require.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = s.Refresh(ctx, other.RefreshToken)
+	require.NoError(t, err, "Expected other users to stay logged in")
+	_, err = s.Register(ctx, "test@test.com", "new password")
+	require.NoError(t, err, "Expected the username to be free again")
+}
+
+// TestNewTokenIssuer tests tokens can't be issued unless HS256 tokens are accepted
+func TestNewTokenIssuer(t *testing.T) {
+	config := testJWTConfig()
+	config.Algorithms = []string{"RS256"}
+	_, err := NewTokenIssuer(config, time.Minute)
+	require.Error(t, err)
+}
@@ -0,0 +1,102 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	chiMiddleware "github.com/go-chi/chi/middleware"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// MakeAccountHTTPHandler creates the http transport layer for the Account service, serving it under /api/auth.
+// Those routes are unauthenticated, as they're how a user gets a JWT. Every other request is passed to next.
+func MakeAccountHTTPHandler(endpoints AccountEndpoints, next http.Handler, logger log.Logger) http.Handler {
+
+	options := []httptransport.ServerOption{
+		httptransport.ServerBefore(httptransport.PopulateRequestContext),
+		httptransport.ServerErrorEncoder(logErrors(logger, encodeError)),
+		httptransport.ServerErrorLogger(transportErrorLogger(logger)),
+	}
+
+	auth := chi.NewRouter()
+	auth.Use(RequestID)
+	auth.Use(AccessLog(logger))
+	auth.Use(chiMiddleware.StripSlashes)
+	auth.Use(chiMiddleware.NoCache)
+	auth.Use(Negotiate)
+
+	auth.Post("/register", httptransport.NewServer(
+		endpoints.RegisterEndpoint,
+		decodeRegisterRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	auth.Post("/login", httptransport.NewServer(
+		endpoints.LoginEndpoint,
+		decodeLoginRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	auth.Post("/refresh", httptransport.NewServer(
+		endpoints.RefreshEndpoint,
+		decodeRefreshRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	auth.Post("/logout", httptransport.NewServer(
+		endpoints.LogoutEndpoint,
+		decodeLogoutRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	r := chi.NewRouter()
+	r.Mount("/api/auth", auth)
+	r.Handle("/*", next)
+	return r
+}
+
+func decodeRegisterRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	var req RegisterRequest
+	unknown, err := decodeBody(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.unknownFields = unknown
+	return req, nil
+}
+
+func decodeLoginRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	var req LoginRequest
+	unknown, err := decodeBody(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.unknownFields = unknown
+	return req, nil
+}
+
+func decodeRefreshRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	var req RefreshRequest
+	unknown, err := decodeBody(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.unknownFields = unknown
+	return req, nil
+}
+
+func decodeLogoutRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	var req LogoutRequest
+	unknown, err := decodeBody(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.unknownFields = unknown
+	return req, nil
+}
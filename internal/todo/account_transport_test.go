@@ -0,0 +1,75 @@
+package todo
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func postJSON(t *testing.T, url string, payload interface{}) *http.Response {
+	b := &bytes.Buffer{}
+	json.NewEncoder(b).Encode(payload)
+	res, err := http.Post(url, "application/json", b)
+	require.NoErrorf(t, err, "Error doing POST request to %s", url)
+	return res
+}
+
+// TestAccountsHTTP tests registering, logging in & using the issued token against the Todo API
+func TestAccountsHTTP(t *testing.T) {
+	jwtConfig := newJWTConfig(t)
+	issuer, err := NewTokenIssuer(jwtConfig, time.Minute)
+	require.NoError(t, err)
+	accounts := NewInmemAccountService(issuer, time.Hour)
+	accounts.(*inmemAccountService).cost = bcrypt.MinCost
+
+	todos := MakeHTTPHandler(MakeTodoEndpoints(NewInmemTodoService()), jwtConfig, NewInmemAPIKeyService(), NewInmemCalendarTokenService(), NewIdempotencyStore(time.Hour), testMaxBodyBytes, log.NewNopLogger())
+	server := httptest.NewServer(MakeAccountHTTPHandler(MakeAccountEndpoints(accounts), todos, log.NewNopLogger()))
+	defer server.Close()
+
+	res := postJSON(t, server.URL+"/api/auth/register", map[string]string{"username": "new@test.com", "password": "short"})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = postJSON(t, server.URL+"/api/auth/register", map[string]string{"username": "new@test.com", "password": "correct horse"})
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = postJSON(t, server.URL+"/api/auth/login", map[string]string{"username": "new@test.com", "password": "wrong password"})
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	require.Equal(t, "JWT", res.Header.Get("WWW-Authenticate"))
+
+	res = postJSON(t, server.URL+"/api/auth/login", map[string]string{"username": "new@test.com", "password": "correct horse"})
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Contains(t, res.Header.Get("Cache-Control"), "no-store", "Expected tokens not to be cached")
+	var tokens Tokens
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/todos", nil)
+	req.Header.Set("Authorization", tokens.TokenType+" "+tokens.AccessToken)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode, "Expected the issued token to authenticate Todo requests")
+
+	res = postJSON(t, server.URL+"/api/auth/refresh", map[string]string{"refresh_token": tokens.RefreshToken})
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = postJSON(t, server.URL+"/api/auth/refresh", map[string]string{"refresh_token": tokens.RefreshToken})
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode, "Expected a used refresh token to be refused")
+
+	// other API routes still need a JWT
+	res, err = http.Get(server.URL + "/api/todos")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
@@ -0,0 +1,146 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Role grants a user access to operations beyond their own data
+type Role string
+
+const (
+	// RoleUser is every authenticated user, who can only reach their own data
+	RoleUser Role = "user"
+	// RoleSupport can inspect, but not change, other users' data
+	RoleSupport Role = "support"
+	// RoleAdmin can inspect & delete other users' data
+	RoleAdmin Role = "admin"
+)
+
+// rolesFromClaims reads the roles in a JWT's claim, either a list or a space separated string.
+// Every user has RoleUser, & roles this service doesn't know are ignored.
+func rolesFromClaims(claims jwt.MapClaims, claim string) []Role {
+	var names []string
+	switch v := claims[claim].(type) {
+	case string:
+		names = strings.Fields(v)
+	case []interface{}:
+		for _, name := range v {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+
+	roles := []Role{RoleUser}
+	for _, name := range names {
+		switch role := Role(strings.ToLower(name)); role {
+		case RoleSupport, RoleAdmin:
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// rolesFromContext returns the roles of the authenticated user
+func rolesFromContext(ctx context.Context) []Role {
+	if roles, ok := ctx.Value(contextKeyRoles).([]Role); ok {
+		return roles
+	}
+	return []Role{RoleUser}
+}
+
+// RequireRole is endpoint middleware refusing requests from users without any of roles
+func RequireRole(roles ...Role) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			for _, have := range rolesFromContext(ctx) {
+				for _, want := range roles {
+					if have == want {
+						return next(ctx, request)
+					}
+				}
+			}
+			return nil, &ForbiddenError{Detail: fmt.Sprintf("Requires the %s role", joinRoles(roles))}
+		}
+	}
+}
+
+func joinRoles(roles []Role) string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, " or ")
+}
+
+type GetUsersRequest struct {
+}
+
+type GetUsersResponse struct {
+	Users []UserSummary `json:"users"`
+}
+
+func MakeGetUsersEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		users, err := s.GetUsers(ctx)
+		return GetUsersResponse{users}, err
+	}
+}
+
+type GetUserRequest struct {
+	Username string
+}
+
+type GetUserResponse struct {
+	User UserSummary `json:"user"`
+}
+
+func MakeGetUserEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetUserRequest)
+		users, err := s.GetUsers(ctx)
+		if err != nil {
+			return GetUserResponse{}, err
+		}
+		for _, user := range users {
+			if user.Username == req.Username {
+				return GetUserResponse{user}, nil
+			}
+		}
+		return GetUserResponse{}, ErrNotFound
+	}
+}
+
+type DeleteUserRequest struct {
+	Username string
+}
+
+// DeleteUserResponse counts the data which was deleted
+type DeleteUserResponse struct {
+	Deleted UserSummary `json:"deleted"`
+}
+
+// UserDeleter deletes what a service keeps for a user outside of the TodoService, e.g. their API keys or Account
+type UserDeleter interface {
+	DeleteUser(ctx context.Context, username string) error
+}
+
+// MakeDeleteUserEndpoint deletes a user's data from s, after deleting their credentials from others,
+// so the user can't go on adding data while it's being deleted.
+func MakeDeleteUserEndpoint(s TodoService, others ...UserDeleter) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeleteUserRequest)
+		for _, other := range others {
+			if err := other.DeleteUser(ctx, req.Username); err != nil {
+				return DeleteUserResponse{}, err
+			}
+		}
+		deleted, err := s.DeleteUser(ctx, req.Username)
+		return DeleteUserResponse{deleted}, err
+	}
+}
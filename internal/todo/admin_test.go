@@ -0,0 +1,97 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRolesFromClaims tests roles are read from a list or a string, ignoring unknown roles
+func TestRolesFromClaims(t *testing.T) {
+	tests := []struct {
+		claim interface{}
+		roles []Role
+	}{
+		{nil, []Role{RoleUser}},
+		{"admin", []Role{RoleUser, RoleAdmin}},
+		{"Support superuser", []Role{RoleUser, RoleSupport}},
+		{[]interface{}{"support", "admin", 7}, []Role{RoleUser, RoleSupport, RoleAdmin}},
+	}
+	for _, tc := range tests {
+		claims := jwt.MapClaims{}
+		if tc.claim != nil {
+			claims["https://todo.example.com/roles"] = tc.claim
+		}
+		require.Equal(t, tc.roles, rolesFromClaims(claims, "https://todo.example.com/roles"), "%v", tc.claim)
+	}
+}
+
+// TestAdminEndpoints tests support can inspect users' data & only admins can delete it, along with the user's API keys
+func TestAdminEndpoints(t *testing.T) {
+	todoService := NewInmemTodoService()
+	apiKeys := NewInmemAPIKeyService()
+	handler := MakeHTTPHandler(MakeTodoEndpoints(todoService, apiKeys), newJWTConfig(t), apiKeys, NewInmemCalendarTokenService(), NewIdempotencyStore(time.Hour), testMaxBodyBytes, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	staff := newToken(t, jwt.MapClaims{"username": "staff@test.com"})
+	support := newToken(t, jwt.MapClaims{"username": "staff@test.com", "roles": []string{"support"}})
+	admin := newToken(t, jwt.MapClaims{"username": "staff@test.com", "roles": []string{"admin"}})
+
+	ctx := context.Background()
+	todoService.Add(ctx, Todo{Username: "a@test.com", Text: "One"})
+	todoService.Add(ctx, Todo{Username: "a@test.com", Text: "Two", Completed: true})
+	todoService.AddView(ctx, View{Username: "a@test.com", Name: "Open", Query: "completed:false"})
+	todoService.Add(ctx, Todo{Username: "b@test.com", Text: "Three"})
+	_, secret, _ := apiKeys.Create(ctx, APIKey{Username: "a@test.com", Scopes: []string{ScopeTodosRead, ScopeTodosWrite}})
+
+	res := newCall(t, http.MethodGet, server.URL+"/api/admin/users", "JWT", staff, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode, "Expected ordinary users to be refused")
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/admin/users", "JWT", support, nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var users GetUsersResponse
+	json.NewDecoder(res.Body).Decode(&users)
+	require.Equal(t, []UserSummary{
+		{Username: "a@test.com", Todos: 2, Completed: 1, Views: 1},
+		{Username: "b@test.com", Todos: 1},
+	}, users.Users)
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/admin/users/b@test.com", "JWT", support, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = newCall(t, http.MethodDelete, server.URL+"/api/admin/users/a@test.com", "JWT", support, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode, "Expected support to be refused deleting data")
+
+	res = newCall(t, http.MethodDelete, server.URL+"/api/admin/users/a@test.com", "JWT", admin, nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var deleted DeleteUserResponse
+	json.NewDecoder(res.Body).Decode(&deleted)
+	require.Equal(t, UserSummary{Username: "a@test.com", Todos: 2, Completed: 1, Views: 1}, deleted.Deleted)
+
+	todos, _ := todoService.GetAllForUser(ctx, "a@test.com")
+	require.Empty(t, todos)
+	res = newCall(t, http.MethodPost, server.URL+"/api/todos", "ApiKey", secret, map[string]string{"text": "Back again"})
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode, "Expected the user's API key to be revoked")
+	results, _ := todoService.Search(ctx, "a@test.com", "one")
+	require.Empty(t, results, "Expected the user's search index to be deleted")
+	todos, _ = todoService.GetAllForUser(ctx, "b@test.com")
+	require.Len(t, todos, 1, "Expected other users' data to be kept")
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/admin/users/a@test.com", "JWT", admin, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
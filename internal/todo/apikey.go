@@ -0,0 +1,283 @@
+package todo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rs/xid"
+)
+
+// APIKeyPrefix starts every API key, so leaked keys are easy to recognise & scan for
+const APIKeyPrefix = "tdk_"
+
+const (
+	// ScopeTodosRead allows reading Todos & Views
+	ScopeTodosRead = "todos:read"
+	// ScopeTodosWrite allows creating, changing & deleting Todos & Views
+	ScopeTodosWrite = "todos:write"
+)
+
+// APIKeyScopes lists the scopes an APIKey can be granted
+var APIKeyScopes = []string{ScopeTodosRead, ScopeTodosWrite}
+
+var (
+	// ErrInvalidAPIKey is when an API key is unknown or has been revoked
+	ErrInvalidAPIKey = &UnauthorizedError{Detail: "Invalid API key"}
+	// ErrSessionRequired is when an API key is used for something only the user themselves can do
+	ErrSessionRequired = &ForbiddenError{Detail: "API keys can't be used for this operation"}
+)
+
+// APIKey lets scripts & integrations act for a user, limited to its Scopes.
+// Only a hash of the key is kept; the key itself is shown once, when it's created.
+type APIKey struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	// Tenant is the organisation the key acts in, the tenant of the user who created it
+	Tenant string `json:"tenant,omitempty"`
+	Name   string `json:"name"`
+	// Prefix is the start of the key, identifying it without revealing it
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	CreatedOn time.Time  `json:"created_on"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	hash      string
+}
+
+// HasScope reports whether the key was granted scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyService manages users' API keys
+type APIKeyService interface {
+	// Create makes a key, returning it with the secret key the user authenticates with
+	Create(ctx context.Context, key APIKey) (APIKey, string, error)
+	GetAllForUser(ctx context.Context, username string) ([]APIKey, error)
+	Revoke(ctx context.Context, username string, id string) error
+	// Authenticate finds the APIKey for a secret key, recording its use
+	Authenticate(ctx context.Context, secret string) (APIKey, error)
+	// DeleteUser revokes all of a user's APIKeys, for administrators
+	DeleteUser(ctx context.Context, username string) error
+}
+
+// NewInmemAPIKeyService creates an in memory APIKey service
+func NewInmemAPIKeyService() APIKeyService {
+	return &inmemAPIKeyService{keys: map[string]APIKey{}, byHash: map[string]string{}}
+}
+
+type inmemAPIKeyService struct {
+	sync.RWMutex
+	keys map[string]APIKey
+	// byHash maps the hash of a secret key to its ID
+	byHash map[string]string
+}
+
+// Create makes an APIKey with a random secret
+func (s *inmemAPIKeyService) Create(ctx context.Context, key APIKey) (APIKey, string, error) {
+	random, err := randomToken()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret := APIKeyPrefix + random
+
+	s.Lock()
+	defer s.Unlock()
+
+	key.ID = xid.New().String()
+	key.Prefix = secret[:len(APIKeyPrefix)+8]
+	key.CreatedOn = time.Now()
+	key.LastUsed = nil
+	key.hash = hashAPIKey(secret)
+	s.keys[key.ID] = key
+	s.byHash[key.hash] = key.ID
+	return key, secret, nil
+}
+
+// GetAllForUser gets a user's APIKeys in the context's tenant, oldest first
+func (s *inmemAPIKeyService) GetAllForUser(ctx context.Context, username string) ([]APIKey, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	tenant := tenantFromContext(ctx)
+	keys := []APIKey{}
+	for _, key := range s.keys {
+		if key.Username == username && key.Tenant == tenant {
+			keys = append(keys, key)
+		}
+	}
+	// xids sort in the order they were created
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// Revoke deletes one of a user's APIKeys, so it can no longer be used
+func (s *inmemAPIKeyService) Revoke(ctx context.Context, username string, id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok || key.Username != username || key.Tenant != tenantFromContext(ctx) {
+		return ErrNotFound
+	}
+	delete(s.byHash, key.hash)
+	delete(s.keys, id)
+	return nil
+}
+
+// DeleteUser revokes every APIKey of a user in the context's tenant
+func (s *inmemAPIKeyService) DeleteUser(ctx context.Context, username string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	tenant := tenantFromContext(ctx)
+	for id, key := range s.keys {
+		if key.Username == username && key.Tenant == tenant {
+			delete(s.byHash, key.hash)
+			delete(s.keys, id)
+		}
+	}
+	return nil
+}
+
+// Authenticate finds the APIKey for a secret key & sets when it was last used
+func (s *inmemAPIKeyService) Authenticate(ctx context.Context, secret string) (APIKey, error) {
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	id, ok := s.byHash[hashAPIKey(secret)]
+	if !ok {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	key := s.keys[id]
+	now := time.Now()
+	key.LastUsed = &now
+	s.keys[id] = key
+	return key, nil
+}
+
+// hashAPIKey hashes a secret key for storage. Keys are random, so a fast hash is enough.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyFromContext returns the APIKey a request was authenticated with, if it wasn't a user's session
+func apiKeyFromContext(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(contextKeyAPIKey).(APIKey)
+	return key, ok
+}
+
+// RequireScope is endpoint middleware refusing requests authenticated by an API key without scope.
+// Requests authenticated by a JWT act as the user themselves, so they have every scope.
+func RequireScope(scope string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if key, ok := apiKeyFromContext(ctx); ok && !key.HasScope(scope) {
+				return nil, &ForbiddenError{Detail: fmt.Sprintf("API key doesn't have the %s scope", scope)}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// RequireSession is endpoint middleware refusing requests authenticated by an API key
+func RequireSession() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if _, ok := apiKeyFromContext(ctx); ok {
+				return nil, ErrSessionRequired
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// APIKeyEndpoints collects the endpoints users manage their API keys with.
+// They need the user's session, so an API key can't be used to create or revoke keys.
+type APIKeyEndpoints struct {
+	GetAPIKeysEndpoint   endpoint.Endpoint
+	CreateAPIKeyEndpoint endpoint.Endpoint
+	RevokeAPIKeyEndpoint endpoint.Endpoint
+}
+
+// MakeAPIKeyEndpoints returns an APIKeyEndpoints struct where each endpoint invokes
+// the corresponding method on the provided APIKeyService.
+func MakeAPIKeyEndpoints(s APIKeyService) APIKeyEndpoints {
+	validate := ValidatingMiddleware()
+	session := RequireSession()
+	return APIKeyEndpoints{
+		GetAPIKeysEndpoint:   session(validate(MakeGetAPIKeysEndpoint(s))),
+		CreateAPIKeyEndpoint: session(validate(MakeCreateAPIKeyEndpoint(s))),
+		RevokeAPIKeyEndpoint: session(validate(MakeRevokeAPIKeyEndpoint(s))),
+	}
+}
+
+type GetAPIKeysRequest struct {
+}
+
+type GetAPIKeysResponse struct {
+	APIKeys []APIKey `json:"api_keys"`
+}
+
+func MakeGetAPIKeysEndpoint(s APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		keys, err := s.GetAllForUser(ctx, ctx.Value("username").(string))
+		return GetAPIKeysResponse{keys}, err
+	}
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	unknownFields []string
+}
+
+// CreateAPIKeyResponse holds the new APIKey & its Secret, which can't be retrieved again
+type CreateAPIKeyResponse struct {
+	APIKey APIKey `json:"api_key"`
+	Secret string `json:"secret"`
+}
+
+func MakeCreateAPIKeyEndpoint(s APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateAPIKeyRequest)
+		key, secret, err := s.Create(ctx, APIKey{
+			Username: ctx.Value("username").(string),
+			Tenant:   tenantFromContext(ctx),
+			Name:     strings.TrimSpace(req.Name),
+			Scopes:   req.Scopes,
+		})
+		return CreateAPIKeyResponse{APIKey: key, Secret: secret}, err
+	}
+}
+
+type RevokeAPIKeyRequest struct {
+	ID string
+}
+
+type RevokeAPIKeyResponse struct {
+}
+
+func MakeRevokeAPIKeyEndpoint(s APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RevokeAPIKeyRequest)
+		err := s.Revoke(ctx, ctx.Value("username").(string), req.ID)
+		return RevokeAPIKeyResponse{}, err
+	}
+}
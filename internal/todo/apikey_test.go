@@ -0,0 +1,112 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAPIKeyService tests keys are only kept hashed, record their use & can be revoked
+func TestAPIKeyService(t *testing.T) {
+	s := NewInmemAPIKeyService()
+	ctx := context.Background()
+
+	key, secret, err := s.Create(ctx, APIKey{Username: "test@test.com", Name: "Backup script", Scopes: []string{ScopeTodosRead}})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(secret, APIKeyPrefix))
+	require.True(t, strings.HasPrefix(secret, key.Prefix))
+	require.NotContains(t, key.hash, secret[len(APIKeyPrefix):], "Expected the key to be hashed")
+	require.Nil(t, key.LastUsed)
+
+	used, err := s.Authenticate(ctx, secret)
+	require.NoError(t, err)
+	require.Equal(t, key.ID, used.ID)
+	keys, _ := s.GetAllForUser(ctx, "test@test.com")
+	require.Len(t, keys, 1)
+	require.NotNil(t, keys[0].LastUsed, "Expected the key's use to be recorded")
+
+	_, err = s.Authenticate(ctx, secret+"x")
+	require.Equal(t, ErrInvalidAPIKey, err)
+
+	require.Equal(t, ErrNotFound, s.Revoke(ctx, "other@test.com", key.ID), "Expected other users not to revoke the key")
+	require.NoError(t, s.Revoke(ctx, "test@test.com", key.ID))
+	_, err = s.Authenticate(ctx, secret)
+	require.Equal(t, ErrInvalidAPIKey, err)
+
+	_, secret, _ = s.Create(ctx, APIKey{Username: "test@test.com", Name: "Sync", Scopes: []string{ScopeTodosRead}})
+	_, other, _ := s.Create(ctx, APIKey{Username: "other@test.com", Name: "Sync", Scopes: []string{ScopeTodosRead}})
+	require.NoError(t, s.DeleteUser(ctx, "test@test.com"))
+	_, err = s.Authenticate(ctx, secret)
+	require.Equal(t, ErrInvalidAPIKey, err, "Expected deleting the user to revoke their keys")
+	_, err = s.Authenticate(ctx, other)
+	require.NoError(t, err, "Expected other users' keys to be kept")
+}
+
+// TestAPIKeyScopes tests API keys authenticate as their user, limited to their scopes
+func TestAPIKeyScopes(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	createKey := func(scopes ...string) string {
+		res := newHTTPServerCall(t, http.MethodPost, server.URL+"/api/keys", map[string]interface{}{"name": "Script", "scopes": scopes})
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var created CreateAPIKeyResponse
+		json.NewDecoder(res.Body).Decode(&created)
+		return created.Secret
+	}
+	readOnly := createKey(ScopeTodosRead)
+	readWrite := createKey(ScopeTodosRead, ScopeTodosWrite)
+
+	res := newHTTPServerCall(t, http.MethodPost, server.URL+"/api/keys", map[string]interface{}{"name": "Script", "scopes": []string{"admin"}})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = newCall(t, http.MethodPost, server.URL+"/api/todos", "ApiKey", readWrite, Todo{Text: "From a script"})
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	todos, _ := todoService.GetAllForUser(context.Background(), "test@test.com")
+	require.Len(t, todos, 1, "Expected the Todo to belong to the key's user")
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/todos", "ApiKey", readOnly, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = newCall(t, http.MethodPost, server.URL+"/api/todos", "ApiKey", readOnly, Todo{Text: "Not allowed"})
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = newCall(t, http.MethodPost, server.URL+"/api/batch", "ApiKey", readOnly, map[string]interface{}{
+		"operations": []map[string]interface{}{{"op": "add", "todo": Todo{Text: "Sneaky"}}},
+	})
+	res.Body.Close()
+	todos, _ = todoService.GetAllForUser(context.Background(), "test@test.com")
+	require.Len(t, todos, 1, "Expected a batch not to get around the key's scopes")
+
+	// keys can't manage keys or get calendar tokens
+	res = newCall(t, http.MethodPost, server.URL+"/api/keys", "ApiKey", readWrite, map[string]interface{}{"name": "Another", "scopes": []string{ScopeTodosWrite}})
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	res = newCall(t, http.MethodGet, server.URL+"/api/calendar", "ApiKey", readWrite, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = newHTTPServerCall(t, http.MethodGet, server.URL+"/api/keys", nil)
+	defer res.Body.Close()
+	var listed GetAPIKeysResponse
+	json.NewDecoder(res.Body).Decode(&listed)
+	require.Len(t, listed.APIKeys, 2)
+	require.NotNil(t, listed.APIKeys[1].LastUsed)
+
+	res = newHTTPServerCall(t, http.MethodDelete, server.URL+"/api/keys/"+listed.APIKeys[1].ID, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = newCall(t, http.MethodGet, server.URL+"/api/todos", "ApiKey", readWrite, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode, "Expected a revoked key to be refused")
+}
@@ -0,0 +1,61 @@
+package todo
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+type AssignRequest struct {
+	ID string `json:"-"`
+	// Assignee is the user to assign the Todo to, or empty to unassign it
+	Assignee      string `json:"assignee"`
+	unknownFields []string
+}
+
+type AssignResponse struct {
+	Todo Todo `json:"todo"`
+}
+
+// MakeAssignEndpoint returns an endpoint which assigns a Todo the user can edit
+func MakeAssignEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AssignRequest)
+		todo, err := s.Assign(ctx, ctx.Value("username").(string), req.ID, req.Assignee)
+		return AssignResponse{todo}, err
+	}
+}
+
+type GetAssignedToMeRequest struct {
+}
+
+type GetAssignedToMeResponse struct {
+	Todos []Todo `json:"todos"`
+}
+
+func MakeGetAssignedToMeEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		todos, err := s.GetAssignedToUser(ctx, ctx.Value("username").(string))
+		return GetAssignedToMeResponse{todos}, err
+	}
+}
+
+type AddToViewRequest struct {
+	ViewID        string
+	Todo          Todo
+	unknownFields []string
+}
+
+type AddToViewResponse struct {
+	Todo Todo `json:"todo"`
+}
+
+// MakeAddToViewEndpoint returns an endpoint which adds a Todo to one of the user's Views, or a View shared with them as an editor.
+// The Todo belongs to the View's owner.
+func MakeAddToViewEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AddToViewRequest)
+		todo, err := s.AddToView(ctx, ctx.Value("username").(string), req.ViewID, req.Todo)
+		return AddToViewResponse{todo}, err
+	}
+}
@@ -0,0 +1,204 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	middleware "github.com/sinnott74/go-http-middleware"
+)
+
+// JWTConfig describes which JWTs are accepted
+type JWTConfig struct {
+	Secret []byte
+	// Issuer, when set, must match the token's iss claim
+	Issuer string
+	// Audience, when set, must be one of the token's aud claims
+	Audience string
+	// Leeway allows for clock skew between the issuer & this service when checking exp & nbf
+	Leeway time.Duration
+	// Algorithms lists the signing algorithms tokens may use
+	Algorithms []string
+	// Keys, when set, verifies RSA & ECDSA signed tokens. HMAC signed tokens are verified with the Secret.
+	Keys KeySource
+	// RolesClaim names the claim holding the user's Roles, defaulting to roles
+	RolesClaim string
+	// TenantClaim names the claim holding the user's tenant, defaulting to tenant
+	TenantClaim string
+}
+
+// NewJWTConfig creates the JWT configuration from the service's Config.
+// A JWKS is refreshed in the background until ctx is done, logging failures to logger.
+func NewJWTConfig(ctx context.Context, config Config, logger log.Logger) (JWTConfig, error) {
+	c := JWTConfig{
+		Secret:      []byte(config.JWTSecret),
+		Issuer:      config.JWTIssuer,
+		Audience:    config.JWTAudience,
+		Leeway:      config.JWTLeeway,
+		Algorithms:  config.JWTAlgorithms,
+		RolesClaim:  config.JWTRolesClaim,
+		TenantClaim: config.JWTTenantClaim,
+	}
+	switch {
+	case config.JWKSURL != "" && config.JWTPublicKeysFile != "":
+		return c, errors.New("only one of JWKS_URL & JWT_PUBLIC_KEYS_FILE can be set")
+	case config.JWKSURL != "":
+		c.Keys = NewJWKS(ctx, config.JWKSURL, config.JWKSRefreshInterval, logger)
+	case config.JWTPublicKeysFile != "":
+		keys, err := LoadPEMKeys(config.JWTPublicKeysFile)
+		if err != nil {
+			return c, fmt.Errorf("reading JWT_PUBLIC_KEYS_FILE: %s", err)
+		}
+		c.Keys = keys
+	}
+	return c, nil
+}
+
+// Check reports configuration the service mustn't start with.
+// The default secret is public, so it's only allowed in development mode.
+func (c JWTConfig) Check(devMode bool) error {
+	if len(c.Algorithms) == 0 {
+		return errors.New("JWT_ALGORITHMS must list at least one algorithm")
+	}
+	usesSecret, usesKeys := false, false
+	for _, alg := range c.Algorithms {
+		switch jwt.GetSigningMethod(alg).(type) {
+		case *jwt.SigningMethodHMAC:
+			usesSecret = true
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+			if c.Keys == nil {
+				return fmt.Errorf("JWT_ALGORITHMS contains %s, which needs JWKS_URL or JWT_PUBLIC_KEYS_FILE", alg)
+			}
+			usesKeys = true
+		default:
+			return fmt.Errorf("JWT_ALGORITHMS contains unsupported algorithm %q", alg)
+		}
+	}
+	if usesSecret && !devMode && string(c.Secret) == DefaultJWTSecret {
+		return errors.New("JWT_SECRET must be set outside development mode")
+	}
+	if c.Keys != nil && !usesKeys {
+		return errors.New("JWT_ALGORITHMS must contain an RSA or ECDSA algorithm to use public keys")
+	}
+	return nil
+}
+
+// parse verifies a token's signature & claims, returning its claims
+func (c JWTConfig) parse(tokenString string) (jwt.MapClaims, error) {
+	// claims are checked below, with leeway
+	parser := jwt.Parser{ValidMethods: c.Algorithms, SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(tokenString, claims, c.key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case !claims.VerifyExpiresAt(now.Add(-c.Leeway).Unix(), true):
+		return nil, errors.New("token is expired or has no exp claim")
+	case !claims.VerifyNotBefore(now.Add(c.Leeway).Unix(), false):
+		return nil, errors.New("token isn't valid yet")
+	case c.Issuer != "" && !claims.VerifyIssuer(c.Issuer, true):
+		return nil, errors.New("token has the wrong issuer")
+	case c.Audience != "" && !hasAudience(claims, c.Audience):
+		return nil, errors.New("token has the wrong audience")
+	}
+	return claims, nil
+}
+
+// key finds the key verifying a token, by its signing method & key ID.
+// The signing method decides the kind of key, so a public key can't be used as a HMAC secret.
+func (c JWTConfig) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return c.Secret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if c.Keys == nil {
+			return nil, errors.New("no public keys are configured")
+		}
+		kid, _ := token.Header["kid"].(string)
+		return c.Keys.Key(kid)
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+func (c JWTConfig) rolesClaim() string {
+	if c.RolesClaim == "" {
+		return "roles"
+	}
+	return c.RolesClaim
+}
+
+func (c JWTConfig) tenantClaim() string {
+	if c.TenantClaim == "" {
+		return "tenant"
+	}
+	return c.TenantClaim
+}
+
+// hasAudience reports whether the aud claim, a string or an array of strings, contains audience
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JWTAuth is middleware which authenticates requests by the JWT in their Authorization header, i.e. JWT {token}.
+// The token's username claim is put in the context.
+func JWTAuth(config JWTConfig) func(http.Handler) http.Handler {
+	return Authenticate(config, nil)
+}
+
+// Authenticate is middleware which authenticates requests by their Authorization header,
+// either a JWT, i.e. JWT {token}, or, when apiKeys is set, an API key, i.e. ApiKey {key}.
+// The user, their tenant & Roles are put in the context, along with the APIKey if one was used.
+// API keys only have RoleUser, whatever their user's roles, & act in the tenant they were created in.
+func Authenticate(config JWTConfig, apiKeys APIKeyService) func(http.Handler) http.Handler {
+	return middleware.Auth(func(ctx context.Context, header string) (context.Context, error) {
+		parts := strings.Fields(header)
+		if len(parts) != 2 {
+			return ctx, errors.New("Authorization header format must be JWT {token} or ApiKey {key}")
+		}
+		switch {
+		case strings.EqualFold(parts[0], "jwt"):
+			claims, err := config.parse(parts[1])
+			if err != nil {
+				return ctx, err
+			}
+			username, ok := claims["username"].(string)
+			if !ok || username == "" {
+				return ctx, errors.New("No username")
+			}
+			tenant, err := tenantFromClaims(claims, config.tenantClaim())
+			if err != nil {
+				return ctx, err
+			}
+			ctx = context.WithValue(ctx, contextKeyRoles, rolesFromClaims(claims, config.rolesClaim()))
+			ctx = context.WithValue(ctx, contextKeyTenant, tenant)
+			return context.WithValue(ctx, "username", username), nil
+		case strings.EqualFold(parts[0], "apikey") && apiKeys != nil:
+			key, err := apiKeys.Authenticate(ctx, parts[1])
+			if err != nil {
+				return ctx, err
+			}
+			ctx = context.WithValue(ctx, contextKeyAPIKey, key)
+			ctx = context.WithValue(ctx, contextKeyTenant, key.Tenant)
+			return context.WithValue(ctx, "username", key.Username), nil
+		}
+		return ctx, errors.New("Authorization header format must be JWT {token} or ApiKey {key}")
+	})
+}
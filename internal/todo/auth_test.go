@@ -0,0 +1,140 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func testJWTConfig() JWTConfig {
+	return JWTConfig{
+		Secret:     []byte("test secret"),
+		Issuer:     "https://auth.example.com",
+		Audience:   "todo-service",
+		Leeway:     time.Minute,
+		Algorithms: []string{"HS256"},
+	}
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, secret []byte, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(secret)
+	require.NoError(t, err, "Error signing JWT")
+	return token
+}
+
+// validClaims returns claims testJWTConfig accepts
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"username": "test@test.com",
+		"iss":      "https://auth.example.com",
+		"aud":      "todo-service",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// TestJWTClaimsValidation tests tokens are checked for expiry, issuer & audience
+func TestJWTClaimsValidation(t *testing.T) {
+	config := testJWTConfig()
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		change func(jwt.MapClaims)
+		valid  bool
+	}{
+		{"valid", func(c jwt.MapClaims) {}, true},
+		{"audience in a list", func(c jwt.MapClaims) { c["aud"] = []string{"other", "todo-service"} }, true},
+		{"expired within leeway", func(c jwt.MapClaims) { c["exp"] = now.Add(-30 * time.Second).Unix() }, true},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, false},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, false},
+		{"not before within leeway", func(c jwt.MapClaims) { c["nbf"] = now.Add(30 * time.Second).Unix() }, true},
+		{"not yet valid", func(c jwt.MapClaims) { c["nbf"] = now.Add(2 * time.Minute).Unix() }, false},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, false},
+		{"no issuer", func(c jwt.MapClaims) { delete(c, "iss") }, false},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = []string{"other"} }, false},
+		{"no audience", func(c jwt.MapClaims) { delete(c, "aud") }, false},
+	}
+	for _, tc := range tests {
+		claims := validClaims()
+		tc.change(claims)
+		_, err := config.parse(signJWT(t, jwt.SigningMethodHS256, config.Secret, claims))
+		if tc.valid {
+			require.NoError(t, err, tc.name)
+		} else {
+			require.Error(t, err, tc.name)
+		}
+	}
+}
+
+// TestJWTAlgorithmPinning tests tokens signed with algorithms outside the allow list are refused
+func TestJWTAlgorithmPinning(t *testing.T) {
+	config := testJWTConfig()
+
+	_, err := config.parse(signJWT(t, jwt.SigningMethodHS512, config.Secret, validClaims()))
+	require.Error(t, err, "Expected HS512 to be refused when only HS256 is allowed")
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = config.parse(unsigned)
+	require.Error(t, err, "Expected an unsigned token to be refused")
+
+	_, err = config.parse(signJWT(t, jwt.SigningMethodHS256, []byte("another secret"), validClaims()))
+	require.Error(t, err, "Expected a token signed with another secret to be refused")
+
+	config.Algorithms = []string{"HS256", "HS512"}
+	_, err = config.parse(signJWT(t, jwt.SigningMethodHS512, config.Secret, validClaims()))
+	require.NoError(t, err)
+}
+
+// TestJWTConfigCheck tests the service refuses to start with an unsafe configuration
+func TestJWTConfigCheck(t *testing.T) {
+	config := testJWTConfig()
+	require.NoError(t, config.Check(false))
+
+	config.Secret = []byte(DefaultJWTSecret)
+	require.Error(t, config.Check(false), "Expected the default secret to be refused")
+	require.NoError(t, config.Check(true), "Expected the default secret to be allowed in development mode")
+
+	config.Algorithms = []string{"RS256"}
+	require.Error(t, config.Check(true), "Expected an algorithm without a key to be refused")
+
+	config.Algorithms = nil
+	require.Error(t, config.Check(true), "Expected at least one algorithm to be required")
+
+	config.Algorithms = []string{"HS256"}
+	config.Keys = KeySet{}
+	require.Error(t, config.Check(true), "Expected public keys without a public key algorithm to be refused")
+}
+
+// TestJWTAuth tests the middleware puts the token's user in the context
+func TestJWTAuth(t *testing.T) {
+	config := testJWTConfig()
+	var username interface{}
+	handler := JWTAuth(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username = r.Context().Value("username")
+	}))
+
+	call := func(header string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(context.Background())
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, call("JWT "+signJWT(t, jwt.SigningMethodHS256, config.Secret, validClaims())))
+	require.Equal(t, "test@test.com", username)
+
+	noUsername := validClaims()
+	delete(noUsername, "username")
+	require.Equal(t, http.StatusUnauthorized, call("JWT "+signJWT(t, jwt.SigningMethodHS256, config.Secret, noUsername)))
+	require.Equal(t, http.StatusUnauthorized, call("Basic dXNlcjpwYXNz"))
+	require.Equal(t, http.StatusUnauthorized, call(""))
+}
@@ -0,0 +1,117 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Batch operation names
+const (
+	BatchGet    = "get"
+	BatchAdd    = "add"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// BatchOperation is a single operation within a batch.
+// Ref names the operation's Todo ID so later operations can use it as "$ref" in place of an ID.
+type BatchOperation struct {
+	Op            string `json:"op"`
+	Ref           string `json:"ref,omitempty"`
+	ID            string `json:"id,omitempty"`
+	Todo          Todo   `json:"todo"`
+	unknownFields []string
+}
+
+type BatchRequest struct {
+	Operations []BatchOperation
+}
+
+// BatchResult is the outcome of a single operation within a batch.
+// Body holds the operation's response, or a Problem when it failed.
+type BatchResult struct {
+	Op     string      `json:"op"`
+	Ref    string      `json:"ref,omitempty"`
+	Status int         `json:"status"`
+	Body   interface{} `json:"body,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// MakeBatchEndpoint returns an endpoint which dispatches each operation of a batch, in order, to the given endpoints.
+// A failed operation doesn't stop the batch, but operations referring to its ID fail with StatusFailedDependency.
+func MakeBatchEndpoint(e TodoEndpoints) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(BatchRequest)
+		refs := map[string]string{}
+		results := make([]BatchResult, 0, len(req.Operations))
+
+		for _, op := range req.Operations {
+			result := BatchResult{Op: op.Op, Ref: op.Ref}
+
+			id, ok := resolveRef(op.ID, refs)
+			if !ok {
+				result.Status = http.StatusFailedDependency
+				result.Body = newProblem(fmt.Errorf("Referenced operation %s did not succeed", op.ID), result.Status)
+				results = append(results, result)
+				continue
+			}
+
+			response, err := dispatchBatchOperation(ctx, e, op, id)
+			if err != nil {
+				result.Status = codeFrom(err)
+				result.Body = newProblem(err, result.Status)
+				results = append(results, result)
+				continue
+			}
+
+			if add, ok := response.(AddResponse); ok {
+				id = add.Todo.ID
+			}
+			if op.Ref != "" {
+				refs[op.Ref] = id
+			}
+			result.Status = http.StatusOK
+			result.Body = response
+			results = append(results, result)
+		}
+
+		return BatchResponse{results}, nil
+	}
+}
+
+// dispatchBatchOperation calls the endpoint for a single operation, with back references already resolved to id
+func dispatchBatchOperation(ctx context.Context, e TodoEndpoints, op BatchOperation, id string) (interface{}, error) {
+	switch op.Op {
+	case BatchGet:
+		return e.GetByIDEndpoint(ctx, GetByIDRequest{id})
+	case BatchAdd:
+		return e.AddEndpoint(ctx, AddRequest{Todo: op.Todo, unknownFields: op.unknownFields})
+	case BatchUpdate:
+		todo := op.Todo
+		if todo.ID == "" || todo.ID == op.ID {
+			todo.ID = id
+		}
+		return e.UpdateEndpoint(ctx, UpdateRequest{ID: id, Todo: todo, unknownFields: op.unknownFields})
+	case BatchDelete:
+		return e.DeleteEndpoint(ctx, DeleteRequest{id})
+	default:
+		return nil, &ValidationError{Detail: "Unknown operation " + op.Op}
+	}
+}
+
+// resolveRef replaces a "$ref" back reference with the ID it refers to.
+// It reports false if the referenced operation failed.
+func resolveRef(id string, refs map[string]string) (string, bool) {
+	if !strings.HasPrefix(id, "$") {
+		return id, true
+	}
+	resolved, ok := refs[strings.TrimPrefix(id, "$")]
+	return resolved, ok
+}
@@ -0,0 +1,82 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBatchWithBackReferences tests a batch which adds a Todo then updates & deletes it by reference
+func TestBatchWithBackReferences(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	batch := map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "add", "ref": "milk", "todo": map[string]interface{}{"text": "Buy milk"}},
+			{"op": "update", "id": "$milk", "todo": map[string]interface{}{"text": "Buy oat milk", "completed": true}},
+			{"op": "get", "id": "$milk"},
+			{"op": "delete", "id": "$milk"},
+			{"op": "get", "id": "$milk"},
+		},
+	}
+	res := newHTTPServerCall(t, http.MethodPost, server.URL+"/api/batch", batch)
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusOK, res.StatusCode, "Expecting StatusOK for batch")
+
+	var batchResponse struct {
+		Results []struct {
+			Status int             `json:"status"`
+			Body   json.RawMessage `json:"body"`
+		} `json:"results"`
+	}
+	json.NewDecoder(res.Body).Decode(&batchResponse)
+	require.Equal(t, 5, len(batchResponse.Results), "Expecting a result per operation")
+
+	statuses := []int{}
+	for _, result := range batchResponse.Results {
+		statuses = append(statuses, result.Status)
+	}
+	require.Equal(t, []int{200, 200, 200, 200, 404}, statuses)
+
+	var getByIDResponse GetByIDResponse
+	json.Unmarshal(batchResponse.Results[2].Body, &getByIDResponse)
+	require.Equal(t, "Buy oat milk", getByIDResponse.Todo.Text, "Expecting the update to have been applied")
+	require.True(t, getByIDResponse.Todo.Completed, "Expecting the update to have been applied")
+}
+
+// TestBatchFailedDependency tests operations referring to a failed operation aren't attempted
+func TestBatchFailedDependency(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	batch := map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "add", "ref": "empty", "todo": map[string]interface{}{"text": ""}},
+			{"op": "delete", "id": "$empty"},
+		},
+	}
+	res := newHTTPServerCall(t, http.MethodPost, server.URL+"/api/batch", batch)
+	defer res.Body.Close()
+
+	var batchResponse BatchResponse
+	json.NewDecoder(res.Body).Decode(&batchResponse)
+	require.Equal(t, http.StatusBadRequest, batchResponse.Results[0].Status, "Expecting invalid add to fail")
+	require.Equal(t, http.StatusFailedDependency, batchResponse.Results[1].Status, "Expecting dependent delete not to run")
+}
+
+// TestBatchValidation tests back references must point at earlier operations
+func TestBatchValidation(t *testing.T) {
+	errs := BatchRequest{[]BatchOperation{
+		{Op: "delete", ID: "$later"},
+		{Op: "add", Ref: "later"},
+		{Op: "rename", ID: "x"},
+	}}.validate(context.Background())
+	require.Equal(t, []FieldError{
+		{"operations[0].id", "must refer to an earlier operation"},
+		{"operations[2].op", "must be one of get, add, update or delete"},
+	}, errs)
+}
@@ -0,0 +1,254 @@
+package todo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// CalendarTokenService keeps the token which authenticates each user's calendar URLs.
+// Calendar apps can't send a JWT, so the token in the URL is all they authenticate with.
+type CalendarTokenService interface {
+	// Get returns the user's token, creating one on first use
+	Get(ctx context.Context, username string) (string, error)
+	// Regenerate replaces the user's token, so URLs with the old token stop working
+	Regenerate(ctx context.Context, username string) (string, error)
+	// Revoke deletes the user's token, until Get creates another
+	Revoke(ctx context.Context, username string) error
+	// Authenticate returns the tenant & user a token belongs to
+	Authenticate(ctx context.Context, token string) (string, string, error)
+	// DeleteUser revokes a user's token, for administrators
+	DeleteUser(ctx context.Context, username string) error
+}
+
+// NewInmemCalendarTokenService creates an in memory CalendarTokenService
+func NewInmemCalendarTokenService() CalendarTokenService {
+	return &inmemCalendarTokenService{tokens: map[calendarUser]string{}, users: map[string]calendarUser{}}
+}
+
+// calendarUser is a user of a tenant
+type calendarUser struct {
+	tenant   string
+	username string
+}
+
+// inmemCalendarTokenService keeps tokens rather than their hashes, unlike API keys,
+// as the user's calendar URLs are shown to them whenever they ask.
+type inmemCalendarTokenService struct {
+	sync.Mutex
+	tokens map[calendarUser]string
+	users  map[string]calendarUser
+}
+
+// Get returns the token of a user in the context's tenant, creating a random token on first use
+func (s *inmemCalendarTokenService) Get(ctx context.Context, username string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	user := calendarUser{tenantFromContext(ctx), username}
+	if token, ok := s.tokens[user]; ok {
+		return token, nil
+	}
+	return s.generate(user)
+}
+
+// Regenerate replaces the token of a user in the context's tenant with a new random token
+func (s *inmemCalendarTokenService) Regenerate(ctx context.Context, username string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	user := calendarUser{tenantFromContext(ctx), username}
+	s.revoke(user)
+	return s.generate(user)
+}
+
+// Revoke deletes the token of a user in the context's tenant
+func (s *inmemCalendarTokenService) Revoke(ctx context.Context, username string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.revoke(calendarUser{tenantFromContext(ctx), username})
+	return nil
+}
+
+// Authenticate finds the user of a token, reporting unknown tokens as not found
+func (s *inmemCalendarTokenService) Authenticate(ctx context.Context, token string) (string, string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	user, ok := s.users[token]
+	if !ok {
+		return "", "", ErrNotFound
+	}
+	return user.tenant, user.username, nil
+}
+
+// DeleteUser revokes the token of a user in the context's tenant
+func (s *inmemCalendarTokenService) DeleteUser(ctx context.Context, username string) error {
+	return s.Revoke(ctx, username)
+}
+
+// generate creates a random token for user. s must be locked.
+func (s *inmemCalendarTokenService) generate(user calendarUser) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	s.tokens[user] = token
+	s.users[token] = user
+	return token, nil
+}
+
+// revoke deletes user's token, if they have one. s must be locked.
+func (s *inmemCalendarTokenService) revoke(user calendarUser) {
+	if token, ok := s.tokens[user]; ok {
+		delete(s.users, token)
+		delete(s.tokens, user)
+	}
+}
+
+type CalendarLinksRequest struct {
+}
+
+// CalendarLinksResponse holds the URLs, relative to the server, of the user's calendar feed & CalDAV collection
+type CalendarLinksResponse struct {
+	FeedURL       string `json:"feed_url"`
+	CollectionURL string `json:"collection_url"`
+}
+
+// calendarLinks returns the URLs authenticated by a calendar token
+func calendarLinks(token string) CalendarLinksResponse {
+	base := "/calendar/" + token
+	return CalendarLinksResponse{FeedURL: base + "/todos.ics", CollectionURL: base + "/todos/"}
+}
+
+// makeCalendarLinksEndpoint returns an endpoint which tells the user where to find their calendar
+func makeCalendarLinksEndpoint(s CalendarTokenService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		token, err := s.Get(ctx, ctx.Value("username").(string))
+		if err != nil {
+			return CalendarLinksResponse{}, err
+		}
+		return calendarLinks(token), nil
+	}
+}
+
+type RegenerateCalendarTokenRequest struct {
+}
+
+// makeRegenerateCalendarTokenEndpoint returns an endpoint which replaces the user's calendar token,
+// responding with their new calendar URLs
+func makeRegenerateCalendarTokenEndpoint(s CalendarTokenService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		token, err := s.Regenerate(ctx, ctx.Value("username").(string))
+		if err != nil {
+			return CalendarLinksResponse{}, err
+		}
+		return calendarLinks(token), nil
+	}
+}
+
+type RevokeCalendarTokenRequest struct {
+}
+
+type RevokeCalendarTokenResponse struct {
+}
+
+// makeRevokeCalendarTokenEndpoint returns an endpoint which stops the user's calendar URLs working
+func makeRevokeCalendarTokenEndpoint(s CalendarTokenService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		err := s.Revoke(ctx, ctx.Value("username").(string))
+		return RevokeCalendarTokenResponse{}, err
+	}
+}
+
+// CalendarGetRequest reads a single VTODO resource of the user's calendar collection
+type CalendarGetRequest struct {
+	ID string
+}
+
+type CalendarGetResponse struct {
+	Todo Todo
+}
+
+// CalendarPutRequest creates or replaces a VTODO resource of the user's calendar collection
+type CalendarPutRequest struct {
+	ID   string
+	Todo Todo
+}
+
+// CalendarPutResponse holds the stored Todo. Created is set when the PUT made a new Todo,
+// whose ID is chosen by the service rather than the calendar app.
+type CalendarPutResponse struct {
+	Todo    Todo
+	Created bool
+}
+
+type CalendarDeleteRequest struct {
+	ID string
+}
+
+type CalendarDeleteResponse struct {
+}
+
+// MakeCalendarGetEndpoint returns an endpoint which reads one of the user's Todos.
+// Other users' Todos are reported as not found.
+func MakeCalendarGetEndpoint(e TodoEndpoints) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CalendarGetRequest)
+		todo, err := ownTodo(ctx, e, req.ID)
+		return CalendarGetResponse{todo}, err
+	}
+}
+
+// MakeCalendarPutEndpoint returns an endpoint which updates the user's Todo with the resource's ID,
+// or adds a new Todo if there isn't one.
+func MakeCalendarPutEndpoint(e TodoEndpoints) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CalendarPutRequest)
+		existing, err := ownTodo(ctx, e, req.ID)
+		switch {
+		case err == nil:
+			todo := req.Todo
+			todo.ID, todo.Username, todo.CreatedOn = existing.ID, existing.Username, existing.CreatedOn
+			if _, err := e.UpdateEndpoint(ctx, UpdateRequest{ID: todo.ID, Todo: todo}); err != nil {
+				return nil, err
+			}
+			return CalendarPutResponse{Todo: todo}, nil
+		case err != ErrNotFound:
+			return nil, err
+		}
+
+		response, err := e.AddEndpoint(ctx, AddRequest{Todo: req.Todo})
+		if err != nil {
+			return nil, err
+		}
+		return CalendarPutResponse{Todo: response.(AddResponse).Todo, Created: true}, nil
+	}
+}
+
+// MakeCalendarDeleteEndpoint returns an endpoint which deletes one of the user's Todos
+func MakeCalendarDeleteEndpoint(e TodoEndpoints) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CalendarDeleteRequest)
+		if _, err := ownTodo(ctx, e, req.ID); err != nil {
+			return nil, err
+		}
+		_, err := e.DeleteEndpoint(ctx, DeleteRequest{req.ID})
+		return CalendarDeleteResponse{}, err
+	}
+}
+
+// ownTodo gets a Todo by ID, reporting it as not found if it belongs to another user
+func ownTodo(ctx context.Context, e TodoEndpoints, id string) (Todo, error) {
+	response, err := e.GetByIDEndpoint(ctx, GetByIDRequest{id})
+	if err != nil {
+		return Todo{}, err
+	}
+	todo := response.(GetByIDResponse).Todo
+	if todo.Username != ctx.Value("username").(string) {
+		return Todo{}, ErrNotFound
+	}
+	return todo, nil
+}
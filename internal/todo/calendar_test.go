@@ -0,0 +1,209 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCalendarTokenService tests calendar tokens are random, identify their user & can be regenerated or revoked
+func TestCalendarTokenService(t *testing.T) {
+	s := NewInmemCalendarTokenService()
+	ctx := context.Background()
+
+	token, err := s.Get(ctx, "test@test.com")
+	require.NoError(t, err)
+	again, _ := s.Get(ctx, "test@test.com")
+	require.Equal(t, token, again, "Expected the user's token to be kept")
+	other, _ := s.Get(ctx, "other@test.com")
+	require.NotEqual(t, token, other)
+
+	tenant, username, err := s.Authenticate(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, "test@test.com", username)
+	require.Empty(t, tenant)
+	_, _, err = s.Authenticate(ctx, "made up")
+	require.Equal(t, ErrNotFound, err)
+
+	regenerated, err := s.Regenerate(ctx, "test@test.com")
+	require.NoError(t, err)
+	require.NotEqual(t, token, regenerated)
+	_, _, err = s.Authenticate(ctx, token)
+	require.Equal(t, ErrNotFound, err, "Expected the old token to stop working")
+
+	require.NoError(t, s.Revoke(ctx, "test@test.com"))
+	_, _, err = s.Authenticate(ctx, regenerated)
+	require.Equal(t, ErrNotFound, err)
+	_, _, err = s.Authenticate(ctx, other)
+	require.NoError(t, err, "Expected other users' tokens to be kept")
+
+	acme, _ := s.Get(tenantContext("acme"), "test@test.com")
+	tenant, username, err = s.Authenticate(ctx, acme)
+	require.NoError(t, err)
+	require.Equal(t, "acme", tenant)
+	require.Equal(t, "test@test.com", username)
+}
+
+// newCalendarCall performs a http call against a calendar URL, which doesn't need a JWT
+func newCalendarCall(t *testing.T, method, url, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoErrorf(t, err, "Error creating %s request", method)
+	if body != "" {
+		req.Header.Set("Content-Type", "text/calendar")
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoErrorf(t, err, "Error doing %s request to %s", method, url)
+	return res
+}
+
+// TestCalendarFeedAndCollection tests subscribing to the feed & syncing Todos through the CalDAV collection
+func TestCalendarFeedAndCollection(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	res := newHTTPServerCall(t, http.MethodPost, server.URL+"/api/todos", Todo{Text: "Walk the dog"})
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	todoService.Add(context.Background(), Todo{Username: "other@test.com", Text: "Someone else's"})
+
+	res = newHTTPServerCall(t, http.MethodGet, server.URL+"/api/calendar", nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var links CalendarLinksResponse
+	json.NewDecoder(res.Body).Decode(&links)
+	require.True(t, strings.HasSuffix(links.FeedURL, "/todos.ics"), "Unexpected feed URL %s", links.FeedURL)
+
+	// Feed only has the user's Todos
+	res = newCalendarCall(t, http.MethodGet, server.URL+links.FeedURL, "")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, ICalendarContentType, res.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(res.Body)
+	require.Contains(t, string(body), "SUMMARY:Walk the dog\r\n")
+	require.NotContains(t, string(body), "Someone else's")
+
+	// PUT a new resource
+	ics := "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nUID:new\r\nSUMMARY:Pay rent\r\nDUE;VALUE=DATE:20181101\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	res = newCalendarCall(t, http.MethodPut, server.URL+links.CollectionURL+"new.ics", ics)
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	location := res.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, strings.TrimSuffix(links.CollectionURL, "/")), "Unexpected location %s", location)
+
+	res = newCalendarCall(t, http.MethodGet, server.URL+location, "")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	etag := res.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	body, _ = ioutil.ReadAll(res.Body)
+	require.Contains(t, string(body), "DUE;VALUE=DATE:20181101\r\n")
+
+	// PUT over it to complete it
+	ics = strings.Replace(ics, "SUMMARY:Pay rent", "SUMMARY:Pay rent\r\nSTATUS:COMPLETED", 1)
+	res = newCalendarCall(t, http.MethodPut, server.URL+location, ics)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	require.NotEqual(t, etag, res.Header.Get("ETag"), "Expected the ETag to change")
+
+	id := strings.TrimSuffix(location[strings.LastIndexByte(location, '/')+1:], ".ics")
+	todo, err := todoService.GetByID(context.Background(), "test@test.com", id)
+	require.NoError(t, err)
+	require.True(t, todo.Completed)
+	require.Equal(t, "test@test.com", todo.Username)
+
+	// PROPFIND lists the resources
+	req, _ := http.NewRequest("PROPFIND", server.URL+links.CollectionURL, nil)
+	req.Header.Set("Depth", "1")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	body, _ = ioutil.ReadAll(res.Body)
+	require.Contains(t, string(body), "<d:href>"+location+"</d:href>")
+	require.Equal(t, 3, strings.Count(string(body), "<d:response>"), "Expected the collection & 2 resources")
+
+	// DELETE it
+	res = newCalendarCall(t, http.MethodDelete, server.URL+location, "")
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	_, err = todoService.GetByID(context.Background(), "test@test.com", id)
+	require.Equal(t, ErrNotFound, err)
+}
+
+// TestCalendarIsScopedToTheTokensUser tests a calendar token can't reach other users' Todos
+func TestCalendarIsScopedToTheTokensUser(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	other, _ := todoService.Add(context.Background(), Todo{Username: "other@test.com", Text: "Private"})
+	res := newHTTPServerCall(t, http.MethodGet, server.URL+"/api/calendar", nil)
+	defer res.Body.Close()
+	var links CalendarLinksResponse
+	json.NewDecoder(res.Body).Decode(&links)
+	collection := server.URL + links.CollectionURL
+
+	res = newCalendarCall(t, http.MethodGet, collection+other.ID+".ics", "")
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = newCalendarCall(t, http.MethodDelete, collection+other.ID+".ics", "")
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// PUT to another user's Todo creates a new Todo rather than overwriting it
+	ics := "BEGIN:VTODO\r\nSUMMARY:Overwritten\r\nEND:VTODO\r\n"
+	res = newCalendarCall(t, http.MethodPut, collection+other.ID+".ics", ics)
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	unchanged, err := todoService.GetByID(context.Background(), "other@test.com", other.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Private", unchanged.Text)
+
+	res = newCalendarCall(t, http.MethodGet, server.URL+"/calendar/forged.token/todos.ics", "")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	require.Equal(t, "application/problem+json; charset=utf-8", res.Header.Get("Content-Type"))
+
+	res = newCalendarCall(t, http.MethodPut, collection+"x.ics", "not a calendar")
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+// TestRegenerateCalendarToken tests regenerating or revoking the calendar token stops the old URLs working
+func TestRegenerateCalendarToken(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	res := newHTTPServerCall(t, http.MethodGet, server.URL+"/api/calendar", nil)
+	defer res.Body.Close()
+	var old CalendarLinksResponse
+	json.NewDecoder(res.Body).Decode(&old)
+
+	res = newHTTPServerCall(t, http.MethodPost, server.URL+"/api/calendar/token", nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var links CalendarLinksResponse
+	json.NewDecoder(res.Body).Decode(&links)
+	require.NotEqual(t, old.FeedURL, links.FeedURL)
+
+	res = newCalendarCall(t, http.MethodGet, server.URL+old.FeedURL, "")
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode, "Expected the old feed URL to stop working")
+	res = newCalendarCall(t, http.MethodGet, server.URL+links.FeedURL, "")
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = newHTTPServerCall(t, http.MethodDelete, server.URL+"/api/calendar/token", nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = newCalendarCall(t, http.MethodGet, server.URL+links.FeedURL, "")
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode, "Expected the revoked feed URL to stop working")
+}
@@ -0,0 +1,237 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Media types the API can be spoken in
+const (
+	JSONContentType        = "application/json"
+	MessagePackContentType = "application/msgpack"
+	ProtobufContentType    = "application/protobuf"
+)
+
+// codec transcodes the API's JSON documents to & from another encoding.
+// Every encoding carries the same document, so the JSON API is the reference for all of them.
+// Encodings with a schema, like Protobuf, pick it by v, the Go value the document is encoded from or decoded into.
+type codec struct {
+	contentType string
+	// aliases are other media types clients use for the encoding
+	aliases []string
+	// marshal encodes v's JSON document
+	marshal func(v interface{}, doc []byte) ([]byte, error)
+	// unmarshal decodes data into v's JSON document
+	unmarshal func(v interface{}, data []byte) ([]byte, error)
+}
+
+// codecs are the supported encodings, in order of preference
+var codecs = []*codec{
+	{
+		contentType: JSONContentType,
+		marshal:     func(v interface{}, doc []byte) ([]byte, error) { return doc, nil },
+		unmarshal:   func(v interface{}, data []byte) ([]byte, error) { return data, nil },
+	},
+	{
+		contentType: MessagePackContentType,
+		aliases:     []string{"application/x-msgpack", "application/vnd.msgpack"},
+		marshal:     jsonToMessagePack,
+		unmarshal:   messagePackToJSON,
+	},
+	{
+		contentType: ProtobufContentType,
+		aliases:     []string{"application/x-protobuf", "application/vnd.google.protobuf"},
+		marshal:     jsonToProtobuf,
+		unmarshal:   protobufToJSON,
+	},
+}
+
+func (c *codec) matches(mediaType string) bool {
+	if mediaType == c.contentType {
+		return true
+	}
+	for _, alias := range c.aliases {
+		if mediaType == alias {
+			return true
+		}
+	}
+	return false
+}
+
+func contentTypeNames() string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.contentType
+	}
+	return strings.Join(names, ", ")
+}
+
+// Negotiate picks the codec decoding a request's body from its Content-Type & the codec encoding its response from its Accept header.
+// Requests are refused with StatusUnsupportedMediaType or StatusNotAcceptable when no codec fits.
+// A body without a Content-Type is decoded as JSON, as it was before other encodings were supported.
+func Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errCtx := httptransport.PopulateRequestContext(r.Context(), r)
+		responseCodec := acceptableCodec(r.Header.Get("Accept"))
+		if responseCodec == nil {
+			encodeError(errCtx, &NotAcceptableError{Detail: "Accept must allow one of " + contentTypeNames()}, w)
+			return
+		}
+		ctx := context.WithValue(r.Context(), contextKeyResponseCodec, responseCodec)
+
+		if contentType := r.Header.Get("Content-Type"); contentType != "" && hasBody(r) {
+			mediaType, _, _ := mime.ParseMediaType(contentType)
+			requestCodec := codecFor(mediaType)
+			if requestCodec == nil {
+				encodeError(errCtx, &UnsupportedMediaTypeError{Detail: "Content-Type must be one of " + contentTypeNames()}, w)
+				return
+			}
+			ctx = context.WithValue(ctx, contextKeyRequestCodec, requestCodec)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// hasBody reports whether a request has a body to decode
+func hasBody(r *http.Request) bool {
+	return r.ContentLength > 0 || (r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody)
+}
+
+func codecFor(mediaType string) *codec {
+	for _, c := range codecs {
+		if c.matches(strings.ToLower(mediaType)) {
+			return c
+		}
+	}
+	return nil
+}
+
+// requestCodec returns the codec for a request's body, defaulting to JSON
+func requestCodec(ctx context.Context) *codec {
+	if c, ok := ctx.Value(contextKeyRequestCodec).(*codec); ok {
+		return c
+	}
+	return codecs[0]
+}
+
+// responseCodec returns the codec for a response, defaulting to JSON
+func responseCodec(ctx context.Context) *codec {
+	if c, ok := ctx.Value(contextKeyResponseCodec).(*codec); ok {
+		return c
+	}
+	return codecs[0]
+}
+
+// acceptableCodec picks the codec an Accept header prefers, see RFC 7231 section 5.3.2.
+// A missing header accepts anything. Ties go to the earlier codec.
+func acceptableCodec(accept string) *codec {
+	if strings.TrimSpace(accept) == "" {
+		return codecs[0]
+	}
+
+	type mediaRange struct {
+		mediaType   string
+		q           float64
+		specificity int
+	}
+	var ranges []mediaRange
+	for _, field := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(field)
+		if err != nil {
+			continue
+		}
+		mr := mediaRange{mediaType: mediaType, q: 1, specificity: 2}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			mr.q = q
+		}
+		switch {
+		case mediaType == "*/*":
+			mr.specificity = 0
+		case strings.HasSuffix(mediaType, "/*"):
+			mr.specificity = 1
+		}
+		ranges = append(ranges, mr)
+	}
+	// the most specific range matching a codec sets its quality
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].specificity > ranges[j].specificity })
+
+	var best *codec
+	bestQ := 0.0
+	for _, c := range codecs {
+		for _, mr := range ranges {
+			if mr.specificity == 2 && !c.matches(mr.mediaType) ||
+				mr.specificity == 1 && !strings.HasPrefix(c.contentType, strings.TrimSuffix(mr.mediaType, "*")) {
+				continue
+			}
+			if mr.q > bestQ {
+				best, bestQ = c, mr.q
+			}
+			break
+		}
+	}
+	return best
+}
+
+// jsonToMessagePack re-encodes a JSON document as MessagePack.
+// Map keys are sorted so the same document always encodes the same, keeping ETags stable.
+func jsonToMessagePack(v interface{}, doc []byte) ([]byte, error) {
+	decoded, err := decodeJSONDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	enc := msgpack.NewEncoder(&b)
+	enc.SetSortMapKeys(true)
+	if err := enc.Encode(decoded); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func messagePackToJSON(v interface{}, data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := msgpack.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// decodeJSONDocument decodes a JSON document, keeping whole numbers as integers
+func decodeJSONDocument(doc []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = convertNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = convertNumbers(e)
+		}
+	}
+	return v
+}
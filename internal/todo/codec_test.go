@@ -0,0 +1,177 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// TestAcceptableCodec tests picking a codec from an Accept header
+func TestAcceptableCodec(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", JSONContentType},
+		{"*/*", JSONContentType},
+		{"application/json", JSONContentType},
+		{"application/msgpack", MessagePackContentType},
+		{"application/x-msgpack", MessagePackContentType},
+		{"application/x-protobuf", ProtobufContentType},
+		{"application/*", JSONContentType},
+		{"application/json;q=0.5, application/msgpack", MessagePackContentType},
+		{"text/html, application/x-msgpack;q=0.9, */*;q=0.1", MessagePackContentType},
+		{"application/json;q=0, */*", MessagePackContentType},
+		{"text/html, application/protobuf;q=0.9, */*;q=0.1", ProtobufContentType},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+	}
+	for _, tc := range tests {
+		c := acceptableCodec(tc.accept)
+		if tc.want == "" {
+			require.Nil(t, c, "Expected nothing to be acceptable for %q", tc.accept)
+			continue
+		}
+		require.NotNil(t, c, "Expected a codec for %q", tc.accept)
+		require.Equal(t, tc.want, c.contentType, "Unexpected codec for %q", tc.accept)
+	}
+}
+
+// TestCodecsCarryTheJSONDocument tests every codec round trips a JSON document
+func TestCodecsCarryTheJSONDocument(t *testing.T) {
+	doc := []byte(`{"results":[{"id":"a","status":201,"todo":{"id":"a","text":"Buy milk","completed":true,"tags":["x"],"created_on":"2018-02-03T04:05:06Z"}}]}`)
+	for _, c := range codecs {
+		data, err := c.marshal(BulkResponse{}, doc)
+		require.NoError(t, err, c.contentType)
+		back, err := c.unmarshal(&BulkResponse{}, data)
+		require.NoError(t, err, c.contentType)
+		require.JSONEq(t, string(doc), string(back), c.contentType)
+	}
+
+	// whole numbers stay integers in MessagePack
+	data, err := jsonToMessagePack(BulkResponse{}, doc)
+	require.NoError(t, err)
+	var v struct {
+		Results []map[string]interface{} `msgpack:"results"`
+	}
+	require.NoError(t, msgpack.Unmarshal(data, &v))
+	require.IsType(t, int64(0), v.Results[0]["status"])
+}
+
+// newNegotiatedCall performs a http call with the body already encoded in contentType
+func newNegotiatedCall(t *testing.T, method, url, contentType, accept string, body []byte) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	require.NoErrorf(t, err, "Error creating %s request", method)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("Authorization", newJWTToken(t))
+	res, err := http.DefaultClient.Do(req)
+	require.NoErrorf(t, err, "Error doing %s request to %s", method, url)
+	return res
+}
+
+// TestContentNegotiation tests talking to the API in MessagePack
+func TestContentNegotiation(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	// Create in MessagePack, reply in JSON
+	body, err := msgpack.Marshal(map[string]interface{}{"text": "Packed", "tags": []string{"binary"}})
+	require.NoError(t, err)
+	res := newNegotiatedCall(t, http.MethodPost, server.URL+"/api/todos", MessagePackContentType, JSONContentType, body)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, JSONContentType+"; charset=utf-8", res.Header.Get("Content-Type"))
+
+	var added AddResponse
+	json.NewDecoder(res.Body).Decode(&added)
+	require.Equal(t, "Packed", added.Todo.Text)
+	id := added.Todo.ID
+	require.NotEmpty(t, id)
+
+	// Update in MessagePack, by an alias
+	body, err = msgpack.Marshal(map[string]interface{}{"id": id, "text": "Repacked", "completed": true})
+	require.NoError(t, err)
+	res = newNegotiatedCall(t, http.MethodPut, server.URL+"/api/todos/"+id, "application/x-msgpack", "*/*", body)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Read back in MessagePack
+	res = newNegotiatedCall(t, http.MethodGet, server.URL+"/api/todos/"+id, "", "application/msgpack", nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, MessagePackContentType, res.Header.Get("Content-Type"))
+	var getByIDResponse map[string]map[string]interface{}
+	data, _ := ioutil.ReadAll(res.Body)
+	require.NoError(t, msgpack.Unmarshal(data, &getByIDResponse))
+	require.Equal(t, "Repacked", getByIDResponse["todo"]["text"])
+	require.Equal(t, true, getByIDResponse["todo"]["completed"])
+
+	// Unknown fields are still reported in other encodings
+	body, _ = msgpack.Marshal(map[string]interface{}{"text": "x", "colour": "red"})
+	res = newNegotiatedCall(t, http.MethodPost, server.URL+"/api/todos", MessagePackContentType, "", body)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	var problem Problem
+	json.NewDecoder(res.Body).Decode(&problem)
+	require.Equal(t, []FieldError{{"colour", "is not a known field"}}, problem.Errors)
+}
+
+// TestMissingContentTypeIsJSON tests clients which don't send a Content-Type are still understood
+func TestMissingContentTypeIsJSON(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	res := newNegotiatedCall(t, http.MethodPost, server.URL+"/api/todos", "", "", []byte(`{"text": "Untyped"}`))
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var added AddResponse
+	json.NewDecoder(res.Body).Decode(&added)
+	require.Equal(t, "Untyped", added.Todo.Text)
+}
+
+// TestUnsupportedMediaTypes tests requests which can't be decoded or answered are refused
+func TestUnsupportedMediaTypes(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	res := newNegotiatedCall(t, http.MethodGet, server.URL+"/api/todos", "", "text/html", nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusNotAcceptable, res.StatusCode)
+	require.Equal(t, ProblemContentType+"; charset=utf-8", res.Header.Get("Content-Type"))
+	var problem Problem
+	json.NewDecoder(res.Body).Decode(&problem)
+	require.Equal(t, "/api/todos", problem.Instance)
+
+	res = newNegotiatedCall(t, http.MethodPost, server.URL+"/api/todos", "application/xml", "", []byte("<todo><text>x</text></todo>"))
+	res.Body.Close()
+	require.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+
+	res = newNegotiatedCall(t, http.MethodPost, server.URL+"/api/todos", ProtobufContentType, "", []byte{0xff})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode, "Expected malformed Protobuf to be a bad request")
+
+	res = newNegotiatedCall(t, http.MethodPost, server.URL+"/api/todos", MessagePackContentType, "", []byte{0xc1})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode, "Expected malformed MessagePack to be a bad request")
+
+	// nothing was created
+	todos, err := todoService.GetAllForUser(context.Background(), "test@test.com")
+	require.NoError(t, err)
+	require.Empty(t, todos)
+
+	// exports keep their own formats
+	res = newNegotiatedCall(t, http.MethodGet, server.URL+"/api/todos/export?format=csv", "", "text/csv", nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
@@ -0,0 +1,323 @@
+package todo
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultJWTSecret is used when JWT_SECRET isn't set. It's only fit for development.
+const DefaultJWTSecret = "SECRET_SSSHHHHHHH"
+
+// redacted replaces secrets when a Config is printed
+const redacted = "REDACTED"
+
+// Config is the service's configuration. It's loaded once at startup by LoadConfig.
+// Each setting is read from the config file by its yaml key, from the environment by its env variable
+// & from the command line by its yaml key, with hyphens for underscores, e.g. -jwt-secret.
+// Settings tagged secret are redacted when printed.
+type Config struct {
+	Port      string `yaml:"port" env:"PORT" help:"port the API listens on"`
+	AdminPort string `yaml:"admin_port" env:"ADMIN_PORT" help:"port serving /metrics, which shouldn't be exposed publicly"`
+	DevMode   bool   `yaml:"dev_mode" env:"DEV_MODE" help:"allow insecure defaults, for development"`
+	Debug     bool   `yaml:"debug" env:"DEBUG" help:"log every service call & client error"`
+	LogFormat string `yaml:"log_format" env:"LOG_FORMAT" help:"format of log lines, logfmt or json"`
+
+	SnapshotFile string `yaml:"snapshot_file" env:"SNAPSHOT_FILE" help:"file the in memory stores are saved to on shutdown & restored from at startup. Nothing is kept across restarts when unset"`
+	TenantsFile  string `yaml:"tenants_file" env:"TENANTS_FILE" help:"JSON file configuring the tenants hosted. Any tenant is accepted when unset"`
+
+	JWTSecret           string        `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true" help:"secret HMAC signed JWTs are signed with"`
+	JWTIssuer           string        `yaml:"jwt_issuer" env:"JWT_ISSUER" help:"issuer JWTs must have, if set"`
+	JWTAudience         string        `yaml:"jwt_audience" env:"JWT_AUDIENCE" help:"audience JWTs must have, if set"`
+	JWTLeeway           time.Duration `yaml:"jwt_leeway" env:"JWT_LEEWAY" help:"clock skew allowed when checking exp & nbf"`
+	JWTAlgorithms       []string      `yaml:"jwt_algorithms" env:"JWT_ALGORITHMS" help:"comma separated signing algorithms JWTs may use"`
+	JWTRolesClaim       string        `yaml:"jwt_roles_claim" env:"JWT_ROLES_CLAIM" help:"claim holding the user's roles"`
+	JWTTenantClaim      string        `yaml:"jwt_tenant_claim" env:"JWT_TENANT_CLAIM" help:"claim holding the user's tenant"`
+	JWKSURL             string        `yaml:"jwks_url" env:"JWKS_URL" help:"JSON Web Key Set URL of the public keys JWTs are signed with"`
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval" env:"JWKS_REFRESH_INTERVAL" help:"how often the JSON Web Key Set is refreshed"`
+	JWTPublicKeysFile   string        `yaml:"jwt_public_keys_file" env:"JWT_PUBLIC_KEYS_FILE" help:"PEM bundle of public keys JWTs are signed with"`
+
+	AccountsEnabled bool          `yaml:"accounts_enabled" env:"ACCOUNTS_ENABLED" help:"register users & issue their JWTs"`
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" env:"ACCESS_TOKEN_TTL" help:"how long issued JWTs are valid for"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" env:"REFRESH_TOKEN_TTL" help:"how long refresh tokens are valid for"`
+	IdempotencyTTL  time.Duration `yaml:"idempotency_ttl" env:"IDEMPOTENCY_TTL" help:"how long responses are kept for replay against an Idempotency-Key"`
+
+	ReadTimeout     time.Duration `yaml:"read_timeout" env:"READ_TIMEOUT" help:"how long a client has to send a request"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"WRITE_TIMEOUT" help:"how long a request has to be handled & its response written"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" env:"IDLE_TIMEOUT" help:"how long an idle keep-alive connection is kept open"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"how long in-flight requests are given to finish on shutdown"`
+	MaxHeaderBytes  int           `yaml:"max_header_bytes" env:"MAX_HEADER_BYTES" help:"largest size of a request's headers"`
+	MaxBodyBytes    int64         `yaml:"max_body_bytes" env:"MAX_BODY_BYTES" help:"largest size of any request's body"`
+
+	OTLPEndpoint     string  `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" help:"URL of the OTLP/HTTP collector spans are exported to. Tracing is off when unset"`
+	ServiceName      string  `yaml:"service_name" env:"OTEL_SERVICE_NAME" help:"name spans are reported under"`
+	TraceSampleRatio float64 `yaml:"trace_sample_ratio" env:"TRACE_SAMPLE_RATIO" help:"fraction of new traces sampled, from 0 to 1"`
+}
+
+// DefaultConfig is the configuration used for anything which isn't set
+func DefaultConfig() Config {
+	return Config{
+		Port:                "8000",
+		AdminPort:           "9090",
+		LogFormat:           "logfmt",
+		JWTSecret:           DefaultJWTSecret,
+		JWTLeeway:           time.Minute,
+		JWTAlgorithms:       []string{"HS256"},
+		JWTRolesClaim:       "roles",
+		JWTTenantClaim:      "tenant",
+		JWKSRefreshInterval: 15 * time.Minute,
+		AccessTokenTTL:      15 * time.Minute,
+		RefreshTokenTTL:     30 * 24 * time.Hour,
+		IdempotencyTTL:      24 * time.Hour,
+		ReadTimeout:         15 * time.Second,
+		WriteTimeout:        30 * time.Second,
+		IdleTimeout:         2 * time.Minute,
+		ShutdownTimeout:     20 * time.Second,
+		MaxHeaderBytes:      1 << 20,
+		MaxBodyBytes:        8 << 20,
+		ServiceName:         "TodoService",
+		TraceSampleRatio:    1,
+	}
+}
+
+// LoadConfig loads the configuration from, in increasing precedence, its defaults, a YAML or TOML config file,
+// the environment, read with getenv, & the command line args. The config file is named by the -config flag
+// or the CONFIG_FILE environment variable. The loaded configuration is validated.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	c := DefaultConfig()
+
+	fs := flag.NewFlagSet("TodoService", flag.ContinueOnError)
+	file := fs.String("config", getenv("CONFIG_FILE"), "YAML or TOML config file")
+	flags := map[string]*settingFlag{}
+	c.each(func(field reflect.StructField, v reflect.Value) {
+		name := flagName(field)
+		flags[name] = &settingFlag{isBool: v.Kind() == reflect.Bool}
+		fs.Var(flags[name], name, field.Tag.Get("help")+" ("+field.Tag.Get("env")+")")
+	})
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if fs.NArg() > 0 {
+		return c, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *file != "" {
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return c, fmt.Errorf("reading config file: %s", err)
+		}
+		if err := decodeConfigFile(*file, data, &c); err != nil {
+			return c, fmt.Errorf("reading config file %s: %s", *file, err)
+		}
+	}
+
+	var errs []string
+	c.each(func(field reflect.StructField, v reflect.Value) {
+		if s := getenv(field.Tag.Get("env")); s != "" {
+			if err := setField(v, s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", field.Tag.Get("env"), err))
+			}
+		}
+	})
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	c.each(func(field reflect.StructField, v reflect.Value) {
+		if name := flagName(field); set[name] {
+			if err := setField(v, flags[name].value); err != nil {
+				errs = append(errs, fmt.Sprintf("-%s: %s", name, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return c, errors.New(strings.Join(errs, "; "))
+	}
+	return c, c.Validate()
+}
+
+// settingFlag holds a setting's command line value, which is parsed once the file & environment have been read
+type settingFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *settingFlag) String() string {
+	return f.value
+}
+
+// Set implements flag.Value
+func (f *settingFlag) Set(s string) error {
+	f.value = s
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be set without a value, e.g. -debug
+func (f *settingFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// decodeConfigFile reads a YAML or TOML config file into c, by the extension of its name,
+// rejecting unknown keys so typos aren't silently ignored.
+// TOML is converted to YAML, so both formats share the yaml keys & their checks.
+func decodeConfigFile(name string, data []byte, c *Config) error {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		var settings map[string]interface{}
+		if _, err := toml.Decode(string(data), &settings); err != nil {
+			return err
+		}
+		converted, err := yaml.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		data = converted
+	default:
+		return errors.New("config file must be .yaml, .yml or .toml")
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Validate reports every setting the service can't start with
+func (c Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	for _, port := range []struct{ name, value string }{{"PORT", c.Port}, {"ADMIN_PORT", c.AdminPort}} {
+		n, err := strconv.Atoi(port.value)
+		check(err == nil && n > 0 && n < 1<<16, "%s %q isn't a port number", port.name, port.value)
+	}
+	check(c.Port != c.AdminPort, "PORT & ADMIN_PORT must differ")
+	check(c.LogFormat == "logfmt" || c.LogFormat == "json", "LOG_FORMAT %q must be logfmt or json", c.LogFormat)
+	check(c.JWTSecret != "", "JWT_SECRET mustn't be empty")
+	check(c.JWTLeeway >= 0, "JWT_LEEWAY mustn't be negative")
+	check(len(c.JWTAlgorithms) > 0, "JWT_ALGORITHMS must list at least one algorithm")
+	check(c.JWTRolesClaim != "", "JWT_ROLES_CLAIM mustn't be empty")
+	check(c.JWTTenantClaim != "", "JWT_TENANT_CLAIM mustn't be empty")
+	check(c.JWKSURL == "" || c.JWTPublicKeysFile == "", "only one of JWKS_URL & JWT_PUBLIC_KEYS_FILE can be set")
+	// accounts issue tokens without a tenant, which only the default tenant accepts
+	check(!c.AccountsEnabled || c.TenantsFile == "", "ACCOUNTS_ENABLED can't be used with TENANTS_FILE")
+	if c.JWKSURL != "" {
+		u, err := url.Parse(c.JWKSURL)
+		check(err == nil && (u.Scheme == "https" || u.Scheme == "http"), "JWKS_URL %q isn't a http(s) URL", c.JWKSURL)
+	}
+	if c.OTLPEndpoint != "" {
+		u, err := url.Parse(c.OTLPEndpoint)
+		check(err == nil && (u.Scheme == "https" || u.Scheme == "http"), "OTEL_EXPORTER_OTLP_ENDPOINT %q isn't a http(s) URL", c.OTLPEndpoint)
+	}
+	for name, d := range map[string]time.Duration{
+		"JWKS_REFRESH_INTERVAL": c.JWKSRefreshInterval,
+		"ACCESS_TOKEN_TTL":      c.AccessTokenTTL,
+		"REFRESH_TOKEN_TTL":     c.RefreshTokenTTL,
+		"IDEMPOTENCY_TTL":       c.IdempotencyTTL,
+		"READ_TIMEOUT":          c.ReadTimeout,
+		"WRITE_TIMEOUT":         c.WriteTimeout,
+		"IDLE_TIMEOUT":          c.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":      c.ShutdownTimeout,
+	} {
+		check(d > 0, "%s must be positive", name)
+	}
+	check(c.MaxHeaderBytes > 0, "MAX_HEADER_BYTES must be positive")
+	check(c.MaxBodyBytes > 0, "MAX_BODY_BYTES must be positive")
+	check(c.TraceSampleRatio >= 0 && c.TraceSampleRatio <= 1, "TRACE_SAMPLE_RATIO must be from 0 to 1")
+
+	if len(errs) == 0 {
+		return nil
+	}
+	// map iteration order isn't stable, so errors are sorted to be reproducible
+	sort.Strings(errs)
+	return errors.New("invalid config: " + strings.Join(errs, "; "))
+}
+
+// Redacted returns a copy of c with its secrets replaced, so it can be printed
+func (c Config) Redacted() Config {
+	c.each(func(field reflect.StructField, v reflect.Value) {
+		if field.Tag.Get("secret") == "true" && v.String() != "" {
+			v.SetString(redacted)
+		}
+	})
+	return c
+}
+
+// Print writes the configuration as YAML, with its secrets redacted
+func (c Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	defer encoder.Close()
+	return encoder.Encode(c.Redacted())
+}
+
+// each calls fn with every setting's field & its value in c
+func (c *Config) each(fn func(field reflect.StructField, v reflect.Value)) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fn(v.Type().Field(i), v.Field(i))
+	}
+}
+
+// flagName is the command line flag for a setting, e.g. -jwt-secret
+func flagName(field reflect.StructField) string {
+	return strings.Replace(field.Tag.Get("yaml"), "_", "-", -1)
+}
+
+// setField parses s into a setting's value, by its type
+func setField(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q isn't true or false", s)
+		}
+		v.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%q isn't a duration, e.g. 15m", s)
+		}
+		v.SetInt(int64(d))
+	case int, int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't a whole number", s)
+		}
+		v.SetInt(n)
+	case float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't a number", s)
+		}
+		v.SetFloat(f)
+	case []string:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package todo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// env creates a getenv reading from a map, so tests don't depend on the real environment
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+// writeConfigFile writes a YAML config file for a test
+func writeConfigFile(t *testing.T, yaml string) string {
+	return writeConfigFileNamed(t, "config.yaml", yaml)
+}
+
+// writeConfigFileNamed writes a config file for a test, whose format is decided by name's extension
+func writeConfigFileNamed(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+// TestConfigDefaults checks the defaults are used when nothing is set
+func TestConfigDefaults(t *testing.T) {
+	c, err := LoadConfig(nil, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "8000", c.Port)
+	assert.Equal(t, DefaultJWTSecret, c.JWTSecret)
+	assert.Empty(t, c.SnapshotFile)
+	assert.False(t, c.Debug)
+	assert.False(t, c.DevMode)
+	assert.False(t, c.AccountsEnabled)
+	assert.Equal(t, 24*time.Hour, c.IdempotencyTTL)
+	assert.Equal(t, time.Minute, c.JWTLeeway)
+	assert.Equal(t, []string{"HS256"}, c.JWTAlgorithms)
+	assert.Equal(t, 15*time.Minute, c.AccessTokenTTL)
+	assert.Equal(t, 30*24*time.Hour, c.RefreshTokenTTL)
+}
+
+// TestConfigEnvSet checks settings are read from their environment variables
+func TestConfigEnvSet(t *testing.T) {
+	c, err := LoadConfig(nil, env(map[string]string{
+		"PORT":             "7474",
+		"JWT_SECRET":       "3rd secret of fatima",
+		"SNAPSHOT_FILE":    "/data/snapshot.json",
+		"DEBUG":            "true",
+		"IDEMPOTENCY_TTL":  "90m",
+		"JWT_LEEWAY":       "5s",
+		"JWT_ALGORITHMS":   "HS256, HS512,",
+		"ACCESS_TOKEN_TTL": "5m",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "7474", c.Port)
+	assert.Equal(t, "3rd secret of fatima", c.JWTSecret)
+	assert.Equal(t, "/data/snapshot.json", c.SnapshotFile)
+	assert.True(t, c.Debug)
+	assert.Equal(t, 90*time.Minute, c.IdempotencyTTL)
+	assert.Equal(t, 5*time.Second, c.JWTLeeway)
+	assert.Equal(t, []string{"HS256", "HS512"}, c.JWTAlgorithms)
+	assert.Equal(t, 5*time.Minute, c.AccessTokenTTL)
+}
+
+// TestConfigPrecedence checks flags override the environment, which overrides the config file
+func TestConfigPrecedence(t *testing.T) {
+	file := writeConfigFile(t, `
+port: "7000"
+admin_port: "7001"
+log_format: json
+jwt_algorithms: [HS256, RS256]
+jwt_public_keys_file: keys.pem
+write_timeout: 1m
+`)
+	c, err := LoadConfig([]string{"-port", "9000", "-debug"}, env(map[string]string{
+		"CONFIG_FILE": file,
+		"PORT":        "8080",
+		"ADMIN_PORT":  "8081",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "9000", c.Port, "Expected the flag to win")
+	assert.Equal(t, "8081", c.AdminPort, "Expected the environment to win")
+	assert.Equal(t, "json", c.LogFormat, "Expected the file to be read")
+	assert.Equal(t, []string{"HS256", "RS256"}, c.JWTAlgorithms)
+	assert.Equal(t, time.Minute, c.WriteTimeout)
+	assert.Equal(t, 15*time.Second, c.ReadTimeout, "Expected settings missing from the file to keep their defaults")
+	assert.True(t, c.Debug)
+
+	c, err = LoadConfig([]string{"-config", writeConfigFile(t, "port: \"6000\"\n")}, env(map[string]string{"CONFIG_FILE": file}))
+	require.NoError(t, err)
+	assert.Equal(t, "6000", c.Port, "Expected -config to override CONFIG_FILE")
+
+	toml := writeConfigFileNamed(t, "config.toml", `
+port = "7000"
+log_format = "json"
+jwt_algorithms = ["HS256", "RS256"]
+jwt_public_keys_file = "keys.pem"
+write_timeout = "1m"
+max_body_bytes = 1024
+trace_sample_ratio = 0.5
+`)
+	c, err = LoadConfig(nil, env(map[string]string{"CONFIG_FILE": toml, "LOG_FORMAT": "logfmt"}))
+	require.NoError(t, err)
+	assert.Equal(t, "7000", c.Port, "Expected a TOML file to be read")
+	assert.Equal(t, "logfmt", c.LogFormat, "Expected the environment to override a TOML file")
+	assert.Equal(t, []string{"HS256", "RS256"}, c.JWTAlgorithms)
+	assert.Equal(t, time.Minute, c.WriteTimeout)
+	assert.Equal(t, int64(1024), c.MaxBodyBytes)
+	assert.Equal(t, 0.5, c.TraceSampleRatio)
+	assert.Equal(t, 15*time.Second, c.ReadTimeout)
+}
+
+// TestConfigInvalid checks every invalid setting is reported at startup
+func TestConfigInvalid(t *testing.T) {
+	_, err := LoadConfig(nil, env(map[string]string{"DEBUG": "yes", "JWT_LEEWAY": "soon"}))
+	require.EqualError(t, err, `DEBUG: "yes" isn't true or false; JWT_LEEWAY: "soon" isn't a duration, e.g. 15m`)
+
+	_, err = LoadConfig([]string{"-port", "http"}, env(map[string]string{
+		"LOG_FORMAT":         "xml",
+		"JWKS_URL":           "ftp://keys",
+		"TRACE_SAMPLE_RATIO": "2",
+		"ACCOUNTS_ENABLED":   "true",
+		"TENANTS_FILE":       "tenants.json",
+	}))
+	require.Error(t, err)
+	for _, msg := range []string{
+		`PORT "http" isn't a port number`,
+		`LOG_FORMAT "xml" must be logfmt or json`,
+		`JWKS_URL "ftp://keys" isn't a http(s) URL`,
+		`TRACE_SAMPLE_RATIO must be from 0 to 1`,
+		`ACCOUNTS_ENABLED can't be used with TENANTS_FILE`,
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+
+	_, err = LoadConfig([]string{"-config", writeConfigFile(t, "prot: \"7000\"\n")}, env(nil))
+	require.Error(t, err, "Expected a misspelt key to be rejected")
+	_, err = LoadConfig([]string{"-config", writeConfigFileNamed(t, "config.toml", "prot = \"7000\"\n")}, env(nil))
+	require.Error(t, err, "Expected a misspelt TOML key to be rejected")
+	_, err = LoadConfig([]string{"-config", writeConfigFileNamed(t, "config.json", "{}")}, env(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "config file must be .yaml, .yml or .toml")
+	_, err = LoadConfig([]string{"-config", filepath.Join(os.TempDir(), "missing.yaml")}, env(nil))
+	require.Error(t, err)
+	_, err = LoadConfig([]string{"-unknown"}, env(nil))
+	require.Error(t, err)
+}
+
+// TestConfigPrint checks the effective config is printed with its secrets redacted
+func TestConfigPrint(t *testing.T) {
+	c, err := LoadConfig(nil, env(map[string]string{"JWT_SECRET": "3rd secret of fatima", "JWT_ISSUER": "issuer"}))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, c.Print(&b))
+	assert.NotContains(t, b.String(), "3rd secret of fatima")
+	assert.NotContains(t, b.String(), "Sinnott@localhost")
+	assert.Contains(t, b.String(), "jwt_secret: REDACTED")
+	assert.Contains(t, b.String(), "jwt_issuer: issuer")
+	assert.Contains(t, b.String(), "idempotency_ttl: 24h0m0s")
+	assert.Equal(t, "3rd secret of fatima", c.JWTSecret, "Expected the config itself to keep its secrets")
+
+	// the printed config can be loaded back, apart from its secrets
+	printed := DefaultConfig()
+	require.NoError(t, decodeConfigFile("config.yaml", b.Bytes(), &printed))
+	assert.Equal(t, c.Redacted(), printed)
+}
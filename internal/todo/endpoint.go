@@ -2,32 +2,97 @@ package todo
 
 import (
 	"context"
+	"net/http"
+	"strings"
 
 	"github.com/go-kit/kit/endpoint"
 )
 
 // Endpoints collects all endpoints which compose the Todo service
 type TodoEndpoints struct {
-	GetAllForUserEndPoint endpoint.Endpoint
-	GetByIDEndpoint       endpoint.Endpoint
-	AddEndpoint           endpoint.Endpoint
-	UpdateEndpoint        endpoint.Endpoint
-	DeleteEndpoint        endpoint.Endpoint
+	GetAllForUserEndPoint  endpoint.Endpoint
+	GetByIDEndpoint        endpoint.Endpoint
+	AddEndpoint            endpoint.Endpoint
+	UpdateEndpoint         endpoint.Endpoint
+	DeleteEndpoint         endpoint.Endpoint
+	BulkAddEndpoint        endpoint.Endpoint
+	BulkUpdateEndpoint     endpoint.Endpoint
+	BulkDeleteEndpoint     endpoint.Endpoint
+	BatchEndpoint          endpoint.Endpoint
+	SearchEndpoint         endpoint.Endpoint
+	GetViewsEndpoint       endpoint.Endpoint
+	GetViewEndpoint        endpoint.Endpoint
+	AddViewEndpoint        endpoint.Endpoint
+	DeleteViewEndpoint     endpoint.Endpoint
+	ExportEndpoint         endpoint.Endpoint
+	ImportEndpoint         endpoint.Endpoint
+	CalendarGetEndpoint    endpoint.Endpoint
+	CalendarPutEndpoint    endpoint.Endpoint
+	CalendarDeleteEndpoint endpoint.Endpoint
+	GetUsersEndpoint       endpoint.Endpoint
+	GetUserEndpoint        endpoint.Endpoint
+	DeleteUserEndpoint     endpoint.Endpoint
+	ShareEndpoint          endpoint.Endpoint
+	GetSharesEndpoint      endpoint.Endpoint
+	AcceptShareEndpoint    endpoint.Endpoint
+	DeleteShareEndpoint    endpoint.Endpoint
+	SharedWithMeEndpoint   endpoint.Endpoint
+	AssignEndpoint         endpoint.Endpoint
+	AssignedToMeEndpoint   endpoint.Endpoint
+	AddToViewEndpoint      endpoint.Endpoint
 }
 
 // MakeTodoEndpoints returns an Endpoints struct where each endpoint invokes
-// the corresponding method on the provided Todo
-func MakeTodoEndpoints(s TodoService) TodoEndpoints {
-	return TodoEndpoints{
-		GetAllForUserEndPoint: MakeGetAllForUserEndpoint(s),
-		GetByIDEndpoint:       MakeGetByIDEndpoint(s),
-		AddEndpoint:           MakeAddEndpoint(s),
-		UpdateEndpoint:        MakeUpdateEndpoint(s),
-		DeleteEndpoint:        MakeDeleteEndpoint(s),
+// the corresponding method on the provided Todo.
+// Requests are validated before they reach the service, & API keys must have the scope the endpoint needs.
+// Endpoints reaching other users' data need the support or admin role, & only the user themselves can share their data.
+// Deleting a user also deletes what each of users keeps for them, e.g. their API keys & Account.
+func MakeTodoEndpoints(s TodoService, users ...UserDeleter) TodoEndpoints {
+	validate := ValidatingMiddleware()
+	read := RequireScope(ScopeTodosRead)
+	write := RequireScope(ScopeTodosWrite)
+	support := RequireRole(RoleSupport, RoleAdmin)
+	admin := RequireRole(RoleAdmin)
+	session := RequireSession()
+	e := TodoEndpoints{
+		GetAllForUserEndPoint: read(validate(MakeGetAllForUserEndpoint(s))),
+		GetByIDEndpoint:       read(validate(MakeGetByIDEndpoint(s))),
+		AddEndpoint:           write(validate(MakeAddEndpoint(s))),
+		UpdateEndpoint:        write(validate(MakeUpdateEndpoint(s))),
+		DeleteEndpoint:        write(validate(MakeDeleteEndpoint(s))),
+		BulkAddEndpoint:       write(validate(MakeBulkAddEndpoint(s))),
+		BulkUpdateEndpoint:    write(validate(MakeBulkUpdateEndpoint(s))),
+		BulkDeleteEndpoint:    write(validate(MakeBulkDeleteEndpoint(s))),
+		SearchEndpoint:        read(validate(MakeSearchEndpoint(s))),
+		GetViewsEndpoint:      read(validate(MakeGetViewsEndpoint(s))),
+		GetViewEndpoint:       read(validate(MakeGetViewEndpoint(s))),
+		AddViewEndpoint:       write(validate(MakeAddViewEndpoint(s))),
+		DeleteViewEndpoint:    write(validate(MakeDeleteViewEndpoint(s))),
+		ExportEndpoint:        read(validate(MakeExportEndpoint(s))),
+		ImportEndpoint:        write(validate(MakeImportEndpoint(s))),
+		GetUsersEndpoint:      support(MakeGetUsersEndpoint(s)),
+		GetUserEndpoint:       support(MakeGetUserEndpoint(s)),
+		DeleteUserEndpoint:    admin(MakeDeleteUserEndpoint(s, users...)),
+		ShareEndpoint:         session(validate(MakeShareEndpoint(s))),
+		GetSharesEndpoint:     session(MakeGetSharesEndpoint(s)),
+		AcceptShareEndpoint:   session(MakeAcceptShareEndpoint(s)),
+		DeleteShareEndpoint:   session(MakeDeleteShareEndpoint(s)),
+		SharedWithMeEndpoint:  read(MakeGetSharedWithMeEndpoint(s)),
+		AssignEndpoint:        write(validate(MakeAssignEndpoint(s))),
+		AssignedToMeEndpoint:  read(MakeGetAssignedToMeEndpoint(s)),
+		AddToViewEndpoint:     write(validate(MakeAddToViewEndpoint(s))),
 	}
+	// batches, like calendar requests, go through the endpoints above so each operation is checked for its scope
+	e.BatchEndpoint = validate(MakeBatchEndpoint(e))
+	e.CalendarGetEndpoint = MakeCalendarGetEndpoint(e)
+	e.CalendarPutEndpoint = MakeCalendarPutEndpoint(e)
+	e.CalendarDeleteEndpoint = MakeCalendarDeleteEndpoint(e)
+	return e
 }
 
+// GetAllForUserRequest optionally narrows a user's Todos with a smart filter query
 type GetAllForUserRequest struct {
+	Query Expr
 }
 
 type GetAllForUserResponse struct {
@@ -36,7 +101,12 @@ type GetAllForUserResponse struct {
 
 func MakeGetAllForUserEndpoint(s TodoService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetAllForUserRequest)
 		username := ctx.Value("username").(string)
+		if req.Query != nil {
+			todos, err := s.Query(ctx, username, req.Query)
+			return GetAllForUserResponse{todos}, err
+		}
 		todos, err := s.GetAllForUser(ctx, username)
 		return GetAllForUserResponse{todos}, err
 	}
@@ -53,13 +123,14 @@ type GetByIDResponse struct {
 func MakeGetByIDEndpoint(s TodoService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(GetByIDRequest)
-		todo, err := s.GetByID(ctx, req.ID)
+		todo, err := s.GetByID(ctx, ctx.Value("username").(string), req.ID)
 		return GetByIDResponse{todo}, err
 	}
 }
 
 type AddRequest struct {
-	Todo Todo
+	Todo          Todo
+	unknownFields []string
 }
 
 type AddResponse struct {
@@ -69,14 +140,16 @@ type AddResponse struct {
 func MakeAddEndpoint(s TodoService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(AddRequest)
+		req.Todo.Username = ctx.Value("username").(string)
 		todo, err := s.Add(ctx, req.Todo)
 		return AddResponse{todo}, err
 	}
 }
 
 type UpdateRequest struct {
-	ID   string
-	Todo Todo
+	ID            string
+	Todo          Todo
+	unknownFields []string
 }
 
 type UpdateResponse struct {
@@ -85,7 +158,7 @@ type UpdateResponse struct {
 func MakeUpdateEndpoint(s TodoService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(UpdateRequest)
-		err := s.Update(ctx, req.ID, req.Todo)
+		err := s.Update(ctx, ctx.Value("username").(string), req.ID, req.Todo)
 		return UpdateResponse{}, err
 	}
 }
@@ -100,7 +173,279 @@ type DeleteResponse struct {
 func MakeDeleteEndpoint(s TodoService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(DeleteRequest)
-		err := s.Delete(ctx, req.ID)
+		err := s.Delete(ctx, ctx.Value("username").(string), req.ID)
 		return DeleteResponse{}, err
 	}
 }
+
+type BulkAddRequest struct {
+	Todos         []Todo
+	unknownFields []string
+}
+
+// BulkResponse holds the outcome of a bulk operation for each Todo it affected
+type BulkResponse struct {
+	Results []BulkResult `json:"results"`
+}
+
+func MakeBulkAddEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(BulkAddRequest)
+		username := ctx.Value("username").(string)
+		for i := range req.Todos {
+			req.Todos[i].Username = username
+		}
+		todos, err := s.AddMany(ctx, req.Todos)
+		if err != nil {
+			return nil, err
+		}
+		results := make([]BulkResult, 0, len(todos))
+		for i := range todos {
+			results = append(results, BulkResult{ID: todos[i].ID, Status: http.StatusCreated, Todo: &todos[i]})
+		}
+		return BulkResponse{results}, nil
+	}
+}
+
+type BulkUpdateRequest struct {
+	Filter        Filter
+	Set           TodoPatch
+	unknownFields []string
+}
+
+func MakeBulkUpdateEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(BulkUpdateRequest)
+		username := ctx.Value("username").(string)
+		results, err := s.UpdateMany(ctx, username, req.Filter, req.Set)
+		return BulkResponse{results}, err
+	}
+}
+
+type BulkDeleteRequest struct {
+	Filter Filter
+}
+
+func MakeBulkDeleteEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(BulkDeleteRequest)
+		username := ctx.Value("username").(string)
+		results, err := s.DeleteMany(ctx, username, req.Filter)
+		return BulkResponse{results}, err
+	}
+}
+
+type SearchRequest struct {
+	Query string
+}
+
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+}
+
+func MakeSearchEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SearchRequest)
+		username := ctx.Value("username").(string)
+		results, err := s.Search(ctx, username, req.Query)
+		return SearchResponse{results}, err
+	}
+}
+
+type GetViewsRequest struct {
+}
+
+type GetViewsResponse struct {
+	Views []View `json:"views"`
+}
+
+func MakeGetViewsEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		username := ctx.Value("username").(string)
+		views, err := s.GetViewsForUser(ctx, username)
+		return GetViewsResponse{views}, err
+	}
+}
+
+type GetViewRequest struct {
+	ID string
+}
+
+// GetViewResponse holds a View along with the Todos currently matching its query
+type GetViewResponse struct {
+	View  View   `json:"view"`
+	Todos []Todo `json:"todos"`
+}
+
+func MakeGetViewEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetViewRequest)
+		username := ctx.Value("username").(string)
+		view, err := s.GetView(ctx, username, req.ID)
+		if err != nil {
+			return nil, err
+		}
+		query, err := view.parsedQuery()
+		if err != nil {
+			return nil, err
+		}
+		todos, err := s.Query(ctx, username, query)
+		return GetViewResponse{view, todos}, err
+	}
+}
+
+type AddViewRequest struct {
+	View          View
+	unknownFields []string
+}
+
+type AddViewResponse struct {
+	View View `json:"view"`
+}
+
+func MakeAddViewEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AddViewRequest)
+		req.View.Username = ctx.Value("username").(string)
+		view, err := s.AddView(ctx, req.View)
+		return AddViewResponse{view}, err
+	}
+}
+
+type DeleteViewRequest struct {
+	ID string
+}
+
+type DeleteViewResponse struct {
+}
+
+func MakeDeleteViewEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeleteViewRequest)
+		username := ctx.Value("username").(string)
+		err := s.DeleteView(ctx, username, req.ID)
+		return DeleteViewResponse{}, err
+	}
+}
+
+// ExportRequest asks for a user's Todos in a file format, optionally narrowed by a smart filter query
+type ExportRequest struct {
+	Format string
+	Query  Expr
+}
+
+// ExportResponse is written in its Format, rather than as JSON
+type ExportResponse struct {
+	Format string
+	Todos  []Todo
+}
+
+func MakeExportEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ExportRequest)
+		response, err := MakeGetAllForUserEndpoint(s)(ctx, GetAllForUserRequest{req.Query})
+		if err != nil {
+			return nil, err
+		}
+		todos := response.(GetAllForUserResponse).Todos
+		sortByCreatedOn(todos)
+		return ExportResponse{req.Format, todos}, nil
+	}
+}
+
+// ImportRequest holds the Todos read from an uploaded file.
+// A DryRun reports what would be imported without creating anything.
+type ImportRequest struct {
+	Items  []ParsedTodo
+	DryRun bool
+}
+
+// Import statuses
+const (
+	ImportCreated     = "created"
+	ImportWouldCreate = "would_create"
+	ImportDuplicate   = "duplicate"
+	ImportInvalid     = "invalid"
+)
+
+// ImportResult is the outcome of importing a single line of a file.
+// Line is the item number for JSON documents.
+type ImportResult struct {
+	Line   int          `json:"line"`
+	Status string       `json:"status"`
+	Todo   *Todo        `json:"todo,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// ImportResponse counts the Todos created, or for a DryRun the Todos which would be created, along with each line's result
+type ImportResponse struct {
+	DryRun      bool           `json:"dry_run"`
+	Created     int            `json:"created"`
+	WouldCreate int            `json:"would_create"`
+	Results     []ImportResult `json:"results"`
+}
+
+// MakeImportEndpoint returns an endpoint which creates a Todo for each valid line of an import.
+// Lines with the same text as an existing Todo, or an earlier line, are skipped as duplicates.
+func MakeImportEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ImportRequest)
+		username := ctx.Value("username").(string)
+
+		existing, err := s.GetAllForUser(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, todo := range existing {
+			seen[duplicateKey(todo)] = true
+		}
+
+		results := make([]ImportResult, 0, len(req.Items))
+		var todos []Todo
+		var created []int
+		for _, item := range req.Items {
+			todo := item.Todo
+			todo.Username = username
+			result := ImportResult{Line: item.Line, Todo: &todo}
+
+			switch {
+			case item.Err != nil:
+				result.Status = ImportInvalid
+				result.Errors = []FieldError{{"line", item.Err.Error()}}
+			case len(validateTodo(todo)) > 0:
+				result.Status = ImportInvalid
+				result.Errors = validateTodo(todo)
+			case seen[duplicateKey(todo)]:
+				result.Status = ImportDuplicate
+			default:
+				seen[duplicateKey(todo)] = true
+				result.Status = ImportWouldCreate
+				todos = append(todos, todo)
+				created = append(created, len(results))
+			}
+			results = append(results, result)
+		}
+
+		if req.DryRun {
+			return ImportResponse{DryRun: true, WouldCreate: len(todos), Results: results}, nil
+		}
+		if len(todos) > 0 {
+			added, err := s.AddMany(ctx, todos)
+			if err != nil {
+				return nil, err
+			}
+			for i, idx := range created {
+				results[idx].Status = ImportCreated
+				results[idx].Todo = &added[i]
+			}
+		}
+
+		return ImportResponse{Created: len(todos), Results: results}, nil
+	}
+}
+
+// duplicateKey identifies Todos which are duplicates of each other, ignoring case & spacing
+func duplicateKey(todo Todo) string {
+	return strings.ToLower(strings.Join(strings.Fields(todo.Text), " "))
+}
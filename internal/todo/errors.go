@@ -0,0 +1,189 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError describes a single invalid field in a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a request is malformed or fails validation.
+// Fields holds every violation found, so clients can fix them all at once.
+type ValidationError struct {
+	Detail string
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Detail
+	}
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Detail, strings.Join(msgs, "; "))
+}
+
+// StatusCode implements httptransport.StatusCoder
+func (e *ValidationError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// UnauthorizedError is returned when the user's credentials are missing or invalid
+type UnauthorizedError struct {
+	Detail string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.Detail
+}
+
+// StatusCode implements httptransport.StatusCoder
+func (e *UnauthorizedError) StatusCode() int {
+	return http.StatusUnauthorized
+}
+
+// Headers implements httptransport.Headerer
+func (e *UnauthorizedError) Headers() http.Header {
+	h := http.Header{}
+	h.Set("WWW-Authenticate", "JWT")
+	return h
+}
+
+// NotFoundError is returned when the requested Entity doesn't exist
+type NotFoundError struct {
+	Detail string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Detail
+}
+
+// StatusCode implements httptransport.StatusCoder
+func (e *NotFoundError) StatusCode() int {
+	return http.StatusNotFound
+}
+
+// ForbiddenError is returned when the user isn't allowed to perform an operation
+type ForbiddenError struct {
+	Detail string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Detail
+}
+
+// StatusCode implements httptransport.StatusCoder
+func (e *ForbiddenError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+// ConflictError is returned when an operation conflicts with the current state of an Entity
+type ConflictError struct {
+	Detail string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Detail
+}
+
+// StatusCode implements httptransport.StatusCoder
+func (e *ConflictError) StatusCode() int {
+	return http.StatusConflict
+}
+
+// NotAcceptableError is returned when the response can't be encoded in any media type the client accepts
+type NotAcceptableError struct {
+	Detail string
+}
+
+func (e *NotAcceptableError) Error() string {
+	return e.Detail
+}
+
+// StatusCode implements httptransport.StatusCoder
+func (e *NotAcceptableError) StatusCode() int {
+	return http.StatusNotAcceptable
+}
+
+// UnsupportedMediaTypeError is returned when the request body's media type can't be decoded
+type UnsupportedMediaTypeError struct {
+	Detail string
+}
+
+func (e *UnsupportedMediaTypeError) Error() string {
+	return e.Detail
+}
+
+// StatusCode implements httptransport.StatusCoder
+func (e *UnsupportedMediaTypeError) StatusCode() int {
+	return http.StatusUnsupportedMediaType
+}
+
+// RateLimitedError is returned when the user has made too many requests.
+// RetryAfter, when set, tells the client how long to back off for.
+type RateLimitedError struct {
+	Detail     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return e.Detail
+}
+
+// StatusCode implements httptransport.StatusCoder
+func (e *RateLimitedError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+// Headers implements httptransport.Headerer
+func (e *RateLimitedError) Headers() http.Header {
+	h := http.Header{}
+	if e.RetryAfter > 0 {
+		secs := int((e.RetryAfter + time.Second - 1) / time.Second)
+		h.Set("Retry-After", strconv.Itoa(secs))
+	}
+	return h
+}
+
+// Problem is an RFC 7807 problem details object
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// ProblemContentType is the media type of an encoded Problem
+const ProblemContentType = "application/problem+json"
+
+// newProblem builds the Problem describing err.
+// Errors which don't carry a status code are treated as internal errors & their message isn't exposed.
+func newProblem(err error, status int) Problem {
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: err.Error(),
+	}
+	if status == http.StatusInternalServerError {
+		p.Detail = "An unexpected error occurred"
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		p.Detail = verr.Detail
+		p.Errors = verr.Fields
+	}
+	return p
+}
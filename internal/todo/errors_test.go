@@ -0,0 +1,72 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCodeFrom checks that each domain error maps to the right HTTP status
+func TestCodeFrom(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{ErrNotFound, http.StatusNotFound},
+		{ErrInconsistentIDs, http.StatusBadRequest},
+		{ErrMissingParam, http.StatusBadRequest},
+		{&ValidationError{Detail: "invalid"}, http.StatusBadRequest},
+		{&ForbiddenError{Detail: "forbidden"}, http.StatusForbidden},
+		{&ConflictError{Detail: "conflict"}, http.StatusConflict},
+		{&RateLimitedError{Detail: "slow down"}, http.StatusTooManyRequests},
+		{fmt.Errorf("wrapped: %w", ErrNotFound), http.StatusNotFound},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		require.Equalf(t, c.code, codeFrom(c.err), "Unexpected status for %q", c.err)
+	}
+}
+
+// TestEncodeErrorValidationProblem checks field errors are serialised in the problem
+func TestEncodeErrorValidationProblem(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := &ValidationError{
+		Detail: "Invalid todo",
+		Fields: []FieldError{{Field: "text", Message: "is required"}},
+	}
+	encodeError(context.Background(), err, w)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "application/problem+json; charset=utf-8", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Bad Request",
+		"status": 400,
+		"detail": "Invalid todo",
+		"errors": [{"field": "text", "message": "is required"}]
+	}`, w.Body.String())
+}
+
+// TestEncodeErrorHidesInternalErrors checks unexpected errors don't leak their message
+func TestEncodeErrorHidesInternalErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	encodeError(context.Background(), errors.New("pq: password authentication failed"), w)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.NotContains(t, w.Body.String(), "password")
+}
+
+// TestEncodeErrorRetryAfter checks rate limited errors set the Retry-After header
+func TestEncodeErrorRetryAfter(t *testing.T) {
+	w := httptest.NewRecorder()
+	encodeError(context.Background(), &RateLimitedError{Detail: "slow down", RetryAfter: 1500 * time.Millisecond}, w)
+
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "2", w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,80 @@
+package todo
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// EventType is the kind of change an Event describes
+type EventType string
+
+const (
+	// EventAdded is when a Todo is created
+	EventAdded EventType = "added"
+	// EventUpdated is when a Todo's contents change
+	EventUpdated EventType = "updated"
+	// EventAssigned is when a Todo's assignee changes, including to nobody
+	EventAssigned EventType = "assigned"
+	// EventDeleted is when a Todo is deleted
+	EventDeleted EventType = "deleted"
+)
+
+// Event describes a change the service made to a Todo
+type Event struct {
+	Type EventType `json:"type"`
+	Todo Todo      `json:"todo"`
+	// Previous is the Todo before an update or assignment
+	Previous *Todo `json:"previous,omitempty"`
+	// Actor is the user who made the change
+	Actor string    `json:"actor"`
+	At    time.Time `json:"at"`
+}
+
+// EventHook is called after the service changes a Todo.
+// Hooks are called synchronously, after the change is stored, so slow work should be handed off.
+type EventHook func(ctx context.Context, event Event)
+
+// Notification tells a user about a change to a Todo they're involved in
+type Notification struct {
+	Username string `json:"username"`
+	// Tenant is the organisation the user belongs to
+	Tenant string `json:"tenant,omitempty"`
+	Event  Event  `json:"event"`
+}
+
+// AssigneeNotifier is an EventHook telling users about changes to Todos they're involved in:
+// a Todo's assignee when it's assigned to them, taken off them, or changed while assigned,
+// & its owner when someone else changes it. Users aren't told about their own changes.
+func AssigneeNotifier(notify func(ctx context.Context, n Notification)) EventHook {
+	return func(ctx context.Context, event Event) {
+		recipients := map[string]bool{
+			event.Todo.Username: true,
+			event.Todo.Assignee: true,
+		}
+		if event.Previous != nil {
+			recipients[event.Previous.Assignee] = true
+		}
+		delete(recipients, "")
+		delete(recipients, event.Actor)
+		for username := range recipients {
+			notify(ctx, Notification{Username: username, Tenant: tenantFromContext(ctx), Event: event})
+		}
+	}
+}
+
+// LogNotifications writes Notifications to logger, until there's a way to deliver them
+func LogNotifications(logger log.Logger) func(ctx context.Context, n Notification) {
+	return func(ctx context.Context, n Notification) {
+		level.Info(logger).Log(
+			"request_id", requestIDFromContext(ctx),
+			"tenant", n.Tenant,
+			"notify", n.Username,
+			"actor", n.Event.Actor,
+			"event", n.Event.Type,
+			"id", n.Event.Todo.ID,
+		)
+	}
+}
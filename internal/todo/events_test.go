@@ -0,0 +1,218 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+// shareWith shares a Todo or View & accepts it
+func shareWith(t *testing.T, s TodoService, share Share) {
+	ctx := context.Background()
+	share, err := s.Share(ctx, share)
+	require.NoError(t, err)
+	_, err = s.AcceptShare(ctx, share.Username, share.ID)
+	require.NoError(t, err)
+}
+
+// TestAssign tests a Todo can only be assigned by & to users who can edit it
+func TestAssign(t *testing.T) {
+	s := NewInmemTodoService()
+	ctx := context.Background()
+	todo, _ := s.Add(ctx, Todo{Username: "owner@test.com", Text: "Paint the fence"})
+	shareWith(t, s, Share{Owner: "owner@test.com", Username: "editor@test.com", TodoID: todo.ID, Access: ShareEditor})
+	shareWith(t, s, Share{Owner: "owner@test.com", Username: "viewer@test.com", TodoID: todo.ID, Access: ShareViewer})
+
+	_, err := s.Assign(ctx, "stranger@test.com", todo.ID, "owner@test.com")
+	require.Equal(t, ErrNotFound, err)
+	_, err = s.Assign(ctx, "viewer@test.com", todo.ID, "viewer@test.com")
+	require.Equal(t, ErrViewerCantEdit, err)
+	_, err = s.Assign(ctx, "owner@test.com", todo.ID, "viewer@test.com")
+	require.Equal(t, ErrInvalidAssignee, err, "Expected a viewer not to be assignable")
+	_, err = s.Assign(ctx, "owner@test.com", todo.ID, "stranger@test.com")
+	require.Equal(t, ErrInvalidAssignee, err)
+
+	assigned, err := s.Assign(ctx, "editor@test.com", todo.ID, "editor@test.com")
+	require.NoError(t, err)
+	require.Equal(t, "editor@test.com", assigned.Assignee)
+	require.Equal(t, "owner@test.com", assigned.CreatedBy)
+
+	// updates keep the assignee
+	assigned.Completed = true
+	assigned.Assignee = ""
+	require.NoError(t, s.Update(ctx, "editor@test.com", todo.ID, assigned))
+	mine, err := s.GetAssignedToUser(ctx, "editor@test.com")
+	require.NoError(t, err)
+	require.Len(t, mine, 1)
+	require.True(t, mine[0].Completed)
+
+	// leaving the share hides the Todo from the assignee
+	shares, _ := s.GetShares(ctx, "editor@test.com")
+	require.NoError(t, s.DeleteShare(ctx, "editor@test.com", shares[0].ID))
+	mine, _ = s.GetAssignedToUser(ctx, "editor@test.com")
+	require.Empty(t, mine)
+
+	unassigned, err := s.Assign(ctx, "owner@test.com", todo.ID, "")
+	require.NoError(t, err)
+	require.Empty(t, unassigned.Assignee)
+}
+
+// TestDeleteUserUnassigns tests deleting a user unassigns them from the Todos they don't own
+func TestDeleteUserUnassigns(t *testing.T) {
+	s := NewInmemTodoService()
+	ctx := context.Background()
+	todo, _ := s.Add(ctx, Todo{Username: "owner@test.com", Text: "Paint the fence"})
+	shareWith(t, s, Share{Owner: "owner@test.com", Username: "editor@test.com", TodoID: todo.ID, Access: ShareEditor})
+	_, err := s.Assign(ctx, "owner@test.com", todo.ID, "editor@test.com")
+	require.NoError(t, err)
+
+	_, err = s.DeleteUser(ctx, "editor@test.com")
+	require.NoError(t, err, "Expected a user with only assignments to be found")
+	got, err := s.GetByID(ctx, "owner@test.com", todo.ID)
+	require.NoError(t, err)
+	require.Empty(t, got.Assignee)
+}
+
+// TestAddToView tests an editor of a shared View adds Todos for its owner, which must match its query
+func TestAddToView(t *testing.T) {
+	s := NewInmemTodoService()
+	ctx := context.Background()
+	view, _ := s.AddView(ctx, View{Username: "owner@test.com", Name: "Groceries", Query: "tag:groceries"})
+
+	_, err := s.AddToView(ctx, "friend@test.com", view.ID, Todo{Text: "Eggs", Tags: []string{"groceries"}})
+	require.Equal(t, ErrNotFound, err, "Expected the View to be hidden until it's shared")
+
+	shareWith(t, s, Share{Owner: "owner@test.com", Username: "friend@test.com", ViewID: view.ID, Access: ShareEditor})
+	_, err = s.AddToView(ctx, "friend@test.com", view.ID, Todo{Text: "Eggs"})
+	require.Equal(t, ErrNotInView, err)
+	_, err = s.AddToView(ctx, "friend@test.com", view.ID, Todo{Text: "Eggs", Tags: []string{"groceries"}, Assignee: "stranger@test.com"})
+	require.Equal(t, ErrInvalidAssignee, err)
+
+	eggs, err := s.AddToView(ctx, "friend@test.com", view.ID, Todo{Text: "Eggs", Tags: []string{"groceries"}, Assignee: "friend@test.com"})
+	require.NoError(t, err)
+	require.Equal(t, "owner@test.com", eggs.Username)
+	require.Equal(t, "friend@test.com", eggs.CreatedBy)
+	require.Equal(t, "friend@test.com", eggs.Assignee)
+	require.Equal(t, ErrOwnerOnly, s.Delete(ctx, "friend@test.com", eggs.ID), "Expected the owner to keep control of the Todo")
+}
+
+// TestEventHooks tests hooks are told about each change, once it's stored
+func TestEventHooks(t *testing.T) {
+	var events []Event
+	var s TodoService
+	s = NewInmemTodoService(func(ctx context.Context, event Event) {
+		// hooks run outside the lock, so can read the change back
+		_, err := s.GetByID(ctx, event.Todo.Username, event.Todo.ID)
+		require.Equal(t, event.Type == EventDeleted, err != nil)
+		events = append(events, event)
+	})
+	ctx := context.Background()
+
+	todo, _ := s.Add(ctx, Todo{Username: "owner@test.com", Text: "Mow the lawn"})
+	todo.Text = "Mow the lawn & hedges"
+	s.Update(ctx, "owner@test.com", todo.ID, todo)
+	s.Assign(ctx, "owner@test.com", todo.ID, "owner@test.com")
+	s.Assign(ctx, "owner@test.com", todo.ID, "owner@test.com")
+	s.Delete(ctx, "owner@test.com", todo.ID)
+	s.Delete(ctx, "owner@test.com", todo.ID)
+
+	types := make([]EventType, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+		require.Equal(t, "owner@test.com", event.Actor)
+	}
+	require.Equal(t, []EventType{EventAdded, EventUpdated, EventAssigned, EventDeleted}, types, "Expected changes which did nothing not to be reported")
+	require.Equal(t, "Mow the lawn", events[1].Previous.Text)
+	require.Empty(t, events[2].Previous.Assignee)
+
+	events = nil
+	s.AddMany(ctx, []Todo{{Username: "owner@test.com", Text: "One"}, {Username: "owner@test.com", Text: "Two"}})
+	completed := true
+	s.UpdateMany(ctx, "owner@test.com", Filter{}, TodoPatch{Completed: &completed})
+	s.DeleteMany(ctx, "owner@test.com", Filter{})
+	require.Len(t, events, 6)
+
+	events = nil
+	todo, _ = s.Add(ctx, Todo{Username: "owner@test.com", Text: "Once"})
+	results, _ := s.DeleteMany(ctx, "owner@test.com", Filter{IDs: []string{todo.ID, todo.ID}})
+	require.Len(t, results, 1)
+	require.Len(t, events, 2, "Expected a repeated ID to be deleted once")
+}
+
+// TestAssigneeNotifier tests the users involved in a Todo are told about others' changes to it
+func TestAssigneeNotifier(t *testing.T) {
+	tests := []struct {
+		event      Event
+		recipients []string
+	}{
+		{
+			Event{Type: EventAdded, Todo: Todo{Username: "owner@test.com"}, Actor: "owner@test.com"},
+			[]string{},
+		},
+		{
+			Event{Type: EventAssigned, Todo: Todo{Username: "owner@test.com", Assignee: "a@test.com"}, Previous: &Todo{Username: "owner@test.com", Assignee: "b@test.com"}, Actor: "owner@test.com"},
+			[]string{"a@test.com", "b@test.com"},
+		},
+		{
+			Event{Type: EventUpdated, Todo: Todo{Username: "owner@test.com", Assignee: "a@test.com"}, Previous: &Todo{Username: "owner@test.com", Assignee: "a@test.com"}, Actor: "a@test.com"},
+			[]string{"owner@test.com"},
+		},
+		{
+			Event{Type: EventDeleted, Todo: Todo{Username: "owner@test.com", Assignee: "a@test.com"}, Actor: "owner@test.com"},
+			[]string{"a@test.com"},
+		},
+	}
+	for _, tc := range tests {
+		recipients := []string{}
+		AssigneeNotifier(func(ctx context.Context, n Notification) {
+			require.Equal(t, tc.event, n.Event)
+			recipients = append(recipients, n.Username)
+		})(context.Background(), tc.event)
+		sort.Strings(recipients)
+		require.Equal(t, tc.recipients, recipients, "%s by %s", tc.event.Type, tc.event.Actor)
+	}
+}
+
+// TestAssignHTTP tests a collaborator adds a Todo to a shared View, assigns it to the owner, who finds it assigned to them
+func TestAssignHTTP(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+	owner := newToken(t, jwt.MapClaims{"username": "owner@test.com"})
+	friend := newToken(t, jwt.MapClaims{"username": "friend@test.com"})
+	view, _ := todoService.AddView(context.Background(), View{Username: "owner@test.com", Name: "Chores", Query: "tag:chores"})
+	shareWith(t, todoService, Share{Owner: "owner@test.com", Username: "friend@test.com", ViewID: view.ID, Access: ShareEditor})
+
+	res := newCall(t, http.MethodPost, server.URL+"/api/todos", "JWT", friend, map[string]interface{}{"text": "Hoover", "created_by": "owner@test.com"})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode, "Expected created_by to be set by the server")
+
+	res = newCall(t, http.MethodPost, server.URL+"/api/views/"+view.ID+"/todos", "JWT", friend, map[string]interface{}{"text": "Hoover", "tags": []string{"chores"}})
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var added AddToViewResponse
+	json.NewDecoder(res.Body).Decode(&added)
+	require.Equal(t, "friend@test.com", added.Todo.CreatedBy)
+
+	res = newCall(t, http.MethodPut, server.URL+"/api/todos/"+added.Todo.ID+"/assignee", "JWT", friend, map[string]string{"assignee": "stranger@test.com"})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = newCall(t, http.MethodPut, server.URL+"/api/todos/"+added.Todo.ID+"/assignee", "JWT", friend, map[string]string{"assignee": "owner@test.com"})
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/todos/assigned", "JWT", owner, nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var assigned GetAssignedToMeResponse
+	json.NewDecoder(res.Body).Decode(&assigned)
+	require.Len(t, assigned.Todos, 1)
+	require.Equal(t, added.Todo.ID, assigned.Todos[0].ID)
+	require.Equal(t, "owner@test.com", assigned.Todos[0].Assignee)
+}
@@ -0,0 +1,507 @@
+package todo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayout is how dates without a time are written, e.g. in todo.txt
+const dateLayout = "2006-01-02"
+
+// fileFormat reads & writes Todos in a file format
+type fileFormat struct {
+	contentType string
+	extension   string
+	// encode streams Todos to w
+	encode func(w io.Writer, todos []Todo) error
+	// decode reads Todos from r, line by line
+	decode func(r io.Reader) ([]ParsedTodo, error)
+}
+
+// ParsedTodo is a Todo read from a file, or the reason its line couldn't be read
+type ParsedTodo struct {
+	Line int
+	Todo Todo
+	Err  error
+}
+
+// fileFormats are the supported export formats, by name
+var fileFormats = map[string]fileFormat{
+	"csv": {
+		contentType: "text/csv; charset=utf-8",
+		extension:   "csv",
+		encode:      encodeCSV,
+		decode:      decodeCSV,
+	},
+	"json": {
+		contentType: "application/x-ndjson",
+		extension:   "ndjson",
+		encode:      encodeNDJSON,
+		decode:      decodeJSON,
+	},
+	"markdown": {
+		contentType: "text/markdown; charset=utf-8",
+		extension:   "md",
+		encode:      encodeMarkdown,
+		decode:      decodeMarkdown,
+	},
+	"todotxt": {
+		contentType: "text/plain; charset=utf-8",
+		extension:   "txt",
+		encode:      encodeTodoTxt,
+		decode:      decodeTodoTxt,
+	},
+}
+
+// formatNames lists the supported formats, for error messages
+func formatNames() string {
+	names := make([]string, 0, len(fileFormats))
+	for name := range fileFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// sortByCreatedOn orders Todos oldest first, so exports are stable
+func sortByCreatedOn(todos []Todo) {
+	sort.SliceStable(todos, func(i, j int) bool {
+		if !todos[i].CreatedOn.Equal(todos[j].CreatedOn) {
+			return todos[i].CreatedOn.Before(todos[j].CreatedOn)
+		}
+		return todos[i].ID < todos[j].ID
+	})
+}
+
+// *** CSV ***
+
+var csvHeader = []string{"id", "text", "completed", "created_on", "due", "tags"}
+
+// encodeCSV writes a header, then a row for each Todo. Tags are space separated.
+func encodeCSV(w io.Writer, todos []Todo) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		due := ""
+		if todo.Due != nil {
+			due = todo.Due.Format(time.RFC3339)
+		}
+		err := cw.Write([]string{
+			todo.ID,
+			todo.Text,
+			strconv.FormatBool(todo.Completed),
+			todo.CreatedOn.Format(time.RFC3339),
+			due,
+			strings.Join(todo.Tags, " "),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvColumns maps header names onto Todo fields, including names used by other tools
+var csvColumns = map[string]string{
+	"text":       "text",
+	"title":      "text",
+	"task":       "text",
+	"name":       "text",
+	"content":    "text",
+	"completed":  "completed",
+	"done":       "completed",
+	"status":     "completed",
+	"created_on": "created_on",
+	"created":    "created_on",
+	"due":        "due",
+	"due_date":   "due",
+	"tags":       "tags",
+	"labels":     "tags",
+	"id":         "id",
+}
+
+// decodeCSV reads a CSV file with a header row. Only a text column is required.
+func decodeCSV(r io.Reader) ([]ParsedTodo, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if field := csvColumns[name]; field != "" {
+			if _, ok := columns[field]; !ok {
+				columns[field] = i
+			}
+		}
+	}
+	if _, ok := columns["text"]; !ok {
+		return nil, fmt.Errorf("CSV header must have a text column")
+	}
+
+	var parsed []ParsedTodo
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return parsed, nil
+		}
+		if err != nil {
+			if perr, ok := err.(*csv.ParseError); ok {
+				parsed = append(parsed, ParsedTodo{Line: perr.Line, Err: perr.Err})
+				continue
+			}
+			return parsed, err
+		}
+		line, _ := cr.FieldPos(0)
+		get := func(field string) string {
+			if i, ok := columns[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		p := ParsedTodo{Line: line, Todo: Todo{Text: get("text"), Tags: splitTags(get("tags"))}}
+		if completed := get("completed"); completed != "" {
+			p.Todo.Completed, p.Err = parseCompleted(completed)
+		}
+		if due := get("due"); due != "" && p.Err == nil {
+			p.Todo.Due, p.Err = parseDue(due)
+		}
+		parsed = append(parsed, p)
+	}
+}
+
+// splitTags splits tags separated by spaces or commas
+func splitTags(s string) []string {
+	tags := strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' || r == ';' })
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// parseCompleted reads a completion flag, as written by this & other tools
+func parseCompleted(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "x", "yes", "y", "done", "complete", "completed":
+		return true, nil
+	case "no", "n", "todo", "open", "incomplete", "pending":
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("completed must be true or false, got %q", s)
+	}
+	return b, nil
+}
+
+// parseDue reads a due date in RFC 3339 or 2006-01-02 format
+func parseDue(s string) (*time.Time, error) {
+	for _, layout := range []string{time.RFC3339, dateLayout} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("due must be a date like 2006-01-02, got %q", s)
+}
+
+// *** Newline delimited JSON ***
+
+// encodeNDJSON writes each Todo as a JSON object on its own line
+func encodeNDJSON(w io.Writer, todos []Todo) error {
+	enc := json.NewEncoder(w)
+	for _, todo := range todos {
+		if err := enc.Encode(todo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeJSON reads Todos exported as JSON, either by this service as newline delimited JSON,
+// or by another tool as a single document, e.g. an array of tasks or Google Tasks' lists of items.
+// Lines of newline delimited JSON, or items of a document, are numbered from 1.
+func decodeJSON(r io.Reader) ([]ParsedTodo, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err == nil {
+		var parsed []ParsedTodo
+		for _, item := range jsonItems(doc) {
+			todo, err := todoFromJSON(item)
+			parsed = append(parsed, ParsedTodo{Line: len(parsed) + 1, Todo: todo, Err: err})
+		}
+		return parsed, nil
+	}
+
+	var parsed []ParsedTodo
+	err = eachLine(bytes.NewReader(data), func(line int, text string) {
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		p := ParsedTodo{Line: line}
+		var item interface{}
+		if p.Err = json.Unmarshal([]byte(text), &item); p.Err == nil {
+			p.Todo, p.Err = todoFromJSON(item)
+		}
+		parsed = append(parsed, p)
+	})
+	return parsed, err
+}
+
+// jsonListKeys are the keys other tools keep lists of tasks under
+var jsonListKeys = []string{"items", "tasks", "todos", "data"}
+
+// jsonItems flattens a JSON document into its tasks.
+// Arrays, & objects holding a list of tasks, are containers, anything else is a task.
+func jsonItems(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		var items []interface{}
+		for _, item := range v {
+			items = append(items, jsonItems(item)...)
+		}
+		return items
+	case map[string]interface{}:
+		for _, key := range jsonListKeys {
+			if list, ok := v[key].([]interface{}); ok {
+				return jsonItems(list)
+			}
+		}
+	}
+	return []interface{}{v}
+}
+
+// todoFromJSON maps a task exported by this or another tool onto a Todo
+func todoFromJSON(v interface{}) (Todo, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return Todo{}, fmt.Errorf("expected a JSON object")
+	}
+
+	var todo Todo
+	for _, key := range []string{"text", "title", "content", "name"} {
+		if text, ok := m[key].(string); ok {
+			todo.Text = text
+			break
+		}
+	}
+
+	for _, key := range []string{"completed", "checked", "is_completed", "done"} {
+		switch completed := m[key].(type) {
+		case bool:
+			todo.Completed = completed
+		case float64:
+			todo.Completed = completed != 0
+		}
+	}
+	// Google Tasks
+	if status, ok := m["status"].(string); ok {
+		todo.Completed = status == "completed"
+	}
+
+	for _, key := range []string{"tags", "labels"} {
+		if tags, ok := m[key].([]interface{}); ok {
+			for _, tag := range tags {
+				if s, ok := tag.(string); ok {
+					todo.Tags = append(todo.Tags, s)
+				}
+			}
+		}
+	}
+
+	due := m["due"]
+	// Todoist nests the due date
+	if nested, ok := due.(map[string]interface{}); ok {
+		due = nested["date"]
+	}
+	if s, ok := due.(string); ok && s != "" {
+		d, err := parseDue(s)
+		if err != nil {
+			return todo, err
+		}
+		todo.Due = d
+	}
+	return todo, nil
+}
+
+// maxLineBytes is the longest line eachLine reads, well over a Todo of MaxTextLength with tags & a due date
+const maxLineBytes = 64 << 10
+
+// eachLine calls fn with each line of r, numbered from 1
+func eachLine(r io.Reader, fn func(line int, text string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fn(line, strings.TrimPrefix(scanner.Text(), "\ufeff"))
+	}
+	return scanner.Err()
+}
+
+// *** Markdown ***
+
+// encodeMarkdown writes a checklist item for each Todo, with tags as #tag & the due date as due:2006-01-02
+func encodeMarkdown(w io.Writer, todos []Todo) error {
+	bw := bufio.NewWriter(w)
+	for _, todo := range todos {
+		box := "[ ]"
+		if todo.Completed {
+			box = "[x]"
+		}
+		// continuation lines of a list item are indented
+		text := strings.Replace(todo.Text, "\n", "\n  ", -1)
+		fmt.Fprintf(bw, "- %s %s%s\n", box, text, annotations(todo, "#"))
+	}
+	return bw.Flush()
+}
+
+// annotations formats a Todo's tags, with the given prefix, & its due date for appending to a line
+func annotations(todo Todo, tagPrefix string) string {
+	var b strings.Builder
+	for _, tag := range todo.Tags {
+		b.WriteString(" " + tagPrefix + tag)
+	}
+	if todo.Due != nil {
+		b.WriteString(" due:" + todo.Due.UTC().Format(dateLayout))
+	}
+	return b.String()
+}
+
+// decodeMarkdown reads each checklist item, e.g. - [x] Text #tag due:2006-01-02.
+// Indented lines continue the item above, other lines are ignored.
+func decodeMarkdown(r io.Reader) ([]ParsedTodo, error) {
+	var parsed []ParsedTodo
+	var lines []string
+	flush := func() {
+		if len(parsed) == 0 || lines == nil {
+			return
+		}
+		p := &parsed[len(parsed)-1]
+		p.Todo.Text, p.Todo.Tags, p.Todo.Due, p.Err = extractAnnotations(strings.Join(lines, "\n"), "#")
+		lines = nil
+	}
+
+	err := eachLine(r, func(line int, text string) {
+		trimmed := strings.TrimLeft(text, " \t")
+		if len(lines) > 0 && len(trimmed) < len(text) && !isChecklistItem(trimmed) {
+			lines = append(lines, trimmed)
+			return
+		}
+		flush()
+		if !isChecklistItem(trimmed) {
+			return
+		}
+		parsed = append(parsed, ParsedTodo{Line: line, Todo: Todo{Completed: strings.ToLower(trimmed[3:4]) == "x"}})
+		lines = []string{trimmed[6:]}
+	})
+	flush()
+	return parsed, err
+}
+
+// isChecklistItem reports whether a line starts with - [ ], - [x], * [ ] or * [x]
+func isChecklistItem(line string) bool {
+	return len(line) >= 6 && (line[0] == '-' || line[0] == '*') && line[1] == ' ' &&
+		line[2] == '[' && strings.Contains(" xX", line[3:4]) && line[4] == ']' && line[5] == ' '
+}
+
+// extractAnnotations removes tags with the given prefix & a due:2006-01-02 annotation from text
+func extractAnnotations(text, tagPrefix string) (string, []string, *time.Time, error) {
+	var tags []string
+	var due *time.Time
+	var err error
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		var kept []string
+		for _, word := range strings.Split(line, " ") {
+			switch {
+			case len(word) > len(tagPrefix) && strings.HasPrefix(word, tagPrefix) && validTag(word[len(tagPrefix):]):
+				tags = append(tags, word[len(tagPrefix):])
+			case strings.HasPrefix(word, "due:") && len(word) > len("due:"):
+				due, err = parseDue(word[len("due:"):])
+			default:
+				kept = append(kept, word)
+			}
+		}
+		lines[i] = strings.Join(kept, " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), tags, due, err
+}
+
+// *** todo.txt ***
+
+// encodeTodoTxt writes a line for each Todo in the todo.txt format, see https://github.com/todotxt/todo.txt.
+// Tags are written as +project tags. Completion dates aren't recorded, so completed Todos have no dates.
+func encodeTodoTxt(w io.Writer, todos []Todo) error {
+	bw := bufio.NewWriter(w)
+	for _, todo := range todos {
+		prefix := todo.CreatedOn.UTC().Format(dateLayout) + " "
+		if todo.Completed {
+			prefix = "x "
+		}
+		// todo.txt has a Todo per line
+		text := strings.Join(strings.Fields(todo.Text), " ")
+		fmt.Fprintf(bw, "%s%s%s\n", prefix, text, annotations(todo, "+"))
+	}
+	return bw.Flush()
+}
+
+// decodeTodoTxt reads a Todo from each non blank line in the todo.txt format.
+// +project & @context tags become tags, and any priority or dates are dropped.
+func decodeTodoTxt(r io.Reader) ([]ParsedTodo, error) {
+	var parsed []ParsedTodo
+	err := eachLine(r, func(line int, text string) {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return
+		}
+		p := ParsedTodo{Line: line}
+
+		if strings.HasPrefix(text, "x ") {
+			p.Todo.Completed = true
+			text = strings.TrimSpace(text[2:])
+		}
+		if len(text) >= 4 && text[0] == '(' && text[2] == ')' && text[1] >= 'A' && text[1] <= 'Z' && text[3] == ' ' {
+			text = strings.TrimSpace(text[4:])
+		}
+		// completion & creation dates
+		for i := 0; i < 2; i++ {
+			if len(text) >= len(dateLayout) {
+				if _, err := time.Parse(dateLayout, text[:len(dateLayout)]); err == nil {
+					text = strings.TrimSpace(text[len(dateLayout):])
+				}
+			}
+		}
+
+		var contexts []string
+		text, p.Todo.Tags, p.Todo.Due, p.Err = extractAnnotations(text, "+")
+		text, contexts, _, _ = extractAnnotations(text, "@")
+		p.Todo.Tags = append(p.Todo.Tags, contexts...)
+		p.Todo.Text = text
+		parsed = append(parsed, p)
+	})
+	return parsed, err
+}
@@ -0,0 +1,110 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// exportSample are Todos exercising every field that survives an export
+func exportSample() []Todo {
+	due := time.Date(2018, 10, 20, 0, 0, 0, 0, time.UTC)
+	created := time.Date(2018, 10, 1, 9, 30, 0, 0, time.UTC)
+	return []Todo{
+		{ID: "a", Text: "Buy milk", CreatedOn: created},
+		{ID: "b", Text: "File taxes", Completed: true, Tags: []string{"admin", "home"}, Due: &due, CreatedOn: created},
+		{ID: "c", Text: `Quote "this", please`, Tags: []string{"work"}, CreatedOn: created},
+	}
+}
+
+// TestFormatsRoundTrip checks Todos read back from each export format match the originals
+func TestFormatsRoundTrip(t *testing.T) {
+	for name, format := range fileFormats {
+		b := &bytes.Buffer{}
+		require.NoErrorf(t, format.encode(b, exportSample()), "Error encoding %s", name)
+
+		parsed, err := format.decode(b)
+		require.NoErrorf(t, err, "Error decoding %s", name)
+		require.Equalf(t, len(exportSample()), len(parsed), "Expected every Todo back from %s", name)
+
+		for i, expected := range exportSample() {
+			p := parsed[i]
+			require.NoErrorf(t, p.Err, "Error decoding %s line %d", name, p.Line)
+			require.Equalf(t, expected.Text, p.Todo.Text, "Text differs after %s round trip", name)
+			require.Equalf(t, expected.Completed, p.Todo.Completed, "Completed differs after %s round trip", name)
+			require.Equalf(t, expected.Tags, p.Todo.Tags, "Tags differ after %s round trip", name)
+			if expected.Due == nil {
+				require.Nilf(t, p.Todo.Due, "Due differs after %s round trip", name)
+			} else {
+				require.Truef(t, expected.Due.Equal(*p.Todo.Due), "Due differs after %s round trip", name)
+			}
+		}
+	}
+}
+
+// TestEncodeTodoTxt checks the todo.txt output
+func TestEncodeTodoTxt(t *testing.T) {
+	b := &bytes.Buffer{}
+	require.NoError(t, encodeTodoTxt(b, exportSample()))
+	require.Equal(t, `2018-10-01 Buy milk
+x File taxes +admin +home due:2018-10-20
+2018-10-01 Quote "this", please +work
+`, b.String())
+}
+
+// TestEncodeMarkdown checks the Markdown checklist output
+func TestEncodeMarkdown(t *testing.T) {
+	b := &bytes.Buffer{}
+	require.NoError(t, encodeMarkdown(b, exportSample()))
+	require.Equal(t, `- [ ] Buy milk
+- [x] File taxes #admin #home due:2018-10-20
+- [ ] Quote "this", please #work
+`, b.String())
+}
+
+// TestMarkdownMultilineRoundTrip checks Todos spanning lines survive a Markdown round trip
+func TestMarkdownMultilineRoundTrip(t *testing.T) {
+	b := &bytes.Buffer{}
+	require.NoError(t, encodeMarkdown(b, []Todo{{Text: "Pack\n- passport\n- charger"}, {Text: "Leave"}}))
+
+	parsed, err := decodeMarkdown(b)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(parsed))
+	require.Equal(t, "Pack\n- passport\n- charger", parsed[0].Todo.Text)
+	require.Equal(t, "Leave", parsed[1].Todo.Text)
+}
+
+// TestExportHTTP tests exporting a filtered list of Todos as CSV
+func TestExportHTTP(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	_, err := todoService.AddMany(context.Background(), []Todo{
+		{Username: "test@test.com", Text: "Done", Completed: true},
+		{Username: "test@test.com", Text: "Not done"},
+	})
+	require.NoError(t, err, "Error adding Todos")
+
+	res := newHTTPServerCall(t, http.MethodGet, server.URL+"/api/todos/export?format=csv&query=completed:false", nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/csv; charset=utf-8", res.Header.Get("Content-Type"))
+	require.Equal(t, `attachment; filename="todos.csv"`, res.Header.Get("Content-Disposition"))
+
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Equal(t, 2, len(lines), "Expecting a header & 1 Todo")
+	require.Contains(t, lines[1], "Not done")
+
+	res = newHTTPServerCall(t, http.MethodGet, server.URL+"/api/todos/export?format=pdf", nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode, "Expecting unknown formats to be rejected")
+}
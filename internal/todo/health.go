@@ -0,0 +1,163 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	chiMiddleware "github.com/go-chi/chi/middleware"
+)
+
+// checkTimeout limits how long a check can take, so a hung dependency fails its check rather than the probe
+const checkTimeout = 5 * time.Second
+
+// ErrNoKeys is returned by a JWKS's check until its keys have been fetched
+var ErrNoKeys = errors.New("no signing keys have been fetched")
+
+// jwksStaleIntervals is how many refresh intervals a JWKS can fail to be fetched for before its check fails
+const jwksStaleIntervals = 3
+
+// Checker checks whether something the service depends on is working
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to a Checker
+type CheckerFunc func(ctx context.Context) error
+
+// Check implements Checker
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Checks are Checkers by name, e.g. storage
+type Checks map[string]Checker
+
+// HealthResponse reports whether every check passed, with each check's result
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// CheckResult is the result of one check
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Took   string `json:"took"`
+}
+
+// run runs every check at once, each limited to checkTimeout
+func (c Checks) run(ctx context.Context) HealthResponse {
+	health := HealthResponse{Status: "ok", Checks: map[string]CheckResult{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, checker := range c {
+		wg.Add(1)
+		go func(name string, checker Checker) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			begin := time.Now()
+			err := checker.Check(ctx)
+
+			result := CheckResult{Status: "ok", Took: time.Since(begin).String()}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Status, result.Error = "fail", err.Error()
+				health.Status = "fail"
+			}
+			health.Checks[name] = result
+		}(name, checker)
+	}
+	wg.Wait()
+	return health
+}
+
+// MakeHealthHTTPHandler serves the liveness checks at /healthz & the readiness checks at /readyz.
+// Those routes are unauthenticated, so platforms can probe them. Every other request is passed to next.
+func MakeHealthHTTPHandler(liveness, readiness Checks, next http.Handler) http.Handler {
+	r := chi.NewRouter()
+	probes := r.With(chiMiddleware.NoCache)
+	probes.Get("/healthz", healthHandler(liveness))
+	probes.Get("/readyz", healthHandler(readiness))
+	r.Handle("/*", next)
+	return r
+}
+
+// healthHandler responds with the results of checks, which is 503 Service Unavailable if any failed
+func healthHandler(checks Checks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := checks.run(r.Context())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if health.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	}
+}
+
+// lockWithin locks & unlocks l, failing with ctx's error if it can't be locked before ctx is done, e.g. because it's deadlocked.
+// The lock is still taken & released once it's free, so a lock which is only slow isn't left held.
+func lockWithin(ctx context.Context, l sync.Locker) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	locked := make(chan struct{})
+	go func() {
+		l.Lock()
+		l.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check implements Checker. Memory is always reachable, so it only fails if the store can't be read before ctx is done.
+func (s *inmemService) Check(ctx context.Context) error {
+	return lockWithin(ctx, s.RLocker())
+}
+
+// Check implements Checker, checking the service of each tenant which has been used
+func (s *tenantService) Check(ctx context.Context) error {
+	if err := lockWithin(ctx, s); err != nil {
+		return err
+	}
+	s.Lock()
+	checkers := make([]Checker, 0, len(s.services))
+	for _, t := range s.services {
+		if c, ok := t.TodoService.(Checker); ok {
+			checkers = append(checkers, c)
+		}
+	}
+	s.Unlock()
+
+	for _, c := range checkers {
+		if err := c.Check(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check implements Checker. It fails until the key set has been fetched, as no JWT can be verified before then.
+func (j *JWKS) Check(ctx context.Context) error {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if len(j.keys) == 0 {
+		return ErrNoKeys
+	}
+	if since := time.Since(j.succeeded); since > jwksStaleIntervals*j.interval {
+		return fmt.Errorf("signing keys haven't been fetched for %s", since.Round(time.Second))
+	}
+	return nil
+}
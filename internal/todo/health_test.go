@@ -0,0 +1,125 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHealth tests probes are served without authentication, failing with each check's result when a dependency is down
+func TestHealth(t *testing.T) {
+	down := errors.New("connection refused")
+	storageUp := true
+	readiness := Checks{
+		"storage": CheckerFunc(func(ctx context.Context) error {
+			if !storageUp {
+				return down
+			}
+			return nil
+		}),
+		"cache": CheckerFunc(func(ctx context.Context) error { return nil }),
+	}
+	api := newTestHandler(t, MakeTodoEndpoints(NewInmemTodoService()))
+	server := httptest.NewServer(MakeHealthHTTPHandler(Checks{}, readiness, api))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get(server.URL + "/readyz")
+	require.NoError(t, err)
+	var health HealthResponse
+	json.NewDecoder(res.Body).Decode(&health)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "ok", health.Status)
+	require.Len(t, health.Checks, 2)
+
+	storageUp = false
+	res, err = http.Get(server.URL + "/readyz")
+	require.NoError(t, err)
+	health = HealthResponse{}
+	json.NewDecoder(res.Body).Decode(&health)
+	res.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	require.Equal(t, "fail", health.Status)
+	require.Equal(t, CheckResult{Status: "fail", Error: "connection refused", Took: health.Checks["storage"].Took}, health.Checks["storage"])
+	require.Equal(t, "ok", health.Checks["cache"].Status)
+
+	res = newHTTPServerCall(t, http.MethodGet, server.URL+"/api/todos", nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode, "Expected other requests to reach the API")
+}
+
+// TestCheckTimeout tests a hung check fails, rather than hanging the probe
+func TestCheckTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	health := Checks{"storage": CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})}.run(ctx)
+	require.Equal(t, "fail", health.Status)
+	require.Equal(t, context.DeadlineExceeded.Error(), health.Checks["storage"].Error)
+}
+
+// TestStorageChecks tests the in memory & tenant services' checks
+func TestStorageChecks(t *testing.T) {
+	s := newTenantService(nil)
+	ctx := context.Background()
+	s.Add(tenantContext("acme"), Todo{Username: "test@test.com", Text: "One"})
+	require.NoError(t, s.(Checker).Check(ctx))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Equal(t, context.Canceled, s.(Checker).Check(cancelled))
+
+	inmem := NewInmemTodoService().(*inmemService)
+	inmem.Lock()
+	defer inmem.Unlock()
+	timeout, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, inmem.Check(timeout), "Expected a store which can't be locked to fail")
+}
+
+// TestJWKSCheck tests a JWKS isn't ready until its keys are fetched, or once they haven't been refreshed for several intervals
+func TestJWKSCheck(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	server := newJWKSServer()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var logs bytes.Buffer
+	jwks := NewJWKS(ctx, server.URL, time.Hour, log.NewSyncLogger(log.NewLogfmtLogger(&logs)))
+	require.Eventually(t, func() bool { return server.requestCount() == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, ErrNoKeys, jwks.Check(ctx))
+
+	server.setKeys(ecJWK("1", &key.PublicKey))
+	jwks.refresh()
+	require.NoError(t, jwks.Check(ctx))
+
+	server.Close()
+	jwks.refresh()
+	require.Contains(t, logs.String(), "fetching JSON Web Key Set failed", "Expected the failed fetch to be logged")
+	require.NoError(t, jwks.Check(ctx), "Expected the cached keys to be used through a short outage")
+
+	jwks.mu.Lock()
+	jwks.succeeded = time.Now().Add(-4 * time.Hour)
+	jwks.mu.Unlock()
+	require.Error(t, jwks.Check(ctx), "Expected keys which haven't been refreshed for 3 intervals to fail the check")
+}
@@ -0,0 +1,213 @@
+package todo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ICalendarContentType is the media type of an iCalendar document
+const ICalendarContentType = "text/calendar; charset=utf-8"
+
+const (
+	icalDateLayout     = "20060102"
+	icalDateTimeLayout = "20060102T150405Z"
+	// icalLineLength is the longest a content line can be, in octets, before it's folded
+	icalLineLength = 75
+)
+
+// encodeICalendar writes Todos as the VTODO components of an iCalendar document, see RFC 5545
+func encodeICalendar(w io.Writer, todos []Todo) error {
+	bw := bufio.NewWriter(w)
+	writeICalLine(bw, "BEGIN:VCALENDAR")
+	writeICalLine(bw, "VERSION:2.0")
+	writeICalLine(bw, "PRODID:-//sinnott74//TodoService//EN")
+	for _, todo := range todos {
+		writeVTODO(bw, todo)
+	}
+	writeICalLine(bw, "END:VCALENDAR")
+	return bw.Flush()
+}
+
+// writeVTODO writes a Todo as a VTODO component.
+// DTSTAMP is the Todo's creation time, so the output only changes when the Todo does.
+func writeVTODO(w *bufio.Writer, todo Todo) {
+	writeICalLine(w, "BEGIN:VTODO")
+	writeICalLine(w, "UID:"+todo.ID)
+	writeICalLine(w, "DTSTAMP:"+todo.CreatedOn.UTC().Format(icalDateTimeLayout))
+	writeICalLine(w, "CREATED:"+todo.CreatedOn.UTC().Format(icalDateTimeLayout))
+	writeICalLine(w, "SUMMARY:"+escapeICalText(todo.Text))
+	if todo.Completed {
+		writeICalLine(w, "STATUS:COMPLETED")
+	} else {
+		writeICalLine(w, "STATUS:NEEDS-ACTION")
+	}
+	if todo.Due != nil {
+		due := todo.Due.UTC()
+		if due.Equal(startOfDay(due)) {
+			writeICalLine(w, "DUE;VALUE=DATE:"+due.Format(icalDateLayout))
+		} else {
+			writeICalLine(w, "DUE:"+due.Format(icalDateTimeLayout))
+		}
+	}
+	if len(todo.Tags) > 0 {
+		tags := make([]string, len(todo.Tags))
+		for i, tag := range todo.Tags {
+			tags[i] = escapeICalText(tag)
+		}
+		writeICalLine(w, "CATEGORIES:"+strings.Join(tags, ","))
+	}
+	writeICalLine(w, "END:VTODO")
+}
+
+// writeICalLine writes a content line, folding it onto continuation lines if it's too long
+func writeICalLine(w *bufio.Writer, line string) {
+	for len(line) > icalLineLength {
+		// don't split a multi-byte character
+		cut := icalLineLength
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	w.WriteString(line + "\r\n")
+}
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+func escapeICalText(s string) string {
+	return icalEscaper.Replace(s)
+}
+
+func unescapeICalText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' || s[i] == 'N' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// splitICalList splits a comma separated list value, respecting escaped commas
+func splitICalList(s string) []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ',':
+			values = append(values, unescapeICalText(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, unescapeICalText(s[start:]))
+}
+
+// decodeVTODO reads the first VTODO component of an iCalendar document into a Todo.
+// Categories become tags, with spaces replaced by dashes so they're valid tags.
+func decodeVTODO(r io.Reader) (Todo, error) {
+	lines, err := unfoldICalLines(r)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	var todo Todo
+	inTodo, found := false, false
+	for _, line := range lines {
+		name, params, value := splitICalLine(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VTODO"):
+			inTodo, found = true, true
+		case name == "END" && strings.EqualFold(value, "VTODO"):
+			return todo, nil
+		case !inTodo:
+		case name == "SUMMARY":
+			todo.Text = unescapeICalText(value)
+		case name == "STATUS":
+			todo.Completed = strings.EqualFold(value, "COMPLETED")
+		case name == "COMPLETED":
+			todo.Completed = true
+		case name == "PERCENT-COMPLETE":
+			todo.Completed = todo.Completed || value == "100"
+		case name == "DUE":
+			due, err := parseICalTime(params, value)
+			if err != nil {
+				return Todo{}, err
+			}
+			todo.Due = &due
+		case name == "CATEGORIES":
+			for _, category := range splitICalList(value) {
+				if tag := strings.Join(strings.Fields(category), "-"); tag != "" {
+					todo.Tags = append(todo.Tags, tag)
+				}
+			}
+		}
+	}
+	if !found {
+		return Todo{}, fmt.Errorf("no VTODO component found")
+	}
+	return Todo{}, fmt.Errorf("VTODO component isn't closed")
+}
+
+// unfoldICalLines reads content lines, joining folded continuation lines back together
+func unfoldICalLines(r io.Reader) ([]string, error) {
+	var lines []string
+	err := eachLine(r, func(_ int, text string) {
+		text = strings.TrimSuffix(text, "\r")
+		if len(lines) > 0 && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) {
+			lines[len(lines)-1] += text[1:]
+			return
+		}
+		if text != "" {
+			lines = append(lines, text)
+		}
+	})
+	return lines, err
+}
+
+// splitICalLine splits a content line into its upper cased name, its parameters & its value
+func splitICalLine(line string) (string, map[string]string, string) {
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+	parts := strings.Split(line[:colon], ";")
+	params := map[string]string{}
+	for _, param := range parts[1:] {
+		if eq := strings.IndexByte(param, '='); eq > 0 {
+			params[strings.ToUpper(param[:eq])] = strings.Trim(param[eq+1:], `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:]
+}
+
+// parseICalTime reads a DATE or DATE-TIME value. Times without a zone are treated as UTC.
+func parseICalTime(params map[string]string, value string) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == len(icalDateLayout) {
+		return time.Parse(icalDateLayout, value)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(icalDateTimeLayout, value)
+	}
+	loc := time.UTC
+	if tzid, ok := params["TZID"]; ok {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t.UTC(), err
+}
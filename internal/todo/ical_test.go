@@ -0,0 +1,97 @@
+package todo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestICalendarRoundTrip tests a Todo written as a VTODO reads back the same
+func TestICalendarRoundTrip(t *testing.T) {
+	due := time.Date(2018, 11, 5, 0, 0, 0, 0, time.UTC)
+	todo := Todo{
+		ID:        "b9t4r3",
+		Text:      "Buy milk, eggs; bread\nand a \\ backslash",
+		Completed: true,
+		Tags:      []string{"shopping", "home,garden"},
+		Due:       &due,
+		CreatedOn: time.Date(2018, 10, 1, 9, 30, 0, 0, time.UTC),
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, encodeICalendar(&b, []Todo{todo}))
+	out := b.String()
+	require.Contains(t, out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n")
+	require.Contains(t, out, "UID:b9t4r3\r\n")
+	require.Contains(t, out, "DTSTAMP:20181001T093000Z\r\n")
+	require.Contains(t, out, `SUMMARY:Buy milk\, eggs\; bread\nand a \\ backslash`+"\r\n")
+	require.Contains(t, out, "STATUS:COMPLETED\r\n")
+	require.Contains(t, out, "DUE;VALUE=DATE:20181105\r\n")
+	require.Contains(t, out, `CATEGORIES:shopping,home\,garden`+"\r\n")
+
+	decoded, err := decodeVTODO(strings.NewReader(out))
+	require.NoError(t, err)
+	require.Equal(t, todo.Text, decoded.Text)
+	require.True(t, decoded.Completed)
+	require.Equal(t, todo.Tags, decoded.Tags)
+	require.True(t, due.Equal(*decoded.Due), "Expected due date %v, got %v", due, decoded.Due)
+}
+
+// TestICalendarDueTime tests a due date with a time of day is written as a UTC date-time
+func TestICalendarDueTime(t *testing.T) {
+	due := time.Date(2018, 11, 5, 17, 0, 0, 0, time.FixedZone("IST", 3600))
+	var b bytes.Buffer
+	require.NoError(t, encodeICalendar(&b, []Todo{{ID: "a", Text: "Call", Due: &due}}))
+	require.Contains(t, b.String(), "DUE:20181105T160000Z\r\n")
+	require.Contains(t, b.String(), "STATUS:NEEDS-ACTION\r\n")
+}
+
+// TestICalendarFolding tests long lines are folded at 75 octets without splitting characters
+func TestICalendarFolding(t *testing.T) {
+	text := strings.Repeat("é", 60)
+	var b bytes.Buffer
+	require.NoError(t, encodeICalendar(&b, []Todo{{ID: "a", Text: text}}))
+
+	for _, line := range strings.Split(b.String(), "\r\n") {
+		require.True(t, len(line) <= icalLineLength+1, "Line %q is longer than 75 octets", line)
+		require.NotContains(t, line, "�")
+	}
+	decoded, err := decodeVTODO(&b)
+	require.NoError(t, err)
+	require.Equal(t, text, decoded.Text)
+}
+
+// TestDecodeVTODO tests reading VTODOs as calendar apps write them
+func TestDecodeVTODO(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\n" +
+		"PRODID:-//Example//EN\r\n" +
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Dublin\r\nEND:VTIMEZONE\r\n" +
+		"BEGIN:VTODO\r\n" +
+		"UID:1234@example.com\r\n" +
+		"SUMMARY:Renew\r\n  passport\r\n" +
+		"DUE;TZID=Europe/Dublin:20180705T090000\r\n" +
+		"CATEGORIES:Travel Plans\r\n" +
+		"CATEGORIES:admin\r\n" +
+		"PERCENT-COMPLETE:100\r\n" +
+		"END:VTODO\r\n" +
+		"END:VCALENDAR\r\n"
+
+	todo, err := decodeVTODO(strings.NewReader(ics))
+	require.NoError(t, err)
+	require.Equal(t, "Renew passport", todo.Text)
+	require.True(t, todo.Completed)
+	require.Equal(t, []string{"Travel-Plans", "admin"}, todo.Tags)
+	require.NotNil(t, todo.Due)
+	if _, err := time.LoadLocation("Europe/Dublin"); err == nil {
+		require.Equal(t, time.Date(2018, 7, 5, 8, 0, 0, 0, time.UTC), *todo.Due)
+	}
+
+	_, err = decodeVTODO(strings.NewReader("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	require.Error(t, err, "Expected an error without a VTODO")
+
+	_, err = decodeVTODO(strings.NewReader("BEGIN:VTODO\r\nDUE:tomorrow\r\nEND:VTODO\r\n"))
+	require.Error(t, err, "Expected an error for an invalid due date")
+}
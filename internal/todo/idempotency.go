@@ -0,0 +1,270 @@
+package todo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header clients use to make a POST safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key accepted
+const maxIdempotencyKeyLength = 255
+
+// storedResponse is a response recorded against an idempotency key.
+// A response with a zero status is still being produced.
+type storedResponse struct {
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// IdempotencyStore remembers responses to POST requests by user & idempotency key
+type IdempotencyStore struct {
+	sync.Mutex
+	ttl       time.Duration
+	m         map[string]*storedResponse
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewIdempotencyStore creates an in memory IdempotencyStore which keeps responses for ttl
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		ttl: ttl,
+		m:   map[string]*storedResponse{},
+		now: time.Now,
+	}
+}
+
+// begin looks up the response for key, reserving the key if there isn't one.
+// It returns the stored response and false when the key has been seen before.
+func (s *IdempotencyStore) begin(key string, fingerprint [sha256.Size]byte) (storedResponse, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if res, ok := s.m[key]; ok && now.Before(res.expires) {
+		return *res, false
+	}
+	s.m[key] = &storedResponse{fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	return storedResponse{}, true
+}
+
+// finish records the response for a key reserved by begin
+func (s *IdempotencyStore) finish(key string, status int, header http.Header, body []byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	if res, ok := s.m[key]; ok {
+		res.status = status
+		res.header = header
+		res.body = body
+	}
+}
+
+// release forgets a key reserved by begin, so the request can be retried
+func (s *IdempotencyStore) release(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.m, key)
+}
+
+// sweep removes expired responses, at most once a minute.
+// The caller must hold the lock.
+func (s *IdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, res := range s.m {
+		if !now.Before(res.expires) {
+			delete(s.m, key)
+		}
+	}
+}
+
+// Idempotency is middleware which replays the original response when a POST is repeated with the same Idempotency-Key.
+// Keys are scoped to the authenticated user, so it must run after authentication.
+// Reusing a key with a different request, or while the original is in progress, is a conflict.
+// Server errors aren't stored, so the request can be retried.
+// The body is read to fingerprint the request, up to limit, the largest body the routes it's used on accept.
+func Idempotency(store *IdempotencyStore, limit int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+			if r.Method != http.MethodPost || idempotencyKey == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(idempotencyKey) > maxIdempotencyKeyLength {
+				encodeError(r.Context(), &ValidationError{
+					Detail: "Invalid header",
+					Fields: []FieldError{{IdempotencyKeyHeader, "must be at most 255 characters"}},
+				}, w)
+				return
+			}
+
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+			if err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+			if int64(len(body)) > limit {
+				encodeError(r.Context(), &http.MaxBytesError{Limit: limit}, w)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			username, _ := r.Context().Value("username").(string)
+			key := tenantFromContext(r.Context()) + "\x00" + username + "\x00" + idempotencyKey
+			fingerprint := requestFingerprint(r, body)
+
+			stored, isNew := store.begin(key, fingerprint)
+			if !isNew {
+				replay(w, r, stored, fingerprint)
+				return
+			}
+
+			// the key is released unless the response is stored, even if next panics, so the request can be retried
+			finished := false
+			defer func() {
+				if !finished {
+					store.release(key)
+				}
+			}()
+
+			rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			if rec.status < http.StatusInternalServerError {
+				store.finish(key, rec.status, rec.header, rec.body.Bytes())
+				finished = true
+			}
+		})
+	}
+}
+
+// requestFingerprint identifies a request by its path & body.
+// A multipart upload is identified by its file & options instead, as its boundary changes each time it's sent.
+func requestFingerprint(r *http.Request, body []byte) [sha256.Size]byte {
+	if file, options, ok := multipartUpload(r, body); ok {
+		return sha256.Sum256([]byte(r.URL.Path + "\x00" + options.Get("format") + "\x00" + options.Get("dry_run") + "\x00" + string(file)))
+	}
+	return sha256.Sum256(append([]byte(r.URL.Path+"\x00"), body...))
+}
+
+// multipartUpload reads the file & options of an import from a multipart body, as decodeImportRequest does.
+// It reports false if the body isn't a well formed multipart upload.
+func multipartUpload(r *http.Request, body []byte) ([]byte, url.Values, bool) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, nil, false
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	options := r.URL.Query()
+	var file []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return file, options, true
+		}
+		if err != nil {
+			return nil, nil, false
+		}
+		value, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, nil, false
+		}
+		switch part.FormName() {
+		case "file":
+			file = value
+		case "format", "dry_run":
+			options.Set(part.FormName(), string(value))
+		}
+	}
+}
+
+// replay writes a stored response, if it was for the same request & is complete
+func replay(w http.ResponseWriter, r *http.Request, stored storedResponse, fingerprint [sha256.Size]byte) {
+	switch {
+	case stored.fingerprint != fingerprint:
+		encodeError(r.Context(), &ConflictError{Detail: "Idempotency-Key has already been used for a different request"}, w)
+	case stored.status == 0:
+		encodeError(r.Context(), &ConflictError{Detail: "A request with this Idempotency-Key is still in progress"}, w)
+	default:
+		for k, v := range stored.header {
+			if !perRequestHeader(k) {
+				w.Header()[k] = v
+			}
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.status)
+		w.Write(stored.body)
+	}
+}
+
+// perRequestHeaders describe the request they're sent in reply to, so aren't stored or replayed
+var perRequestHeaders = []string{RequestIDHeader, "Traceparent", "Tracestate", "Traceresponse"}
+
+// perRequestHeader reports whether the header k is one of perRequestHeaders
+func perRequestHeader(k string) bool {
+	for _, h := range perRequestHeaders {
+		if http.CanonicalHeaderKey(k) == http.CanonicalHeaderKey(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingWriter is a ResponseWriter which keeps a copy of the status, headers & body written through it.
+// Headers are copied before outer middleware, such as compression, adds its own, leaving out perRequestHeaders.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.header = cloneHeader(w.Header())
+		for _, h := range perRequestHeaders {
+			w.header.Del(h)
+		}
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	clone := make(http.Header, len(h))
+	for k, v := range h {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
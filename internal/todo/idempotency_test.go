@@ -0,0 +1,198 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newIdempotentPost performs a POST with an Idempotency-Key
+func newIdempotentPost(t *testing.T, url, key string, payload interface{}) *http.Response {
+	b := &bytes.Buffer{}
+	json.NewEncoder(b).Encode(payload)
+	req, err := http.NewRequest(http.MethodPost, url, b)
+	require.NoError(t, err, "Error creating POST request")
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", newJWTToken(t))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "Error doing POST request")
+	return res
+}
+
+// TestIdempotentAddIsReplayed tests a retried POST doesn't create a duplicate Todo
+func TestIdempotentAddIsReplayed(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	todo := Todo{Text: "Only once"}
+	res := newIdempotentPost(t, server.URL+"/api/todos", "key-1", todo)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var first AddResponse
+	json.NewDecoder(res.Body).Decode(&first)
+
+	res = newIdempotentPost(t, server.URL+"/api/todos", "key-1", todo)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+	var second AddResponse
+	json.NewDecoder(res.Body).Decode(&second)
+	require.Equal(t, first.Todo.ID, second.Todo.ID, "Replayed response should be for the same Todo")
+
+	todos, err := todoService.GetAllForUser(context.Background(), "test@test.com")
+	require.NoError(t, err, "Error reading back Todos")
+	require.Equal(t, 1, len(todos), "Should only have created 1 Todo")
+}
+
+// TestIdempotentReplayKeepsRequestID tests a replayed response carries the retry's request ID, not the original's
+func TestIdempotentReplayKeepsRequestID(t *testing.T) {
+	store := NewIdempotencyStore(time.Hour)
+	handler := RequestID(Idempotency(store, testMaxBodyBytes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})))
+
+	for _, id := range []string{"first-request", "retried-request"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/todos", strings.NewReader(`{"text": "Once"}`))
+		r.Header.Set(IdempotencyKeyHeader, "key-1")
+		r.Header.Set(RequestIDHeader, id)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Equal(t, []string{id}, w.Header().Values(RequestIDHeader))
+	}
+	for _, stored := range store.m {
+		require.Empty(t, stored.header.Get(RequestIDHeader), "Expected the request ID not to be stored")
+	}
+}
+
+// TestIdempotencyKeyReusedWithDifferentPayload tests a key can't be reused for another request
+func TestIdempotencyKeyReusedWithDifferentPayload(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	res := newIdempotentPost(t, server.URL+"/api/todos", "key-1", Todo{Text: "First"})
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = newIdempotentPost(t, server.URL+"/api/todos", "key-1", Todo{Text: "Second"})
+	defer res.Body.Close()
+	require.Equal(t, http.StatusConflict, res.StatusCode)
+}
+
+// TestIdempotencyStoreExpires tests stored responses are forgotten after the TTL
+func TestIdempotencyStoreExpires(t *testing.T) {
+	now := time.Now()
+	store := NewIdempotencyStore(time.Hour)
+	store.now = func() time.Time { return now }
+
+	fingerprint := [32]byte{1}
+	_, isNew := store.begin("key", fingerprint)
+	require.True(t, isNew)
+	store.finish("key", http.StatusOK, http.Header{}, []byte("{}"))
+
+	stored, isNew := store.begin("key", fingerprint)
+	require.False(t, isNew)
+	require.Equal(t, http.StatusOK, stored.status)
+
+	now = now.Add(2 * time.Hour)
+	_, isNew = store.begin("key", fingerprint)
+	require.True(t, isNew, "Expired key should be usable again")
+}
+
+// TestIdempotentBodyLimit tests bodies over the handler's limit are rejected with an Idempotency-Key, uploads included
+func TestIdempotentBodyLimit(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	res := newIdempotentPost(t, server.URL+"/api/todos", "key-1", Todo{Text: strings.Repeat("a", testMaxBodyBytes)})
+	res.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	res = newIdempotentImport(t, server.URL+"/api/todos/import", "key-2", strings.Repeat("Buy milk\n", testMaxBodyBytes), true)
+	res.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	res = newIdempotentImport(t, server.URL+"/api/todos/import", "key-3", "Buy milk\n", true)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode, "Expected smaller uploads to be read")
+}
+
+// newIdempotentImport uploads a file to the import endpoint with an Idempotency-Key, in a new multipart body each time
+func newIdempotentImport(t *testing.T, url, key, content string, dryRun bool) *http.Response {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", "todo.txt")
+	require.NoError(t, err, "Error creating multipart file")
+	fw.Write([]byte(content))
+	mw.WriteField("dry_run", strconv.FormatBool(dryRun))
+	require.NoError(t, mw.Close(), "Error closing multipart writer")
+
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	require.NoError(t, err, "Error creating import request")
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", newJWTToken(t))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "Error doing import request")
+	return res
+}
+
+// TestIdempotentImportIsReplayed tests a retried upload is recognised, though its multipart boundary differs
+func TestIdempotentImportIsReplayed(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	res := newIdempotentImport(t, server.URL+"/api/todos/import", "key-1", "Buy milk\n", false)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = newIdempotentImport(t, server.URL+"/api/todos/import", "key-1", "Buy milk\n", false)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+	todos, _ := todoService.GetAllForUser(context.Background(), "test@test.com")
+	require.Len(t, todos, 1, "Should only have imported once")
+
+	res = newIdempotentImport(t, server.URL+"/api/todos/import", "key-1", "Buy milk\n", true)
+	res.Body.Close()
+	require.Equal(t, http.StatusConflict, res.StatusCode, "Expected a dry run to be a different request")
+	res = newIdempotentImport(t, server.URL+"/api/todos/import", "key-1", "Buy bread\n", false)
+	res.Body.Close()
+	require.Equal(t, http.StatusConflict, res.StatusCode, "Expected another file to be a different request")
+}
+
+// TestIdempotencyKeyReleasedOnPanic tests a request which panics can be retried with its key
+func TestIdempotencyKeyReleasedOnPanic(t *testing.T) {
+	store := NewIdempotencyStore(time.Hour)
+	panicking := true
+	handler := Idempotency(store, testMaxBodyBytes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if panicking {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/todos", strings.NewReader(`{"text": "Retry me"}`))
+		r.Header.Set(IdempotencyKeyHeader, "key-1")
+		return r
+	}
+	require.Panics(t, func() { handler.ServeHTTP(httptest.NewRecorder(), newRequest()) })
+
+	panicking = false
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newRequest())
+	require.Equal(t, http.StatusCreated, w.Code, "Expected the key to be released by the panic")
+}
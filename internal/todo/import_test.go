@@ -0,0 +1,118 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newImportCall uploads a file to the import endpoint
+func newImportCall(t *testing.T, url, filename, content string) ImportResponse {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", filename)
+	require.NoError(t, err, "Error creating multipart file")
+	fw.Write([]byte(content))
+	require.NoError(t, mw.Close(), "Error closing multipart writer")
+
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	require.NoError(t, err, "Error creating import request")
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", newJWTToken(t))
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "Error doing import request")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode, "Expecting StatusOK for import")
+
+	var importResponse ImportResponse
+	json.NewDecoder(res.Body).Decode(&importResponse)
+	return importResponse
+}
+
+// importStatuses lists the status of each result
+func importStatuses(response ImportResponse) []string {
+	statuses := []string{}
+	for _, result := range response.Results {
+		statuses = append(statuses, result.Status)
+	}
+	return statuses
+}
+
+// TestImportTodoTxtDryRunThenImport tests previewing an import, then importing it
+func TestImportTodoTxtDryRunThenImport(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	_, err := todoService.Add(context.Background(), Todo{Username: "test@test.com", Text: "Buy milk"})
+	require.NoError(t, err, "Error adding a Todo")
+
+	file := strings.Join([]string{
+		"(A) 2018-10-01 Call Mom +family @phone due:2018-10-20",
+		"x 2018-10-02 2018-10-01 File taxes",
+		"buy   MILK",
+		"Call Mom +family",
+		"Renew passport due:someday",
+	}, "\n")
+
+	dryRun := newImportCall(t, server.URL+"/api/todos/import?dry_run=true", "todo.txt", file)
+	require.True(t, dryRun.DryRun)
+	require.Equal(t, 0, dryRun.Created, "A dry run shouldn't claim to create Todos")
+	require.Equal(t, 2, dryRun.WouldCreate)
+	require.Equal(t, []string{ImportWouldCreate, ImportWouldCreate, ImportDuplicate, ImportDuplicate, ImportInvalid}, importStatuses(dryRun))
+	require.Equal(t, 5, dryRun.Results[4].Line, "Expecting the invalid line's number")
+	require.Equal(t, "Call Mom", dryRun.Results[0].Todo.Text)
+	require.Equal(t, []string{"family", "phone"}, dryRun.Results[0].Todo.Tags)
+	require.True(t, dryRun.Results[1].Todo.Completed)
+
+	todos, err := todoService.GetAllForUser(context.Background(), "test@test.com")
+	require.NoError(t, err, "Error reading back Todos")
+	require.Equal(t, 1, len(todos), "A dry run shouldn't create Todos")
+
+	imported := newImportCall(t, server.URL+"/api/todos/import", "todo.txt", file)
+	require.Equal(t, 2, imported.Created)
+	require.Equal(t, 0, imported.WouldCreate)
+	require.Equal(t, []string{ImportCreated, ImportCreated, ImportDuplicate, ImportDuplicate, ImportInvalid}, importStatuses(imported))
+	require.NotZero(t, imported.Results[0].Todo.ID, "Created Todos should have an ID")
+
+	todos, err = todoService.GetAllForUser(context.Background(), "test@test.com")
+	require.NoError(t, err, "Error reading back Todos")
+	require.Equal(t, 3, len(todos), "Expecting the 2 new Todos to be created")
+}
+
+// TestImportCSV tests importing a CSV file with another tool's column names
+func TestImportCSV(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	file := "Title,Done,Labels\nWrite report,no,work\n,no,\nPlan trip,maybe,\n"
+	imported := newImportCall(t, server.URL+"/api/todos/import", "export.csv", file)
+	require.Equal(t, []string{ImportCreated, ImportInvalid, ImportInvalid}, importStatuses(imported))
+	require.Equal(t, []FieldError{{"text", "is required"}}, imported.Results[1].Errors)
+	require.Equal(t, 4, imported.Results[2].Line)
+	require.Equal(t, []string{"work"}, imported.Results[0].Todo.Tags)
+}
+
+// TestImportGoogleTasksJSON tests importing nested task lists from another tool
+func TestImportGoogleTasksJSON(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	file := `{"kind": "tasks#taskLists", "items": [
+		{"kind": "tasks#taskList", "title": "My Tasks", "items": [
+			{"title": "Book dentist", "status": "needsAction", "due": "2018-10-20T00:00:00.000Z"},
+			{"title": "Return library books", "status": "completed"}
+		]}
+	]}`
+	imported := newImportCall(t, server.URL+"/api/todos/import", "Tasks.json", file)
+	require.Equal(t, []string{ImportCreated, ImportCreated}, importStatuses(imported))
+	require.Equal(t, "Book dentist", imported.Results[0].Todo.Text)
+	require.NotNil(t, imported.Results[0].Todo.Due)
+	require.True(t, imported.Results[1].Todo.Completed)
+}
@@ -0,0 +1,307 @@
+package todo
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// KeySource finds the public keys which verify asymmetrically signed JWTs
+type KeySource interface {
+	// Key returns the key with the given key ID. An empty kid matches a source's only key.
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// KeySet is a fixed set of public keys, by key ID
+type KeySet map[string]crypto.PublicKey
+
+// Key implements KeySource
+func (ks KeySet) Key(kid string) (crypto.PublicKey, error) {
+	if key, ok := ks[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(ks) == 1 {
+		for _, key := range ks {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// LoadPEMKeys reads a bundle of PEM encoded public keys & certificates.
+// A key's ID is its block's kid header if it has one, otherwise its RFC 7638 thumbprint.
+func LoadPEMKeys(path string) (KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePEMKeys(data)
+}
+
+func parsePEMKeys(data []byte) (KeySet, error) {
+	keys := KeySet{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		kid := block.Headers["kid"]
+		if kid == "" {
+			if kid, err = thumbprint(key); err != nil {
+				return nil, err
+			}
+		}
+		keys[kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM encoded keys found")
+	}
+	return keys, nil
+}
+
+// thumbprint is a key's RFC 7638 JWK thumbprint, which identity providers commonly use as its key ID
+func thumbprint(key crypto.PublicKey) (string, error) {
+	var members string
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`,
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			base64.RawURLEncoding.EncodeToString(key.N.Bytes()))
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`,
+			key.Curve.Params().Name,
+			base64.RawURLEncoding.EncodeToString(padBytes(key.X.Bytes(), size)),
+			base64.RawURLEncoding.EncodeToString(padBytes(key.Y.Bytes(), size)))
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", key)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// jwk is a JSON Web Key, see RFC 7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes an RSA or EC key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC key isn't on its curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// JWKS is a KeySource backed by a JSON Web Key Set URL.
+// Keys are cached & refreshed in the background. An unknown key ID triggers a refresh,
+// so rotated keys are picked up straight away, but at most once every minRefresh.
+type JWKS struct {
+	url        string
+	client     *http.Client
+	interval   time.Duration
+	minRefresh time.Duration
+	logger     log.Logger
+
+	// fetching serialises fetches, so a burst of unknown key IDs makes one request
+	fetching sync.Mutex
+	mu       sync.RWMutex
+	keys     KeySet
+	fetched  time.Time
+	// succeeded is when the keys were last fetched without error
+	succeeded time.Time
+}
+
+// NewJWKS creates a JWKS, refreshing its keys every interval until ctx is done.
+// Failed fetches are logged to logger.
+func NewJWKS(ctx context.Context, url string, interval time.Duration, logger log.Logger) *JWKS {
+	j := &JWKS{
+		url:        url,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		interval:   interval,
+		minRefresh: 10 * time.Second,
+		logger:     logger,
+	}
+	go func() {
+		j.refresh()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.refresh()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return j
+}
+
+// Key implements KeySource
+func (j *JWKS) Key(kid string) (crypto.PublicKey, error) {
+	j.mu.RLock()
+	keys, fetched := j.keys, j.fetched
+	j.mu.RUnlock()
+	if key, err := keys.Key(kid); err == nil {
+		return key, nil
+	}
+
+	if time.Since(fetched) >= j.minRefresh {
+		j.refreshSince(fetched)
+		j.mu.RLock()
+		keys = j.keys
+		j.mu.RUnlock()
+	}
+	return keys.Key(kid)
+}
+
+// refreshSince refreshes the keys, unless another caller already has since they were fetched
+func (j *JWKS) refreshSince(fetched time.Time) {
+	j.fetching.Lock()
+	defer j.fetching.Unlock()
+	j.mu.RLock()
+	stale := !j.fetched.After(fetched)
+	j.mu.RUnlock()
+	if stale {
+		j.fetch()
+	}
+}
+
+func (j *JWKS) refresh() {
+	j.fetching.Lock()
+	defer j.fetching.Unlock()
+	j.fetch()
+}
+
+// fetch replaces the cached keys with the key set's signing keys.
+// The cached keys are kept if the key set can't be fetched, so an outage doesn't lock everyone out.
+func (j *JWKS) fetch() {
+	keys, err := j.download()
+	if err != nil {
+		level.Warn(j.logger).Log("msg", "fetching JSON Web Key Set failed", "url", j.url, "err", err)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.fetched = time.Now()
+	if err == nil {
+		j.keys = keys
+		j.succeeded = j.fetched
+	}
+}
+
+func (j *JWKS) download() (KeySet, error) {
+	res, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", j.url, res.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding %s: %s", j.url, err)
+	}
+	keys := KeySet{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// skip keys we can't use, rather than failing the whole set
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
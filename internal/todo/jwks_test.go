@@ -0,0 +1,199 @@
+package todo
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// jwksServer serves a JSON Web Key Set which can be changed, counting requests for it
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []map[string]string
+	requests int
+}
+
+func newJWKSServer() *jwksServer {
+	s := &jwksServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(padBytes(key.X.Bytes(), 32)), "y": b64(padBytes(key.Y.Bytes(), 32))}
+}
+
+func signWithKid(t *testing.T, method jwt.SigningMethod, kid string, key crypto.PrivateKey) string {
+	token := jwt.NewWithClaims(method, validClaims())
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	require.NoError(t, err, "Error signing JWT")
+	return s
+}
+
+// TestJWKSVerification tests RS256 & ES256 tokens are verified against a JWKS, picking up rotated keys
+func TestJWKSVerification(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rotatedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	server := newJWKSServer()
+	defer server.Close()
+	server.setKeys(rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey),
+		map[string]string{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": "AQAB", "e": "AQAB"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jwks := NewJWKS(ctx, server.URL, time.Hour, log.NewNopLogger())
+	config := testJWTConfig()
+	config.Algorithms = []string{"RS256", "ES256"}
+	config.Keys = jwks
+	require.NoError(t, config.Check(false))
+
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodRS256, "rsa-1", rsaKey))
+	require.NoError(t, err, "Expected an RS256 token to be verified")
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodES256, "ec-1", ecKey))
+	require.NoError(t, err, "Expected an ES256 token to be verified")
+
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodRS256, "ec-1", rsaKey))
+	require.Error(t, err, "Expected a token naming another key to be refused")
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodRS256, "enc-1", rsaKey))
+	require.Error(t, err, "Expected encryption keys to be ignored")
+	_, err = config.parse(signWithSecret(t, config))
+	require.Error(t, err, "Expected HS256 to be refused when only public key algorithms are allowed")
+
+	// a token from a rotated key refreshes the set, but not more than once every minRefresh
+	jwks.minRefresh = time.Hour
+	server.setKeys(rsaJWK("rsa-2", &rotatedKey.PublicKey))
+	requests := server.requestCount()
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodRS256, "rsa-2", rotatedKey))
+	require.Error(t, err, "Expected the set not to be refreshed so soon")
+	require.Equal(t, requests, server.requestCount())
+
+	jwks.minRefresh = 0
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodRS256, "rsa-2", rotatedKey))
+	require.NoError(t, err, "Expected the rotated key to be fetched")
+	require.Equal(t, requests+1, server.requestCount())
+
+	// an outage keeps the cached keys
+	server.Close()
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodRS256, "rsa-3", rotatedKey))
+	require.Error(t, err)
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodRS256, "rsa-2", rotatedKey))
+	require.NoError(t, err)
+}
+
+// signWithSecret signs a valid token with the config's HMAC secret
+func signWithSecret(t *testing.T, config JWTConfig) string {
+	return signJWT(t, jwt.SigningMethodHS256, config.Secret, validClaims())
+}
+
+// TestJWKSBackgroundRefresh tests the key set is refreshed on an interval
+func TestJWKSBackgroundRefresh(t *testing.T) {
+	server := newJWKSServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	NewJWKS(ctx, server.URL, 10*time.Millisecond, log.NewNopLogger())
+	require.Eventually(t, func() bool { return server.requestCount() >= 3 }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	stopped := server.requestCount()
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, stopped, server.requestCount(), "Expected refreshing to stop when the context is done")
+}
+
+// TestPEMKeys tests verifying tokens against a PEM bundle of keys & certificates
+func TestPEMKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &ecKey.PublicKey, ecKey)
+	require.NoError(t, err)
+
+	bundle := append(
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Headers: map[string]string{"kid": "rsa-1"}, Bytes: rsaDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})...)
+	keys, err := parsePEMKeys(bundle)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	ecKid, err := thumbprint(&ecKey.PublicKey)
+	require.NoError(t, err)
+	require.Contains(t, keys, ecKid, "Expected a key without a kid header to be identified by its thumbprint")
+
+	config := testJWTConfig()
+	config.Algorithms = []string{"HS256", "RS256", "ES256"}
+	config.Keys = keys
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodRS256, "rsa-1", rsaKey))
+	require.NoError(t, err)
+	_, err = config.parse(signWithKid(t, jwt.SigningMethodES256, ecKid, ecKey))
+	require.NoError(t, err)
+	_, err = config.parse(signWithSecret(t, config))
+	require.NoError(t, err, "Expected HMAC tokens to still be verified with the secret")
+
+	_, err = parsePEMKeys([]byte("not PEM"))
+	require.Error(t, err)
+}
+
+// TestThumbprint checks the thumbprint against the example in RFC 7638 section 3.1
+func TestThumbprint(t *testing.T) {
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	require.NoError(t, err)
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	kid, err := thumbprint(key)
+	require.NoError(t, err)
+	require.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", kid)
+}
@@ -0,0 +1,309 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"unicode"
+
+	chiMiddleware "github.com/go-chi/chi/middleware"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/rs/xid"
+)
+
+// RequestIDHeader carries a request's ID, so log lines from every service handling it can be correlated
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of a request ID taken from a client's header
+const maxRequestIDLength = 128
+
+// NewLogger creates a logger writing logfmt or JSON lines to w, with a timestamp on each line.
+// Debug lines are dropped unless debug is set.
+func NewLogger(w io.Writer, format string, debug bool) (log.Logger, error) {
+	var logger log.Logger
+	switch format {
+	case "logfmt":
+		logger = log.NewLogfmtLogger(log.NewSyncWriter(w))
+	case "json":
+		logger = log.NewJSONLogger(log.NewSyncWriter(w))
+	default:
+		return nil, fmt.Errorf("unknown log format %q, must be logfmt or json", format)
+	}
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+	if debug {
+		return level.NewFilter(logger, level.AllowDebug()), nil
+	}
+	return level.NewFilter(logger, level.AllowInfo()), nil
+}
+
+// RequestID is middleware which identifies each request by the ID in its X-Request-ID header, or a new one.
+// The ID is put in the context & echoed in the response's header.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestIDFromContext(r.Context())
+		if id == "" {
+			id = r.Header.Get(RequestIDHeader)
+		}
+		if !validRequestID(id) {
+			id = xid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyRequestID, id)))
+	})
+}
+
+// validRequestID reports whether a client's request ID is safe to log & echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFromContext returns the ID of the request being handled
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyRequestID).(string)
+	return id
+}
+
+// AccessLog is middleware which logs each request once it's been handled
+func AccessLog(logger log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			begin := time.Now()
+			ww := chiMiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			level.Info(logger).Log(
+				"request_id", requestIDFromContext(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", ww.Status(),
+				"bytes", ww.BytesWritten(),
+				"took", time.Since(begin),
+			)
+		})
+	}
+}
+
+// logErrors wraps an ErrorEncoder, logging each error with its request's ID.
+// Server errors are logged as errors, while client errors are only logged when debugging.
+func logErrors(logger log.Logger, encode httptransport.ErrorEncoder) httptransport.ErrorEncoder {
+	return func(ctx context.Context, err error, w http.ResponseWriter) {
+		status := codeFrom(err)
+		l := level.Debug(logger)
+		if status >= http.StatusInternalServerError {
+			l = level.Error(logger)
+		}
+		l.Log("request_id", requestIDFromContext(ctx), "status", status, "err", err)
+		encode(ctx, err, w)
+	}
+}
+
+// transportErrorLogger is the http transports' ServerErrorLogger, which sees every error a go-kit server handles,
+// including those from encoding its response. It logs at debug level, as logErrors already logs them with their request.
+func transportErrorLogger(logger log.Logger) log.Logger {
+	return level.Debug(log.With(logger, "component", "http"))
+}
+
+// ServiceMiddleware decorates a TodoService
+type ServiceMiddleware func(TodoService) TodoService
+
+// LoggingMiddleware logs each call to a TodoService, with its request's ID, user & how long it took.
+// Successful calls & client errors are debug lines, server errors are error lines.
+func LoggingMiddleware(logger log.Logger) ServiceMiddleware {
+	return func(next TodoService) TodoService {
+		return &loggingService{logger: logger, next: next}
+	}
+}
+
+type loggingService struct {
+	logger log.Logger
+	next   TodoService
+}
+
+// log writes a line for a call which began at begin, described by keyvals
+func (s *loggingService) log(ctx context.Context, begin time.Time, err error, keyvals ...interface{}) {
+	l := level.Debug(s.logger)
+	if err != nil && codeFrom(err) >= http.StatusInternalServerError {
+		l = level.Error(s.logger)
+	}
+	keyvals = append([]interface{}{"request_id", requestIDFromContext(ctx), "tenant", tenantFromContext(ctx)}, keyvals...)
+	l.Log(append(keyvals, "took", time.Since(begin), "err", err)...)
+}
+
+func (s *loggingService) GetAllForUser(ctx context.Context, username string) (todos []Todo, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "GetAllForUser", "username", username, "todos", len(todos))
+	}(time.Now())
+	return s.next.GetAllForUser(ctx, username)
+}
+
+func (s *loggingService) GetByID(ctx context.Context, username string, id string) (todo Todo, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "GetByID", "username", username, "id", id)
+	}(time.Now())
+	return s.next.GetByID(ctx, username, id)
+}
+
+func (s *loggingService) Add(ctx context.Context, todo Todo) (added Todo, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "Add", "username", todo.Username, "id", added.ID)
+	}(time.Now())
+	return s.next.Add(ctx, todo)
+}
+
+func (s *loggingService) Update(ctx context.Context, username string, id string, todo Todo) (err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "Update", "username", username, "id", id)
+	}(time.Now())
+	return s.next.Update(ctx, username, id, todo)
+}
+
+func (s *loggingService) Delete(ctx context.Context, username string, id string) (err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "Delete", "username", username, "id", id)
+	}(time.Now())
+	return s.next.Delete(ctx, username, id)
+}
+
+func (s *loggingService) AddMany(ctx context.Context, todos []Todo) (added []Todo, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "AddMany", "todos", len(added))
+	}(time.Now())
+	return s.next.AddMany(ctx, todos)
+}
+
+func (s *loggingService) UpdateMany(ctx context.Context, username string, filter Filter, patch TodoPatch) (results []BulkResult, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "UpdateMany", "username", username, "results", len(results))
+	}(time.Now())
+	return s.next.UpdateMany(ctx, username, filter, patch)
+}
+
+func (s *loggingService) DeleteMany(ctx context.Context, username string, filter Filter) (results []BulkResult, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "DeleteMany", "username", username, "results", len(results))
+	}(time.Now())
+	return s.next.DeleteMany(ctx, username, filter)
+}
+
+func (s *loggingService) Search(ctx context.Context, username string, query string) (results []SearchResult, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "Search", "username", username, "results", len(results))
+	}(time.Now())
+	return s.next.Search(ctx, username, query)
+}
+
+func (s *loggingService) Query(ctx context.Context, username string, query Expr) (todos []Todo, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "Query", "username", username, "todos", len(todos))
+	}(time.Now())
+	return s.next.Query(ctx, username, query)
+}
+
+func (s *loggingService) GetViewsForUser(ctx context.Context, username string) (views []View, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "GetViewsForUser", "username", username, "views", len(views))
+	}(time.Now())
+	return s.next.GetViewsForUser(ctx, username)
+}
+
+func (s *loggingService) GetView(ctx context.Context, username string, id string) (view View, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "GetView", "username", username, "id", id)
+	}(time.Now())
+	return s.next.GetView(ctx, username, id)
+}
+
+func (s *loggingService) AddView(ctx context.Context, view View) (added View, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "AddView", "username", view.Username, "id", added.ID)
+	}(time.Now())
+	return s.next.AddView(ctx, view)
+}
+
+func (s *loggingService) DeleteView(ctx context.Context, username string, id string) (err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "DeleteView", "username", username, "id", id)
+	}(time.Now())
+	return s.next.DeleteView(ctx, username, id)
+}
+
+func (s *loggingService) GetUsers(ctx context.Context) (users []UserSummary, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "GetUsers", "users", len(users))
+	}(time.Now())
+	return s.next.GetUsers(ctx)
+}
+
+func (s *loggingService) DeleteUser(ctx context.Context, username string) (deleted UserSummary, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "DeleteUser", "username", username, "todos", deleted.Todos, "views", deleted.Views)
+	}(time.Now())
+	return s.next.DeleteUser(ctx, username)
+}
+
+func (s *loggingService) Share(ctx context.Context, share Share) (shared Share, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "Share", "username", share.Owner, "id", shared.ID)
+	}(time.Now())
+	return s.next.Share(ctx, share)
+}
+
+func (s *loggingService) GetShares(ctx context.Context, username string) (shares []Share, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "GetShares", "username", username, "shares", len(shares))
+	}(time.Now())
+	return s.next.GetShares(ctx, username)
+}
+
+func (s *loggingService) AcceptShare(ctx context.Context, username string, id string) (share Share, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "AcceptShare", "username", username, "id", id)
+	}(time.Now())
+	return s.next.AcceptShare(ctx, username, id)
+}
+
+func (s *loggingService) DeleteShare(ctx context.Context, username string, id string) (err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "DeleteShare", "username", username, "id", id)
+	}(time.Now())
+	return s.next.DeleteShare(ctx, username, id)
+}
+
+func (s *loggingService) GetSharedWithUser(ctx context.Context, username string) (todos []SharedTodo, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "GetSharedWithUser", "username", username, "todos", len(todos))
+	}(time.Now())
+	return s.next.GetSharedWithUser(ctx, username)
+}
+
+func (s *loggingService) AddToView(ctx context.Context, username string, viewID string, todo Todo) (added Todo, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "AddToView", "username", username, "view_id", viewID, "id", added.ID)
+	}(time.Now())
+	return s.next.AddToView(ctx, username, viewID, todo)
+}
+
+func (s *loggingService) Assign(ctx context.Context, username string, id string, assignee string) (todo Todo, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "Assign", "username", username, "id", id, "assignee", assignee)
+	}(time.Now())
+	return s.next.Assign(ctx, username, id, assignee)
+}
+
+func (s *loggingService) GetAssignedToUser(ctx context.Context, username string) (todos []Todo, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err, "method", "GetAssignedToUser", "username", username, "todos", len(todos))
+	}(time.Now())
+	return s.next.GetAssignedToUser(ctx, username)
+}
@@ -0,0 +1,131 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewLogger tests log lines are written in the chosen format, with debug lines only when debugging
+func TestNewLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger, err := NewLogger(&b, "json", false)
+	require.NoError(t, err)
+	level.Debug(logger).Log("msg", "hidden")
+	level.Info(logger).Log("msg", "shown")
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &line), "Expected a single JSON line, got %s", b.String())
+	require.Equal(t, "shown", line["msg"])
+	require.Equal(t, "info", line["level"])
+	require.NotEmpty(t, line["ts"])
+
+	b.Reset()
+	logger, err = NewLogger(&b, "logfmt", true)
+	require.NoError(t, err)
+	level.Debug(logger).Log("msg", "shown")
+	require.Contains(t, b.String(), "level=debug msg=shown")
+
+	_, err = NewLogger(&b, "xml", false)
+	require.Error(t, err)
+}
+
+// TestRequestID tests a client's request ID is kept, & a missing or unsafe one replaced
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFromContext(r.Context())
+	}))
+
+	for header, kept := range map[string]bool{
+		"abc-123":                true,
+		"":                       false,
+		"bad\nline":              false,
+		strings.Repeat("a", 129): false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, header)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.NotEmpty(t, seen)
+		require.Equal(t, seen, rec.Header().Get(RequestIDHeader), "Expected the ID to be echoed")
+		require.Equal(t, kept, seen == header, "%q", header)
+	}
+}
+
+// TestRequestLogging tests the access log, service calls & errors of a request all carry its ID
+func TestRequestLogging(t *testing.T) {
+	var b bytes.Buffer
+	logger, _ := NewLogger(&b, "logfmt", true)
+	service := LoggingMiddleware(logger)(NewInmemTodoService())
+	server := httptest.NewServer(MakeHTTPHandler(MakeTodoEndpoints(service), newJWTConfig(t), NewInmemAPIKeyService(), NewInmemCalendarTokenService(), NewIdempotencyStore(time.Hour), testMaxBodyBytes, logger))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/todos/missing", nil)
+	req.Header.Set("Authorization", newJWTToken(t))
+	req.Header.Set(RequestIDHeader, "req-42")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, "req-42", res.Header.Get(RequestIDHeader))
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 4, "Expected the service call, transport error, error & access log lines, got %s", b.String())
+	require.Contains(t, lines[0], "method=GetByID")
+	require.Contains(t, lines[1], "component=http")
+	require.Contains(t, lines[2], "status=404")
+	require.Contains(t, lines[3], "path=/api/todos/missing")
+	// the transport's error logger doesn't have the request's context
+	for _, line := range []string{lines[0], lines[2], lines[3]} {
+		require.Contains(t, line, "request_id=req-42")
+	}
+}
+
+// TestLoggingMiddlewareLevels tests server errors are logged as errors, so they're seen without debugging
+func TestLoggingMiddlewareLevels(t *testing.T) {
+	var b bytes.Buffer
+	logger, _ := NewLogger(&b, "logfmt", false)
+	s := &loggingService{logger: logger}
+	ctx := context.Background()
+
+	s.log(ctx, time.Now(), nil, "method", "GetByID")
+	s.log(ctx, time.Now(), ErrNotFound, "method", "GetByID")
+	require.Empty(t, b.String())
+
+	s.log(ctx, time.Now(), context.DeadlineExceeded, "method", "GetByID")
+	require.Contains(t, b.String(), "level=error")
+	require.Contains(t, b.String(), `err="context deadline exceeded"`)
+}
+
+// TestEncodeErrorsAreLogged tests an error writing a response is logged, though it's found after the endpoint succeeded
+func TestEncodeErrorsAreLogged(t *testing.T) {
+	var b bytes.Buffer
+	logger, _ := NewLogger(&b, "logfmt", true)
+	server := httptransport.NewServer(
+		func(ctx context.Context, request interface{}) (interface{}, error) { return struct{}{}, nil },
+		func(ctx context.Context, r *http.Request) (interface{}, error) { return nil, nil },
+		func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+			return errors.New("broken pipe")
+		},
+		httptransport.ServerErrorEncoder(logErrors(logger, encodeError)),
+		httptransport.ServerErrorLogger(transportErrorLogger(logger)),
+	)
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/todos", nil))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 2, "Expected the transport's & the error encoder's lines, got %s", b.String())
+	require.Contains(t, lines[0], "component=http")
+	require.Contains(t, lines[0], `err="broken pipe"`)
+	require.Contains(t, lines[1], "level=error")
+	require.Contains(t, lines[1], `err="broken pipe"`)
+}
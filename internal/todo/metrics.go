@@ -0,0 +1,305 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are what the instrumenting middlewares record, labelled by the method or endpoint called
+type Metrics struct {
+	Requests metrics.Counter
+	Latency  metrics.Histogram
+	// Errors is also labelled by the type of error, e.g. not_found
+	Errors metrics.Counter
+	label  string
+}
+
+// NewMetrics creates Metrics for a subsystem, e.g. service or endpoint, registering them with registry.
+// label names what's being measured, e.g. method.
+func NewMetrics(registry prometheus.Registerer, subsystem, label string) Metrics {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "todo",
+		Subsystem: subsystem,
+		Name:      "requests_total",
+		Help:      "Number of requests received.",
+	}, []string{label})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "todo",
+		Subsystem: subsystem,
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle requests, in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{label})
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "todo",
+		Subsystem: subsystem,
+		Name:      "errors_total",
+		Help:      "Number of requests which failed, by type of error.",
+	}, []string{label, "type"})
+	registry.MustRegister(requests, latency, errs)
+	return Metrics{
+		Requests: kitprometheus.NewCounter(requests),
+		Latency:  kitprometheus.NewHistogram(latency),
+		Errors:   kitprometheus.NewCounter(errs),
+		label:    label,
+	}
+}
+
+// observe records a request to name which began at begin
+func (m Metrics) observe(name string, begin time.Time, err error) {
+	m.Requests.With(m.label, name).Add(1)
+	m.Latency.With(m.label, name).Observe(time.Since(begin).Seconds())
+	if err != nil {
+		m.Errors.With(m.label, name, "type", errorType(err)).Add(1)
+	}
+}
+
+// errorType names the kind of error, by its status, e.g. not_found or internal_server_error
+func errorType(err error) string {
+	return strings.ToLower(strings.Replace(http.StatusText(codeFrom(err)), " ", "_", -1))
+}
+
+// InstrumentingEndpoint is endpoint middleware recording requests to the named endpoint in m
+func InstrumentingEndpoint(m Metrics, name string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) { m.observe(name, begin, err) }(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// InstrumentEndpoints wraps every endpoint with InstrumentingEndpoint, named after its field, e.g. GetByID
+func InstrumentEndpoints(e TodoEndpoints, m Metrics) TodoEndpoints {
+	return wrapEndpoints(e, func(name string) endpoint.Middleware { return InstrumentingEndpoint(m, name) })
+}
+
+// wrapEndpoints wraps every endpoint with the middleware for its field's name, e.g. GetByID for GetByIDEndpoint.
+// Fields are found by reflection, so new endpoints are wrapped without being listed here.
+func wrapEndpoints(e TodoEndpoints, middleware func(name string) endpoint.Middleware) TodoEndpoints {
+	v := reflect.ValueOf(&e).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		next, ok := v.Field(i).Interface().(endpoint.Endpoint)
+		if !ok || next == nil {
+			continue
+		}
+		name := v.Type().Field(i).Name
+		name = strings.TrimSuffix(strings.TrimSuffix(name, "Endpoint"), "EndPoint")
+		v.Field(i).Set(reflect.ValueOf(middleware(name)(next)))
+	}
+	return e
+}
+
+// InstrumentingMiddleware records each call to a TodoService in m, labelled by method
+func InstrumentingMiddleware(m Metrics) ServiceMiddleware {
+	return func(next TodoService) TodoService {
+		return &instrumentingService{m: m, next: next}
+	}
+}
+
+// StorageStats counts what a TodoService stores
+type StorageStats struct {
+	Todos int
+	Users int
+}
+
+// StatsReporter is a TodoService which can count what it stores
+type StatsReporter interface {
+	Stats() StorageStats
+}
+
+// RegisterStorageMetrics registers gauges of what s stores, labelled by its backend, e.g. inmem.
+// They're read from s whenever metrics are scraped.
+func RegisterStorageMetrics(registry prometheus.Registerer, backend string, s StatsReporter) {
+	labels := prometheus.Labels{"backend": backend}
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "todo",
+			Subsystem:   "storage",
+			Name:        "todos",
+			Help:        "Number of Todos stored.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.Stats().Todos) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "todo",
+			Subsystem:   "storage",
+			Name:        "users",
+			Help:        "Number of users with Todos or Views.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.Stats().Users) }),
+	)
+}
+
+// Stats counts the Todos & users in memory
+func (s *inmemService) Stats() StorageStats {
+	s.RLock()
+	defer s.RUnlock()
+
+	users := map[string]bool{}
+	for _, todo := range s.m {
+		users[todo.Username] = true
+	}
+	for _, view := range s.views {
+		users[view.Username] = true
+	}
+	return StorageStats{Todos: len(s.m), Users: len(users)}
+}
+
+// Stats adds up the stats of every tenant's service which reports them.
+// Users in different tenants are different users.
+func (s *tenantService) Stats() StorageStats {
+	s.Lock()
+	defer s.Unlock()
+
+	var stats StorageStats
+	for _, t := range s.services {
+		if r, ok := t.TodoService.(StatsReporter); ok {
+			tenant := r.Stats()
+			stats.Todos += tenant.Todos
+			stats.Users += tenant.Users
+		}
+	}
+	return stats
+}
+
+// MakeAdminHandler creates the http handler for the admin port, serving Prometheus metrics at /metrics
+func MakeAdminHandler(gatherer prometheus.Gatherer) http.Handler {
+	r := chi.NewRouter()
+	r.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	return r
+}
+
+type instrumentingService struct {
+	m    Metrics
+	next TodoService
+}
+
+func (s *instrumentingService) GetAllForUser(ctx context.Context, username string) (todos []Todo, err error) {
+	defer func(begin time.Time) { s.m.observe("GetAllForUser", begin, err) }(time.Now())
+	return s.next.GetAllForUser(ctx, username)
+}
+
+func (s *instrumentingService) GetByID(ctx context.Context, username string, id string) (todo Todo, err error) {
+	defer func(begin time.Time) { s.m.observe("GetByID", begin, err) }(time.Now())
+	return s.next.GetByID(ctx, username, id)
+}
+
+func (s *instrumentingService) Add(ctx context.Context, todo Todo) (added Todo, err error) {
+	defer func(begin time.Time) { s.m.observe("Add", begin, err) }(time.Now())
+	return s.next.Add(ctx, todo)
+}
+
+func (s *instrumentingService) Update(ctx context.Context, username string, id string, todo Todo) (err error) {
+	defer func(begin time.Time) { s.m.observe("Update", begin, err) }(time.Now())
+	return s.next.Update(ctx, username, id, todo)
+}
+
+func (s *instrumentingService) Delete(ctx context.Context, username string, id string) (err error) {
+	defer func(begin time.Time) { s.m.observe("Delete", begin, err) }(time.Now())
+	return s.next.Delete(ctx, username, id)
+}
+
+func (s *instrumentingService) AddMany(ctx context.Context, todos []Todo) (added []Todo, err error) {
+	defer func(begin time.Time) { s.m.observe("AddMany", begin, err) }(time.Now())
+	return s.next.AddMany(ctx, todos)
+}
+
+func (s *instrumentingService) UpdateMany(ctx context.Context, username string, filter Filter, patch TodoPatch) (results []BulkResult, err error) {
+	defer func(begin time.Time) { s.m.observe("UpdateMany", begin, err) }(time.Now())
+	return s.next.UpdateMany(ctx, username, filter, patch)
+}
+
+func (s *instrumentingService) DeleteMany(ctx context.Context, username string, filter Filter) (results []BulkResult, err error) {
+	defer func(begin time.Time) { s.m.observe("DeleteMany", begin, err) }(time.Now())
+	return s.next.DeleteMany(ctx, username, filter)
+}
+
+func (s *instrumentingService) Search(ctx context.Context, username string, query string) (results []SearchResult, err error) {
+	defer func(begin time.Time) { s.m.observe("Search", begin, err) }(time.Now())
+	return s.next.Search(ctx, username, query)
+}
+
+func (s *instrumentingService) Query(ctx context.Context, username string, query Expr) (todos []Todo, err error) {
+	defer func(begin time.Time) { s.m.observe("Query", begin, err) }(time.Now())
+	return s.next.Query(ctx, username, query)
+}
+
+func (s *instrumentingService) GetViewsForUser(ctx context.Context, username string) (views []View, err error) {
+	defer func(begin time.Time) { s.m.observe("GetViewsForUser", begin, err) }(time.Now())
+	return s.next.GetViewsForUser(ctx, username)
+}
+
+func (s *instrumentingService) GetView(ctx context.Context, username string, id string) (view View, err error) {
+	defer func(begin time.Time) { s.m.observe("GetView", begin, err) }(time.Now())
+	return s.next.GetView(ctx, username, id)
+}
+
+func (s *instrumentingService) AddView(ctx context.Context, view View) (added View, err error) {
+	defer func(begin time.Time) { s.m.observe("AddView", begin, err) }(time.Now())
+	return s.next.AddView(ctx, view)
+}
+
+func (s *instrumentingService) DeleteView(ctx context.Context, username string, id string) (err error) {
+	defer func(begin time.Time) { s.m.observe("DeleteView", begin, err) }(time.Now())
+	return s.next.DeleteView(ctx, username, id)
+}
+
+func (s *instrumentingService) GetUsers(ctx context.Context) (users []UserSummary, err error) {
+	defer func(begin time.Time) { s.m.observe("GetUsers", begin, err) }(time.Now())
+	return s.next.GetUsers(ctx)
+}
+
+func (s *instrumentingService) DeleteUser(ctx context.Context, username string) (deleted UserSummary, err error) {
+	defer func(begin time.Time) { s.m.observe("DeleteUser", begin, err) }(time.Now())
+	return s.next.DeleteUser(ctx, username)
+}
+
+func (s *instrumentingService) Share(ctx context.Context, share Share) (shared Share, err error) {
+	defer func(begin time.Time) { s.m.observe("Share", begin, err) }(time.Now())
+	return s.next.Share(ctx, share)
+}
+
+func (s *instrumentingService) GetShares(ctx context.Context, username string) (shares []Share, err error) {
+	defer func(begin time.Time) { s.m.observe("GetShares", begin, err) }(time.Now())
+	return s.next.GetShares(ctx, username)
+}
+
+func (s *instrumentingService) AcceptShare(ctx context.Context, username string, id string) (share Share, err error) {
+	defer func(begin time.Time) { s.m.observe("AcceptShare", begin, err) }(time.Now())
+	return s.next.AcceptShare(ctx, username, id)
+}
+
+func (s *instrumentingService) DeleteShare(ctx context.Context, username string, id string) (err error) {
+	defer func(begin time.Time) { s.m.observe("DeleteShare", begin, err) }(time.Now())
+	return s.next.DeleteShare(ctx, username, id)
+}
+
+func (s *instrumentingService) GetSharedWithUser(ctx context.Context, username string) (todos []SharedTodo, err error) {
+	defer func(begin time.Time) { s.m.observe("GetSharedWithUser", begin, err) }(time.Now())
+	return s.next.GetSharedWithUser(ctx, username)
+}
+
+func (s *instrumentingService) AddToView(ctx context.Context, username string, viewID string, todo Todo) (added Todo, err error) {
+	defer func(begin time.Time) { s.m.observe("AddToView", begin, err) }(time.Now())
+	return s.next.AddToView(ctx, username, viewID, todo)
+}
+
+func (s *instrumentingService) Assign(ctx context.Context, username string, id string, assignee string) (todo Todo, err error) {
+	defer func(begin time.Time) { s.m.observe("Assign", begin, err) }(time.Now())
+	return s.next.Assign(ctx, username, id, assignee)
+}
+
+func (s *instrumentingService) GetAssignedToUser(ctx context.Context, username string) (todos []Todo, err error) {
+	defer func(begin time.Time) { s.m.observe("GetAssignedToUser", begin, err) }(time.Now())
+	return s.next.GetAssignedToUser(ctx, username)
+}
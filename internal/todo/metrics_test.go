@@ -0,0 +1,67 @@
+package todo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMetrics tests requests are counted & timed by service method & endpoint, with errors by type, & storage is measured
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	storage := NewTenantTodoService(nil, func(tenant string) TodoService { return NewInmemTodoService() })
+	RegisterStorageMetrics(registry, "inmem", storage.(StatsReporter))
+	service := InstrumentingMiddleware(NewMetrics(registry, "service", "method"))(storage)
+	endpoints := InstrumentEndpoints(MakeTodoEndpoints(service), NewMetrics(registry, "endpoint", "endpoint"))
+	server := httptest.NewServer(newTestHandler(t, endpoints))
+	defer server.Close()
+	admin := httptest.NewServer(MakeAdminHandler(registry))
+	defer admin.Close()
+
+	storage.Add(context.WithValue(context.Background(), contextKeyTenant, "acme"), Todo{Username: "a@test.com", Text: "One"})
+	storage.Add(context.Background(), Todo{Username: "test@test.com", Text: "Two"})
+	storage.Add(context.Background(), Todo{Username: "test@test.com", Text: "Three"})
+
+	newHTTPServerCall(t, http.MethodGet, server.URL+"/api/todos", nil).Body.Close()
+	newHTTPServerCall(t, http.MethodGet, server.URL+"/api/todos/missing", nil).Body.Close()
+
+	res, err := http.Get(admin.URL + "/metrics")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+
+	for _, line := range []string{
+		`todo_service_requests_total{method="GetAllForUser"} 1`,
+		`todo_service_requests_total{method="GetByID"} 1`,
+		`todo_service_errors_total{method="GetByID",type="not_found"} 1`,
+		`todo_service_request_duration_seconds_count{method="GetByID"} 1`,
+		`todo_endpoint_requests_total{endpoint="GetAllForUser"} 1`,
+		`todo_endpoint_errors_total{endpoint="GetByID",type="not_found"} 1`,
+		`todo_endpoint_request_duration_seconds_count{endpoint="GetByID"} 1`,
+		`todo_storage_todos{backend="inmem"} 3`,
+		`todo_storage_users{backend="inmem"} 2`,
+	} {
+		require.Contains(t, string(body), line)
+	}
+}
+
+// TestInstrumentEndpoints tests every endpoint is wrapped, without losing any
+func TestInstrumentEndpoints(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	endpoints := InstrumentEndpoints(MakeTodoEndpoints(NewInmemTodoService()), NewMetrics(registry, "endpoint", "endpoint"))
+	require.NotNil(t, endpoints.GetAllForUserEndPoint)
+	require.NotNil(t, endpoints.CalendarDeleteEndpoint)
+
+	ctx := context.WithValue(context.Background(), "username", "test@test.com")
+	_, err := endpoints.SearchEndpoint(ctx, SearchRequest{"milk"})
+	require.NoError(t, err)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.NotEmpty(t, families)
+	require.Equal(t, "Search", families[0].Metric[0].Label[0].GetValue())
+}
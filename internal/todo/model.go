@@ -1,14 +1,107 @@
 package todo
 
 import (
+	"strings"
 	"time"
 )
 
 // Todo model
 type Todo struct {
+	ID string `json:"id"`
+	// Username is the Todo's owner
+	Username string `json:"username"`
+	// CreatedBy is the user who added the Todo, who may be someone the owner's shared a View with
+	CreatedBy string `json:"created_by,omitempty"`
+	// Assignee is the user doing the Todo, if anyone
+	Assignee  string     `json:"assignee,omitempty"`
+	Text      string     `json:"text"`
+	Completed bool       `json:"completed"`
+	CreatedOn time.Time  `json:"created_on"`
+	Tags      []string   `json:"tags,omitempty"`
+	Due       *time.Time `json:"due,omitempty"`
+}
+
+// HasTag reports whether the Todo is tagged with tag, ignoring case
+func (t Todo) HasTag(tag string) bool {
+	for _, tg := range t.Tags {
+		if strings.EqualFold(tg, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// View is a named query saved by a user
+type View struct {
 	ID        string    `json:"id"`
 	Username  string    `json:"username"`
-	Text      string    `json:"text"`
-	Completed bool      `json:"completed"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
 	CreatedOn time.Time `json:"created_on"`
+	// expr is the parsed Query, kept by the service so it isn't parsed for every Todo a shared View is checked against
+	expr Expr
+}
+
+// parsedQuery returns the View's parsed Query, which is only parsed now if the View didn't come from the service
+func (v View) parsedQuery() (Expr, error) {
+	if v.expr != nil {
+		return v.expr, nil
+	}
+	return ParseQuery(v.Query)
+}
+
+// Filter selects a user's Todos.
+// Empty criteria match every Todo.
+type Filter struct {
+	IDs       []string `json:"ids,omitempty"`
+	Completed *bool    `json:"completed,omitempty"`
+}
+
+// Empty reports whether the filter has no criteria
+func (f Filter) Empty() bool {
+	return len(f.IDs) == 0 && f.Completed == nil
+}
+
+// Matches reports whether a Todo satisfies the filter's criteria, other than IDs
+func (f Filter) Matches(todo Todo) bool {
+	return f.Completed == nil || *f.Completed == todo.Completed
+}
+
+// TodoPatch holds the fields to change on a Todo.
+// Nil fields are left unchanged.
+type TodoPatch struct {
+	Text      *string `json:"text,omitempty"`
+	Completed *bool   `json:"completed,omitempty"`
+}
+
+// Empty reports whether the patch changes nothing
+func (p TodoPatch) Empty() bool {
+	return p.Text == nil && p.Completed == nil
+}
+
+// Apply returns a copy of the Todo with the patch applied
+func (p TodoPatch) Apply(todo Todo) Todo {
+	if p.Text != nil {
+		todo.Text = *p.Text
+	}
+	if p.Completed != nil {
+		todo.Completed = *p.Completed
+	}
+	return todo
+}
+
+// BulkResult is the outcome of a bulk operation for a single Todo
+type BulkResult struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Todo   *Todo  `json:"todo,omitempty"`
+}
+
+// UserSummary describes how much data a user has stored
+type UserSummary struct {
+	Username  string `json:"username"`
+	Todos     int    `json:"todos"`
+	Completed int    `json:"completed"`
+	Views     int    `json:"views"`
 }
@@ -0,0 +1,100 @@
+package todo
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative todopb/api.proto
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/sinnott74/TodoService/internal/todo/todopb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// protoMessages are the Protobuf messages for the API's request bodies & responses, by their Go type.
+// Each message has the fields of its type's JSON document, so documents are transcoded by protojson.
+var protoMessages = map[reflect.Type]proto.Message{
+	reflect.TypeOf(Todo{}):                        &todopb.Todo{},
+	reflect.TypeOf(View{}):                        &todopb.View{},
+	reflect.TypeOf(bulkAddBody{}):                 &todopb.BulkAddRequest{},
+	reflect.TypeOf(bulkUpdateBody{}):              &todopb.BulkUpdateRequest{},
+	reflect.TypeOf(batchBody{}):                   &todopb.BatchRequest{},
+	reflect.TypeOf(ShareRequest{}):                &todopb.ShareRequest{},
+	reflect.TypeOf(AssignRequest{}):               &todopb.AssignRequest{},
+	reflect.TypeOf(CreateAPIKeyRequest{}):         &todopb.CreateAPIKeyRequest{},
+	reflect.TypeOf(RegisterRequest{}):             &todopb.RegisterRequest{},
+	reflect.TypeOf(LoginRequest{}):                &todopb.LoginRequest{},
+	reflect.TypeOf(RefreshRequest{}):              &todopb.RefreshRequest{},
+	reflect.TypeOf(LogoutRequest{}):               &todopb.LogoutRequest{},
+	reflect.TypeOf(GetAllForUserResponse{}):       &todopb.GetAllForUserResponse{},
+	reflect.TypeOf(GetByIDResponse{}):             &todopb.GetByIDResponse{},
+	reflect.TypeOf(AddResponse{}):                 &todopb.AddResponse{},
+	reflect.TypeOf(UpdateResponse{}):              &emptypb.Empty{},
+	reflect.TypeOf(DeleteResponse{}):              &emptypb.Empty{},
+	reflect.TypeOf(BulkResponse{}):                &todopb.BulkResponse{},
+	reflect.TypeOf(BatchResponse{}):               &todopb.BatchResponse{},
+	reflect.TypeOf(SearchResponse{}):              &todopb.SearchResponse{},
+	reflect.TypeOf(GetViewsResponse{}):            &todopb.GetViewsResponse{},
+	reflect.TypeOf(GetViewResponse{}):             &todopb.GetViewResponse{},
+	reflect.TypeOf(AddViewResponse{}):             &todopb.AddViewResponse{},
+	reflect.TypeOf(DeleteViewResponse{}):          &emptypb.Empty{},
+	reflect.TypeOf(AddToViewResponse{}):           &todopb.AddToViewResponse{},
+	reflect.TypeOf(ShareResponse{}):               &todopb.ShareResponse{},
+	reflect.TypeOf(GetSharesResponse{}):           &todopb.GetSharesResponse{},
+	reflect.TypeOf(AcceptShareResponse{}):         &todopb.AcceptShareResponse{},
+	reflect.TypeOf(DeleteShareResponse{}):         &emptypb.Empty{},
+	reflect.TypeOf(GetSharedWithMeResponse{}):     &todopb.GetSharedWithMeResponse{},
+	reflect.TypeOf(AssignResponse{}):              &todopb.AssignResponse{},
+	reflect.TypeOf(GetAssignedToMeResponse{}):     &todopb.GetAssignedToMeResponse{},
+	reflect.TypeOf(CalendarLinksResponse{}):       &todopb.CalendarLinksResponse{},
+	reflect.TypeOf(RevokeCalendarTokenResponse{}): &emptypb.Empty{},
+	reflect.TypeOf(GetAPIKeysResponse{}):          &todopb.GetAPIKeysResponse{},
+	reflect.TypeOf(CreateAPIKeyResponse{}):        &todopb.CreateAPIKeyResponse{},
+	reflect.TypeOf(RevokeAPIKeyResponse{}):        &emptypb.Empty{},
+	reflect.TypeOf(GetUsersResponse{}):            &todopb.GetUsersResponse{},
+	reflect.TypeOf(GetUserResponse{}):             &todopb.GetUserResponse{},
+	reflect.TypeOf(DeleteUserResponse{}):          &todopb.DeleteUserResponse{},
+	reflect.TypeOf(RegisterResponse{}):            &todopb.RegisterResponse{},
+	reflect.TypeOf(LoginResponse{}):               &todopb.Tokens{},
+	reflect.TypeOf(RefreshResponse{}):             &todopb.Tokens{},
+	reflect.TypeOf(LogoutResponse{}):              &emptypb.Empty{},
+}
+
+// newProtoMessage returns a new, empty, Protobuf message for the Go value v, if the API has one
+func newProtoMessage(v interface{}) (proto.Message, bool) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	m, ok := protoMessages[t]
+	if !ok {
+		return nil, false
+	}
+	return m.ProtoReflect().New().Interface(), true
+}
+
+// jsonToProtobuf re-encodes v's JSON document as its Protobuf message.
+// Messages are encoded deterministically, so the same document always encodes the same, keeping ETags stable.
+func jsonToProtobuf(v interface{}, doc []byte) ([]byte, error) {
+	m, ok := newProtoMessage(v)
+	if !ok {
+		return nil, &NotAcceptableError{Detail: fmt.Sprintf("This response can't be encoded as %s", ProtobufContentType)}
+	}
+	if err := protojson.Unmarshal(doc, m); err != nil {
+		return nil, err
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+}
+
+// protobufToJSON decodes the Protobuf message for v, returning it as v's JSON document
+func protobufToJSON(v interface{}, data []byte) ([]byte, error) {
+	m, ok := newProtoMessage(v)
+	if !ok {
+		return nil, &UnsupportedMediaTypeError{Detail: fmt.Sprintf("This request can't be sent as %s", ProtobufContentType)}
+	}
+	if err := proto.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+}
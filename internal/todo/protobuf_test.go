@@ -0,0 +1,135 @@
+package todo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sinnott74/TodoService/internal/todo/todopb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fill sets every exported field of v, recursively, so each field of its JSON document is present
+func fill(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem())
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			v.Set(reflect.ValueOf(time.Date(2018, 2, 3, 4, 5, 6, 0, time.UTC)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				fill(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if v.Type() == reflect.TypeOf(json.RawMessage{}) {
+			v.SetBytes([]byte(`{}`))
+			return
+		}
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0))
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(map[string]interface{}{"detail": "x"}))
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Float64:
+		v.SetFloat(1.5)
+	}
+}
+
+// TestProtoMessagesMatchJSON tests each type's Protobuf message carries every field of its JSON document
+func TestProtoMessagesMatchJSON(t *testing.T) {
+	for typ := range protoMessages {
+		v := reflect.New(typ)
+		fill(v.Elem())
+		doc, err := json.Marshal(v.Interface())
+		require.NoError(t, err, typ.Name())
+
+		data, err := jsonToProtobuf(v.Interface(), doc)
+		require.NoError(t, err, "Expected %s's message to have every field of %s", typ.Name(), doc)
+		back, err := protobufToJSON(v.Interface(), data)
+		require.NoError(t, err, typ.Name())
+		require.JSONEq(t, string(doc), string(back), typ.Name())
+	}
+
+	_, err := jsonToProtobuf(HealthResponse{}, []byte(`{}`))
+	require.IsType(t, &NotAcceptableError{}, err, "Expected types without a message to be refused")
+}
+
+// newProtobufCall performs a http call with a Protobuf body, accepting a Protobuf response
+func newProtobufCall(t *testing.T, method, url string, body proto.Message) *http.Response {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = proto.Marshal(body)
+		require.NoError(t, err)
+	}
+	return newNegotiatedCall(t, method, url, ProtobufContentType, ProtobufContentType, data)
+}
+
+// readProtobuf decodes a Protobuf response into m
+func readProtobuf(t *testing.T, res *http.Response, m proto.Message) {
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, ProtobufContentType, res.Header.Get("Content-Type"))
+	data, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, proto.Unmarshal(data, m))
+}
+
+// TestProtobufNegotiation tests talking to the API in Protobuf, with the messages in todopb
+func TestProtobufNegotiation(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	due := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	var added todopb.AddResponse
+	readProtobuf(t, newProtobufCall(t, http.MethodPost, server.URL+"/api/todos", &todopb.Todo{Text: "Buffered", Tags: []string{"binary"}, Due: timestamppb.New(due)}), &added)
+	require.Equal(t, "Buffered", added.Todo.Text)
+	require.Equal(t, []string{"binary"}, added.Todo.Tags)
+	require.True(t, due.Equal(added.Todo.Due.AsTime()))
+	require.NotEmpty(t, added.Todo.Id)
+
+	// optional fields are sent when they're set, even to false
+	completed, incomplete := true, false
+	var updated todopb.BulkResponse
+	readProtobuf(t, newProtobufCall(t, http.MethodPatch, server.URL+"/api/todos/bulk", &todopb.BulkUpdateRequest{
+		Filter: &todopb.Filter{Completed: &incomplete},
+		Set:    &todopb.TodoPatch{Completed: &completed},
+	}), &updated)
+	require.Len(t, updated.Results, 1)
+	require.Equal(t, int32(http.StatusOK), updated.Results[0].Status)
+	require.True(t, updated.Results[0].Todo.Completed)
+	require.Equal(t, "Buffered", updated.Results[0].Todo.Text)
+
+	var todos todopb.GetAllForUserResponse
+	readProtobuf(t, newProtobufCall(t, http.MethodGet, server.URL+"/api/todos", nil), &todos)
+	require.Len(t, todos.Todos, 1)
+	require.True(t, todos.Todos[0].Completed)
+
+	var batch todopb.BatchResponse
+	readProtobuf(t, newProtobufCall(t, http.MethodPost, server.URL+"/api/batch", &todopb.BatchRequest{Operations: []*todopb.BatchOperation{
+		{Op: BatchGet, Id: added.Todo.Id},
+		{Op: BatchGet, Id: "missing"},
+	}}), &batch)
+	require.Len(t, batch.Results, 2)
+	require.Equal(t, "Buffered", batch.Results[0].Body.Fields["todo"].GetStructValue().Fields["text"].GetStringValue())
+	require.Equal(t, int32(http.StatusNotFound), batch.Results[1].Status)
+
+	var deleted emptypb.Empty
+	readProtobuf(t, newProtobufCall(t, http.MethodDelete, server.URL+"/api/todos/"+added.Todo.Id, nil), &deleted)
+}
@@ -0,0 +1,484 @@
+package todo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a parsed smart filter query, such as completed:false AND tag:work AND due<7d.
+// It can be evaluated against a Todo by any backend, or translated to SQL with ToSQL.
+type Expr interface {
+	// Match reports whether a Todo satisfies the query, with relative dates resolved against now
+	Match(todo Todo, now time.Time) bool
+	// sql appends the query's SQL condition to b
+	sql(b *sqlBuilder, now time.Time)
+}
+
+type andExpr struct {
+	left, right Expr
+}
+
+func (e andExpr) Match(todo Todo, now time.Time) bool {
+	return e.left.Match(todo, now) && e.right.Match(todo, now)
+}
+
+type orExpr struct {
+	left, right Expr
+}
+
+func (e orExpr) Match(todo Todo, now time.Time) bool {
+	return e.left.Match(todo, now) || e.right.Match(todo, now)
+}
+
+type notExpr struct {
+	expr Expr
+}
+
+func (e notExpr) Match(todo Todo, now time.Time) bool {
+	return !e.expr.Match(todo, now)
+}
+
+// Query fields
+const (
+	fieldCompleted = "completed"
+	fieldTag       = "tag"
+	fieldText      = "text"
+	fieldDue       = "due"
+	fieldCreated   = "created"
+)
+
+// compareExpr compares a single field of a Todo with a value.
+// Bare words in a query are compared with the text field.
+type compareExpr struct {
+	field string
+	op    string
+	value string
+	// flag is the parsed value of a completed comparison
+	flag bool
+	// date is the parsed value of a due or created comparison
+	date dateValue
+}
+
+// dateValue is a point in time in a query, either absolute or relative to now
+type dateValue struct {
+	// none matches Todos without a date, e.g. due:none
+	none bool
+	abs  time.Time
+	rel  time.Duration
+	// isRel is set when the date is relative, e.g. 7d
+	isRel bool
+}
+
+func (d dateValue) resolve(now time.Time) time.Time {
+	if d.isRel {
+		return now.Add(d.rel)
+	}
+	return d.abs
+}
+
+func (e compareExpr) Match(todo Todo, now time.Time) bool {
+	switch e.field {
+	case fieldCompleted:
+		return (todo.Completed == e.flag) != (e.op == "!=")
+	case fieldTag:
+		return todo.HasTag(e.value) != (e.op == "!=")
+	case fieldText:
+		return strings.Contains(strings.ToLower(todo.Text), strings.ToLower(e.value)) != (e.op == "!=")
+	case fieldDue:
+		if e.date.none {
+			return (todo.Due == nil) != (e.op == "!=")
+		}
+		return todo.Due != nil && compareTimes(*todo.Due, e.op, e.date.resolve(now))
+	case fieldCreated:
+		return compareTimes(todo.CreatedOn, e.op, e.date.resolve(now))
+	}
+	return false
+}
+
+// compareTimes compares t with v. Equality is by calendar day, in UTC.
+func compareTimes(t time.Time, op string, v time.Time) bool {
+	switch op {
+	case "<":
+		return t.Before(v)
+	case "<=":
+		return !t.After(v)
+	case ">":
+		return t.After(v)
+	case ">=":
+		return !t.Before(v)
+	case "!=":
+		return !sameDay(t, v)
+	default:
+		return sameDay(t, v)
+	}
+}
+
+func sameDay(a, b time.Time) bool {
+	return startOfDay(a).Equal(startOfDay(b))
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// ParseQuery parses a smart filter query.
+//
+// A query is made of comparisons of a field with a value, e.g. completed:false, tag:work or due<7d,
+// combined with AND, OR, NOT, - and parentheses. Comparisons next to each other are ANDed.
+// Bare words, or "quoted phrases", match Todos whose text contains them.
+//
+// completed takes true or false, tag & text take a word or "quoted phrase".
+// due & created take a date (2006-01-02), a time relative to now (7d, -2w, 12h), now, or for due, none.
+// Dates are compared with :, =, !=, <, <=, > and >=, where : & = match the same day.
+func ParseQuery(query string) (Expr, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, queryError(tok.pos, "unexpected %q", tok.text)
+	}
+	return expr, nil
+}
+
+func queryError(pos int, format string, args ...interface{}) error {
+	return &ValidationError{
+		Detail: "Invalid query",
+		Fields: []FieldError{{"query", fmt.Sprintf("at position %d: ", pos+1) + fmt.Sprintf(format, args...)}},
+	}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokTerm
+)
+
+type queryToken struct {
+	kind tokenKind
+	text string
+	pos  int
+	// field, op & value are set on terms. Bare words have no field or op.
+	field string
+	op    string
+	value string
+}
+
+// lexQuery splits a query into tokens
+func lexQuery(s string) ([]queryToken, error) {
+	var tokens []queryToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, queryToken{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == '-' && i+1 < len(s) && !strings.ContainsRune(" \t\n)", rune(s[i+1])):
+			tokens = append(tokens, queryToken{kind: tokNot, text: "-", pos: i})
+			i++
+		case c == '"':
+			value, end, err := lexQuoted(s, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, queryToken{kind: tokTerm, text: s[i:end], pos: i, value: value})
+			i = end
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\n()\":<>=!", rune(s[i])) {
+				i++
+			}
+			word := s[start:i]
+
+			if i < len(s) && strings.ContainsRune(":<>=!", rune(s[i])) {
+				if word == "" {
+					return nil, queryError(i, "expected a field before %c", s[i])
+				}
+				op := s[i : i+1]
+				if i+1 < len(s) && s[i+1] == '=' && op != ":" && op != "=" {
+					op = s[i : i+2]
+				}
+				if op == "!" {
+					return nil, queryError(i, "expected != but found !")
+				}
+				i += len(op)
+
+				value := ""
+				if i < len(s) && s[i] == '"' {
+					v, end, err := lexQuoted(s, i)
+					if err != nil {
+						return nil, err
+					}
+					value, i = v, end
+				} else {
+					valueStart := i
+					for i < len(s) && !strings.ContainsRune(" \t\n()", rune(s[i])) {
+						i++
+					}
+					value = s[valueStart:i]
+				}
+				tokens = append(tokens, queryToken{kind: tokTerm, text: s[start:i], pos: start, field: strings.ToLower(word), op: op, value: value})
+				continue
+			}
+
+			switch word {
+			case "AND":
+				tokens = append(tokens, queryToken{kind: tokAnd, text: word, pos: start})
+			case "OR":
+				tokens = append(tokens, queryToken{kind: tokOr, text: word, pos: start})
+			case "NOT":
+				tokens = append(tokens, queryToken{kind: tokNot, text: word, pos: start})
+			default:
+				tokens = append(tokens, queryToken{kind: tokTerm, text: word, pos: start, value: word})
+			}
+		}
+	}
+	return append(tokens, queryToken{kind: tokEOF, text: "end of query", pos: len(s)}), nil
+}
+
+// lexQuoted reads the quoted string starting at s[start], returning its contents & the index after the closing quote
+func lexQuoted(s string, start int) (string, int, error) {
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", 0, queryError(start, "unterminated quote")
+	}
+	return s[start+1 : start+1+end], start + end + 2, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *queryParser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case tokAnd:
+			p.next()
+		case tokTerm, tokNot, tokLParen:
+		default:
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+}
+
+func (p *queryParser) parseUnary() (Expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNot:
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	case tokLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, queryError(closing.pos, "expected ) but found %q", closing.text)
+		}
+		return expr, nil
+	case tokTerm:
+		return newCompareExpr(tok)
+	default:
+		return nil, queryError(tok.pos, "unexpected %q", tok.text)
+	}
+}
+
+var relativeDate = regexp.MustCompile(`^([+-]?\d+)([hdw])$`)
+
+// newCompareExpr checks a term's field, operator & value make sense together
+func newCompareExpr(tok queryToken) (Expr, error) {
+	if tok.field == "" {
+		return compareExpr{field: fieldText, op: ":", value: tok.value}, nil
+	}
+
+	e := compareExpr{field: tok.field, op: tok.op, value: tok.value}
+	if e.value == "" {
+		return nil, queryError(tok.pos, "%s needs a value", e.field)
+	}
+
+	switch e.field {
+	case fieldCompleted:
+		if e.op != ":" && e.op != "=" && e.op != "!=" {
+			return nil, queryError(tok.pos, "%s can't be compared with %s", e.field, e.op)
+		}
+		flag, err := strconv.ParseBool(e.value)
+		if err != nil {
+			return nil, queryError(tok.pos, "%s must be true or false", e.field)
+		}
+		e.flag = flag
+	case fieldTag, fieldText:
+		if e.op != ":" && e.op != "=" && e.op != "!=" {
+			return nil, queryError(tok.pos, "%s can't be compared with %s", e.field, e.op)
+		}
+	case fieldDue, fieldCreated:
+		date, err := parseDateValue(e.value)
+		if err != nil || (date.none && e.field != fieldDue) {
+			return nil, queryError(tok.pos, "%s must be a date like 2006-01-02 or relative like 7d", e.field)
+		}
+		if date.none && e.op != ":" && e.op != "=" && e.op != "!=" {
+			return nil, queryError(tok.pos, "%s can't be compared with %s", e.field, e.op)
+		}
+		e.date = date
+	default:
+		return nil, queryError(tok.pos, "unknown field %s", tok.field)
+	}
+	return e, nil
+}
+
+func parseDateValue(value string) (dateValue, error) {
+	switch strings.ToLower(value) {
+	case "none":
+		return dateValue{none: true}, nil
+	case "now":
+		return dateValue{isRel: true}, nil
+	}
+	if m := relativeDate.FindStringSubmatch(value); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return dateValue{}, err
+		}
+		unit := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[m[2]]
+		return dateValue{isRel: true, rel: time.Duration(n) * unit}, nil
+	}
+	abs, err := time.Parse("2006-01-02", value)
+	return dateValue{abs: abs}, err
+}
+
+// sqlBuilder accumulates a SQL condition & its placeholder arguments
+type sqlBuilder struct {
+	strings.Builder
+	args []interface{}
+}
+
+// arg adds a placeholder argument, returning its placeholder
+func (b *sqlBuilder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+// ToSQL translates a query into a Postgres WHERE condition on the todos table,
+// which has the columns text, completed, tags (text[]), due & created_on.
+// Placeholders are numbered after args, which are returned with the query's arguments appended.
+func ToSQL(expr Expr, now time.Time, args ...interface{}) (string, []interface{}) {
+	b := &sqlBuilder{args: args}
+	expr.sql(b, now)
+	return b.String(), b.args
+}
+
+func (e andExpr) sql(b *sqlBuilder, now time.Time) {
+	b.WriteString("(")
+	e.left.sql(b, now)
+	b.WriteString(" AND ")
+	e.right.sql(b, now)
+	b.WriteString(")")
+}
+
+func (e orExpr) sql(b *sqlBuilder, now time.Time) {
+	b.WriteString("(")
+	e.left.sql(b, now)
+	b.WriteString(" OR ")
+	e.right.sql(b, now)
+	b.WriteString(")")
+}
+
+func (e notExpr) sql(b *sqlBuilder, now time.Time) {
+	b.WriteString("NOT ")
+	e.expr.sql(b, now)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (e compareExpr) sql(b *sqlBuilder, now time.Time) {
+	not := ""
+	if e.op == "!=" {
+		not = "NOT "
+	}
+	switch e.field {
+	case fieldCompleted:
+		fmt.Fprintf(b, "%s(completed = %s)", not, b.arg(e.flag))
+	case fieldTag:
+		fmt.Fprintf(b, "%sEXISTS (SELECT 1 FROM unnest(tags) AS tag WHERE lower(tag) = lower(%s))", not, b.arg(e.value))
+	case fieldText:
+		fmt.Fprintf(b, "%s(text ILIKE %s)", not, b.arg("%"+likeEscaper.Replace(e.value)+"%"))
+	case fieldDue, fieldCreated:
+		column := "due"
+		if e.field == fieldCreated {
+			column = "created_on"
+		}
+		if e.date.none {
+			fmt.Fprintf(b, "(%s IS %sNULL)", column, not)
+			return
+		}
+		// a missing due date never matches a comparison, as in Match, rather than making it NULL
+		v := e.date.resolve(now)
+		switch e.op {
+		case "<", "<=", ">", ">=":
+			fmt.Fprintf(b, "COALESCE(%s %s %s, false)", column, e.op, b.arg(v))
+		default:
+			day := startOfDay(v)
+			fmt.Fprintf(b, "COALESCE(%s(%s >= %s AND %s < %s), false)", not, column, b.arg(day), column, b.arg(day.AddDate(0, 0, 1)))
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestQueryMatch checks queries are evaluated against Todos
+func TestQueryMatch(t *testing.T) {
+	now := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	tomorrow := now.AddDate(0, 0, 1)
+	nextMonth := now.AddDate(0, 1, 0)
+
+	work := Todo{Text: "Write report", Tags: []string{"Work"}, Due: &tomorrow, CreatedOn: now.AddDate(0, 0, -10)}
+	home := Todo{Text: "Paint the fence", Tags: []string{"home"}, Due: &nextMonth, CreatedOn: now}
+	done := Todo{Text: "Write shopping list", Completed: true, CreatedOn: now.AddDate(0, 0, -1)}
+
+	cases := []struct {
+		query   string
+		matches []Todo
+	}{
+		{"completed:false", []Todo{work, home}},
+		{"completed:false AND tag:work AND due<7d", []Todo{work}},
+		{"tag:home OR tag:work", []Todo{work, home}},
+		{"write -completed:true", []Todo{work}},
+		{"NOT (tag:work OR tag:home)", []Todo{done}},
+		{`"shopping list"`, []Todo{done}},
+		{"text:FENCE", []Todo{home}},
+		{"due:none", []Todo{done}},
+		{"due!=none", []Todo{work, home}},
+		{"due:2018-10-16", []Todo{work}},
+		{"due>=1w", []Todo{home}},
+		{"created>-2d", []Todo{home, done}},
+		{"tag!=work completed:false", []Todo{home}},
+	}
+	for _, c := range cases {
+		expr, err := ParseQuery(c.query)
+		require.NoErrorf(t, err, "Error parsing %q", c.query)
+
+		matches := []Todo{}
+		for _, todo := range []Todo{work, home, done} {
+			if expr.Match(todo, now) {
+				matches = append(matches, todo)
+			}
+		}
+		require.Equalf(t, c.matches, matches, "Unexpected matches for %q", c.query)
+	}
+}
+
+// TestParseQueryErrors checks invalid queries are reported with their position
+func TestParseQueryErrors(t *testing.T) {
+	cases := map[string]string{
+		"completed:maybe":     "at position 1: completed must be true or false",
+		"priority:high":       "at position 1: unknown field priority",
+		"tag:work AND":        "at position 13: unexpected \"end of query\"",
+		"(tag:work":           "at position 10: expected ) but found \"end of query\"",
+		"due<tomorrow":        "at position 1: due must be a date like 2006-01-02 or relative like 7d",
+		"tag<work":            "at position 1: tag can't be compared with <",
+		`text:"unterminated`:  "at position 6: unterminated quote",
+		"tag:work ) tag:home": "at position 10: unexpected \")\"",
+	}
+	for query, message := range cases {
+		_, err := ParseQuery(query)
+		verr, ok := err.(*ValidationError)
+		require.Truef(t, ok, "Expected a ValidationError for %q, got %v", query, err)
+		require.Equalf(t, []FieldError{{"query", message}}, verr.Fields, "Unexpected error for %q", query)
+	}
+}
+
+// TestToSQL checks queries are translated to parameterised SQL
+func TestToSQL(t *testing.T) {
+	now := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	expr, err := ParseQuery(`completed:false AND (tag:work OR text:"50%") AND due<7d`)
+	require.NoError(t, err, "Error parsing query")
+
+	where, args := ToSQL(expr, now, "test@test.com")
+	require.Equal(t, "(((completed = $2) AND (EXISTS (SELECT 1 FROM unnest(tags) AS tag WHERE lower(tag) = lower($3)) OR (text ILIKE $4))) AND COALESCE(due < $5, false))", where)
+	require.Equal(t, []interface{}{"test@test.com", false, "work", `%50\%%`, now.AddDate(0, 0, 7)}, args)
+
+	expr, err = ParseQuery("-due:none created:2018-10-15")
+	require.NoError(t, err, "Error parsing query")
+
+	where, args = ToSQL(expr, now)
+	require.Equal(t, "(NOT (due IS NULL) AND COALESCE((created_on >= $1 AND created_on < $2), false))", where)
+	require.Equal(t, []interface{}{time.Date(2018, 10, 15, 0, 0, 0, 0, time.UTC), time.Date(2018, 10, 16, 0, 0, 0, 0, time.UTC)}, args)
+}
+
+// TestSavedViews tests saving a view, listing its results & deleting it over HTTP
+func TestSavedViews(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	_, err := todoService.AddMany(context.Background(), []Todo{
+		{Username: "test@test.com", Text: "Write report", Tags: []string{"work"}},
+		{Username: "test@test.com", Text: "Paint the fence", Tags: []string{"home"}},
+	})
+	require.NoError(t, err, "Error adding Todos")
+
+	// Save a view
+	res := newHTTPServerCall(t, http.MethodPost, server.URL+"/api/views", View{Name: "Work", Query: "tag:work completed:false"})
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusOK, res.StatusCode, "Expecting StatusOK when saving a View")
+
+	var addViewResponse AddViewResponse
+	json.NewDecoder(res.Body).Decode(&addViewResponse)
+	require.NotZero(t, addViewResponse.View.ID, "View ID should be set")
+
+	// Names are unique
+	res = newHTTPServerCall(t, http.MethodPost, server.URL+"/api/views", View{Name: "Work", Query: "tag:work"})
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusConflict, res.StatusCode, "Expecting 409 when reusing a View name")
+
+	// Invalid queries can't be saved
+	res = newHTTPServerCall(t, http.MethodPost, server.URL+"/api/views", View{Name: "Broken", Query: "tag<work"})
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusBadRequest, res.StatusCode, "Expecting 400 for an invalid query")
+
+	// List the view's results
+	res = newHTTPServerCall(t, http.MethodGet, server.URL+"/api/views/"+addViewResponse.View.ID, nil)
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusOK, res.StatusCode, "Expecting StatusOK when reading a View")
+
+	var getViewResponse GetViewResponse
+	json.NewDecoder(res.Body).Decode(&getViewResponse)
+	require.Equal(t, 1, len(getViewResponse.Todos), "Expecting 1 Todo in the View")
+	require.Equal(t, "Write report", getViewResponse.Todos[0].Text)
+
+	// Delete the view
+	res = newHTTPServerCall(t, http.MethodDelete, server.URL+"/api/views/"+addViewResponse.View.ID, nil)
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusOK, res.StatusCode, "Expecting StatusOK when deleting a View")
+
+	res = newHTTPServerCall(t, http.MethodGet, server.URL+"/api/views", nil)
+	defer res.Body.Close()
+	var getViewsResponse GetViewsResponse
+	json.NewDecoder(res.Body).Decode(&getViewsResponse)
+	require.Equal(t, 0, len(getViewsResponse.Views), "Expecting no Views")
+}
+
+// TestListWithQuery tests filtering the list of Todos with a query
+func TestListWithQuery(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	_, err := todoService.AddMany(context.Background(), []Todo{
+		{Username: "test@test.com", Text: "Done", Completed: true},
+		{Username: "test@test.com", Text: "Not done"},
+	})
+	require.NoError(t, err, "Error adding Todos")
+
+	res := newHTTPServerCall(t, http.MethodGet, server.URL+"/api/todos?query="+url.QueryEscape("completed:true"), nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var getAllResponse GetAllForUserResponse
+	json.NewDecoder(res.Body).Decode(&getAllResponse)
+	require.Equal(t, 1, len(getAllResponse.Todos), "Expecting only the completed Todo")
+	require.Equal(t, "Done", getAllResponse.Todos[0].Text)
+}
@@ -0,0 +1,340 @@
+package todo
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchResult is a Todo matching a search, with its relevance score.
+// Higher scores are more relevant.
+type SearchResult struct {
+	Todo  Todo    `json:"todo"`
+	Score float64 `json:"score"`
+}
+
+// BM25 tuning parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// searchClause is one part of a search query.
+// Every clause must match for a Todo to be a result.
+type searchClause struct {
+	// terms are stemmed, consecutive terms when the clause is a phrase
+	terms []string
+	// prefix is set instead of terms when the clause is a prefix, e.g. groc*
+	prefix string
+}
+
+// parseSearchQuery splits a query into clauses.
+// Words are matched by their stem, "quoted words" as a phrase and words ending in * as a prefix.
+func parseSearchQuery(q string) ([]searchClause, error) {
+	var clauses []searchClause
+	for len(q) > 0 {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			break
+		}
+
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				return nil, &ValidationError{Detail: "Invalid search query", Fields: []FieldError{{"q", "has an unterminated phrase"}}}
+			}
+			if terms := stemAll(tokenize(q[1 : end+1])); len(terms) > 0 {
+				clauses = append(clauses, searchClause{terms: terms})
+			}
+			q = q[end+2:]
+			continue
+		}
+
+		end := strings.IndexFunc(q, unicode.IsSpace)
+		if end < 0 {
+			end = len(q)
+		}
+		word := q[:end]
+		q = q[end:]
+
+		if strings.HasSuffix(word, "*") {
+			if tokens := tokenize(word); len(tokens) > 0 {
+				// only the last token of a hyphenated prefix is a prefix, e.g. e-mai*
+				if len(tokens) > 1 {
+					clauses = append(clauses, searchClause{terms: stemAll(tokens[:len(tokens)-1])})
+				}
+				clauses = append(clauses, searchClause{prefix: tokens[len(tokens)-1]})
+			}
+			continue
+		}
+
+		// punctuated words, e.g. e-mail, must appear together
+		if terms := stemAll(tokenize(word)); len(terms) > 0 {
+			clauses = append(clauses, searchClause{terms: terms})
+		}
+	}
+	return clauses, nil
+}
+
+// tokenize lower cases text & splits it into words of letters & digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func stemAll(words []string) []string {
+	stems := make([]string, len(words))
+	for i, w := range words {
+		stems[i] = stem(w)
+	}
+	return stems
+}
+
+// stem reduces an English word to its stem, so that e.g. "shopping", "shops" & "shop" match.
+// It implements the first step of the Porter stemmer, which handles plurals & -ed/-ing endings.
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+
+	// step 1a: plurals
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ies"):
+		word = strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ss"):
+	case strings.HasSuffix(word, "s"):
+		word = strings.TrimSuffix(word, "s")
+	}
+
+	// step 1b: -eed, -ed & -ing
+	trimmed := false
+	switch {
+	case strings.HasSuffix(word, "eed"):
+		if measure(strings.TrimSuffix(word, "eed")) > 0 {
+			word = strings.TrimSuffix(word, "d")
+		}
+	case strings.HasSuffix(word, "ed") && hasVowel(strings.TrimSuffix(word, "ed")):
+		word, trimmed = strings.TrimSuffix(word, "ed"), true
+	case strings.HasSuffix(word, "ing") && hasVowel(strings.TrimSuffix(word, "ing")):
+		word, trimmed = strings.TrimSuffix(word, "ing"), true
+	}
+	if trimmed {
+		n := len(word)
+		switch {
+		case strings.HasSuffix(word, "at"), strings.HasSuffix(word, "bl"), strings.HasSuffix(word, "iz"):
+			word += "e"
+		case n >= 2 && word[n-1] == word[n-2] && isConsonant(word, n-1) && !strings.ContainsRune("lsz", rune(word[n-1])):
+			word = word[:n-1]
+		case measure(word) == 1 && endsCVC(word):
+			word += "e"
+		}
+	}
+
+	// step 1c: y to i
+	if strings.HasSuffix(word, "y") && hasVowel(strings.TrimSuffix(word, "y")) {
+		word = strings.TrimSuffix(word, "y") + "i"
+	}
+	return word
+}
+
+// isConsonant reports whether the byte at i is a consonant, as defined by Porter
+func isConsonant(word string, i int) bool {
+	switch word[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !isConsonant(word, i-1)
+	}
+	return true
+}
+
+func hasVowel(word string) bool {
+	for i := range word {
+		if !isConsonant(word, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// measure counts the vowel-consonant sequences in a word, as defined by Porter
+func measure(word string) int {
+	m := 0
+	prevVowel := false
+	for i := range word {
+		vowel := !isConsonant(word, i)
+		if prevVowel && !vowel {
+			m++
+		}
+		prevVowel = vowel
+	}
+	return m
+}
+
+// endsCVC reports whether a word ends consonant-vowel-consonant, where the last consonant isn't w, x or y
+func endsCVC(word string) bool {
+	n := len(word)
+	return n >= 3 && isConsonant(word, n-3) && !isConsonant(word, n-2) && isConsonant(word, n-1) &&
+		!strings.ContainsRune("wxy", rune(word[n-1]))
+}
+
+// searchIndex is an in memory inverted index of Todo text
+type searchIndex struct {
+	// postings maps a stem to the positions it appears at in each Todo
+	postings map[string]map[string][]int
+	// docs maps a Todo ID to its indexed words
+	docs map[string]indexedDoc
+	// words counts the unstemmed words in the index, for prefix matching
+	words       map[string]int
+	totalLength int
+}
+
+// indexedDoc holds a Todo's words, & their stems, in order
+type indexedDoc struct {
+	words []string
+	stems []string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: map[string]map[string][]int{},
+		docs:     map[string]indexedDoc{},
+		words:    map[string]int{},
+	}
+}
+
+// put indexes a Todo's text & tags, replacing any previous version of it
+func (idx *searchIndex) put(todo Todo) {
+	idx.remove(todo.ID)
+
+	doc := indexedDoc{words: tokenize(todo.Text + " " + strings.Join(todo.Tags, " "))}
+	doc.stems = stemAll(doc.words)
+	for pos, s := range doc.stems {
+		if idx.postings[s] == nil {
+			idx.postings[s] = map[string][]int{}
+		}
+		idx.postings[s][todo.ID] = append(idx.postings[s][todo.ID], pos)
+		idx.words[doc.words[pos]]++
+	}
+	idx.docs[todo.ID] = doc
+	idx.totalLength += len(doc.stems)
+}
+
+// remove removes a Todo from the index
+func (idx *searchIndex) remove(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for pos, s := range doc.stems {
+		delete(idx.postings[s], id)
+		if len(idx.postings[s]) == 0 {
+			delete(idx.postings, s)
+		}
+		w := doc.words[pos]
+		if idx.words[w]--; idx.words[w] == 0 {
+			delete(idx.words, w)
+		}
+	}
+	delete(idx.docs, id)
+	idx.totalLength -= len(doc.stems)
+}
+
+// search returns the IDs of Todos matching every clause, with their BM25 relevance scores
+func (idx *searchIndex) search(clauses []searchClause) map[string]float64 {
+	if len(clauses) == 0 || len(idx.docs) == 0 {
+		return nil
+	}
+
+	var scores map[string]float64
+	for _, clause := range clauses {
+		clauseScores := idx.scoreClause(clause)
+		if scores == nil {
+			scores = clauseScores
+			continue
+		}
+		for id, score := range scores {
+			if s, ok := clauseScores[id]; ok {
+				scores[id] = score + s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores
+}
+
+// scoreClause scores every Todo matching a single clause
+func (idx *searchIndex) scoreClause(clause searchClause) map[string]float64 {
+	if clause.prefix != "" {
+		scores := map[string]float64{}
+		seen := map[string]bool{}
+		for w := range idx.words {
+			s := stem(w)
+			if seen[s] || (!strings.HasPrefix(w, clause.prefix) && !strings.HasPrefix(s, clause.prefix)) {
+				continue
+			}
+			seen[s] = true
+			for id, positions := range idx.postings[s] {
+				scores[id] += idx.bm25(len(positions), len(idx.postings[s]), id)
+			}
+		}
+		return scores
+	}
+
+	first := clause.terms[0]
+	scores := map[string]float64{}
+	for id, positions := range idx.postings[first] {
+		freq := 0
+		for _, pos := range positions {
+			if idx.phraseAt(id, clause.terms, pos) {
+				freq++
+			}
+		}
+		if freq == 0 {
+			continue
+		}
+		for _, term := range clause.terms {
+			scores[id] += idx.bm25(freq, len(idx.postings[term]), id)
+		}
+	}
+	return scores
+}
+
+// phraseAt reports whether the terms appear consecutively in a Todo, starting at pos
+func (idx *searchIndex) phraseAt(id string, terms []string, pos int) bool {
+	stems := idx.docs[id].stems
+	if pos+len(terms) > len(stems) {
+		return false
+	}
+	for i, term := range terms {
+		if stems[pos+i] != term {
+			return false
+		}
+	}
+	return true
+}
+
+// bm25 scores a term appearing freq times in a Todo, where docFreq Todos contain the term
+func (idx *searchIndex) bm25(freq, docFreq int, id string) float64 {
+	n := float64(len(idx.docs))
+	idf := math.Log(1 + (n-float64(docFreq)+0.5)/(float64(docFreq)+0.5))
+	avgLength := float64(idx.totalLength) / n
+	length := float64(len(idx.docs[id].stems))
+	tf := float64(freq)
+	return idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/avgLength))
+}
+
+// rankSearchResults orders results by relevance, most relevant first, then newest first
+func rankSearchResults(results []SearchResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Todo.CreatedOn.After(results[j].Todo.CreatedOn)
+	})
+}
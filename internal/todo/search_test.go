@@ -0,0 +1,136 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStem checks words with the same stem are reduced to the same term
+func TestStem(t *testing.T) {
+	cases := map[string]string{
+		"shopping":  "shop",
+		"shops":     "shop",
+		"shop":      "shop",
+		"running":   "run",
+		"caresses":  "caress",
+		"ponies":    "poni",
+		"agreed":    "agree",
+		"hoping":    "hope",
+		"happy":     "happi",
+		"groceries": "groceri",
+		"grocery":   "groceri",
+	}
+	for word, expected := range cases {
+		require.Equalf(t, expected, stem(word), "Unexpected stem for %q", word)
+	}
+}
+
+// TestParseSearchQuery checks words, phrases & prefixes are parsed
+func TestParseSearchQuery(t *testing.T) {
+	clauses, err := parseSearchQuery(`buying "oat milk" groc* e-mail`)
+	require.NoError(t, err, "Error parsing query")
+	require.Equal(t, []searchClause{
+		{terms: []string{"bui"}},
+		{terms: []string{"oat", "milk"}},
+		{prefix: "groc"},
+		{terms: []string{"e", "mail"}},
+	}, clauses)
+
+	_, err = parseSearchQuery(`"oat milk`)
+	require.Error(t, err, "Unterminated phrase should be an error")
+}
+
+// searchTexts adds Todos with the given text & returns the text of the Todos matching query, most relevant first
+func searchTexts(t *testing.T, texts []string, query string) []string {
+	todoService := NewInmemTodoService()
+	for _, text := range texts {
+		_, err := todoService.Add(context.Background(), Todo{Username: "test@test.com", Text: text})
+		require.NoError(t, err, "Error adding a Todo")
+	}
+	results, err := todoService.Search(context.Background(), "test@test.com", query)
+	require.NoError(t, err, "Error searching Todos")
+	found := []string{}
+	for _, result := range results {
+		found = append(found, result.Todo.Text)
+	}
+	return found
+}
+
+// TestSearchStemming checks searches match other forms of a word
+func TestSearchStemming(t *testing.T) {
+	found := searchTexts(t, []string{"Go shopping", "Walk the dog"}, "shops")
+	require.Equal(t, []string{"Go shopping"}, found)
+}
+
+// TestSearchPhrase checks phrases only match consecutive words
+func TestSearchPhrase(t *testing.T) {
+	found := searchTexts(t, []string{"Buy oat milk", "Buy milk and oat bars"}, `"oat milk"`)
+	require.Equal(t, []string{"Buy oat milk"}, found)
+}
+
+// TestSearchPrefix checks prefixes match the start of words
+func TestSearchPrefix(t *testing.T) {
+	found := searchTexts(t, []string{"Pick up groceries", "Call grandma", "Fix the gutter"}, "gr*")
+	require.ElementsMatch(t, []string{"Pick up groceries", "Call grandma"}, found)
+}
+
+// TestSearchRanking checks Todos mentioning a term more often rank higher
+func TestSearchRanking(t *testing.T) {
+	found := searchTexts(t, []string{"Tidy the house and paint the fence", "Paint, paint, paint"}, "paint")
+	require.Equal(t, []string{"Paint, paint, paint", "Tidy the house and paint the fence"}, found)
+}
+
+// TestSearchAfterUpdateAndDelete checks the index follows changes to Todos
+func TestSearchAfterUpdateAndDelete(t *testing.T) {
+	todoService := NewInmemTodoService()
+	username := "test@test.com"
+
+	todo, err := todoService.Add(context.Background(), Todo{Username: username, Text: "Buy milk"})
+	require.NoError(t, err, "Error adding a Todo")
+
+	todo.Text = "Buy bread"
+	require.NoError(t, todoService.Update(context.Background(), username, todo.ID, todo), "Error updating a Todo")
+
+	results, err := todoService.Search(context.Background(), username, "milk")
+	require.NoError(t, err, "Error searching Todos")
+	require.Equal(t, 0, len(results), "Updated text should no longer match")
+
+	results, err = todoService.Search(context.Background(), username, "bread")
+	require.NoError(t, err, "Error searching Todos")
+	require.Equal(t, 1, len(results), "Updated text should match")
+
+	require.NoError(t, todoService.Delete(context.Background(), username, todo.ID), "Error deleting a Todo")
+	results, err = todoService.Search(context.Background(), username, "bread")
+	require.NoError(t, err, "Error searching Todos")
+	require.Equal(t, 0, len(results), "Deleted Todo should not match")
+
+	results, err = todoService.Search(context.Background(), "testANOTHER@test.com", "bread")
+	require.NoError(t, err, "Error searching Todos")
+	require.Equal(t, 0, len(results), "Other users should not see matches")
+}
+
+// TestSearchHTTP checks the search route isn't mistaken for a Todo ID
+func TestSearchHTTP(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	_, err := todoService.Add(context.Background(), Todo{Username: "test@test.com", Text: "Renew passport"})
+	require.NoError(t, err, "Error adding a Todo")
+
+	res := newHTTPServerCall(t, http.MethodGet, server.URL+"/api/todos/search?q=passports", nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var searchResponse SearchResponse
+	json.NewDecoder(res.Body).Decode(&searchResponse)
+	require.Equal(t, 1, len(searchResponse.Results), "Expecting the Todo to be found")
+
+	res = newHTTPServerCall(t, http.MethodGet, server.URL+"/api/todos/search", nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode, "Expecting a query to be required")
+}
@@ -0,0 +1,95 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// ServerConfig limits how long clients can take & how much they can send, so slow or greedy clients can't tie up the server
+type ServerConfig struct {
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+	// MaxBodyBytes caps every request's body
+	MaxBodyBytes int64
+}
+
+// NewServer creates a http.Server serving handler on addr, hardened by config
+func NewServer(addr string, handler http.Handler, config ServerConfig) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           LimitBody(config.MaxBodyBytes)(handler),
+		ReadHeaderTimeout: config.ReadTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+}
+
+// LimitBody is middleware rejecting request bodies larger than n bytes with 413 Request Entity Too Large.
+// Bodies without a Content-Length are cut off once they pass n bytes.
+func LimitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				encodeError(r.Context(), &http.MaxBytesError{Limit: n}, w)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ShutdownFunc flushes or closes something on shutdown, e.g. a store or span exporter, giving up once ctx is done
+type ShutdownFunc func(ctx context.Context) error
+
+// Serve serves each of servers until ctx is done, e.g. on SIGTERM, or one of them fails.
+// The servers then stop accepting connections & drain in-flight requests, after which hooks are run in order.
+// Draining & hooks are given timeout between them. The first error is returned.
+func Serve(ctx context.Context, logger log.Logger, timeout time.Duration, servers []*http.Server, hooks ...ShutdownFunc) error {
+	failed := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			level.Info(logger).Log("msg", "listening", "addr", server.Addr)
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				failed <- err
+			}
+		}(server)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		level.Info(logger).Log("msg", "shutting down", "timeout", timeout)
+	case err = <-failed:
+		level.Error(logger).Log("msg", "server failed, shutting down", "err", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(ctx); shutdownErr != nil {
+			level.Error(logger).Log("msg", "draining connections", "addr", server.Addr, "err", shutdownErr)
+			if err == nil {
+				err = shutdownErr
+			}
+		}
+	}
+	for _, hook := range hooks {
+		if hookErr := hook(ctx); hookErr != nil {
+			level.Error(logger).Log("msg", "shutdown hook failed", "err", hookErr)
+			if err == nil {
+				err = hookErr
+			}
+		}
+	}
+	level.Info(logger).Log("msg", "stopped")
+	return err
+}
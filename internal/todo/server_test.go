@@ -0,0 +1,112 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// freeAddr finds an address a test server can listen on
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// TestServeDrains tests shutting down waits for in-flight requests, then runs the hooks in order
+func TestServeDrains(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server := NewServer(freeAddr(t), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	}), ServerConfig{ReadTimeout: time.Second, WriteTimeout: time.Second, MaxBodyBytes: 1 << 10})
+
+	var hooks []string
+	hook := func(name string) ShutdownFunc {
+		return func(ctx context.Context) error {
+			hooks = append(hooks, name)
+			return nil
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- Serve(ctx, log.NewNopLogger(), time.Second, []*http.Server{server}, hook("spans"), hook("store"))
+	}()
+
+	body := make(chan string)
+	go func() {
+		var res *http.Response
+		var err error
+		require.Eventually(t, func() bool {
+			res, err = http.Get("http://" + server.Addr)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	cancel()
+	select {
+	case <-served:
+		t.Fatal("Expected Serve to wait for the in-flight request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	require.Equal(t, "done", <-body)
+	require.NoError(t, <-served)
+	require.Equal(t, []string{"spans", "store"}, hooks)
+
+	_, err := http.Get("http://" + server.Addr)
+	require.Error(t, err, "Expected new connections to be refused")
+}
+
+// TestServeFails tests a server which can't start shuts the others down & is reported
+func TestServeFails(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	hookErr := errors.New("flush failed")
+
+	err = Serve(context.Background(), log.NewNopLogger(), time.Second,
+		[]*http.Server{NewServer(freeAddr(t), http.NotFoundHandler(), ServerConfig{}), NewServer(l.Addr().String(), http.NotFoundHandler(), ServerConfig{})},
+		func(ctx context.Context) error { return hookErr },
+	)
+	require.Error(t, err)
+	require.NotEqual(t, hookErr, err, "Expected the first error to be reported")
+}
+
+// TestLimitBody tests bodies over the limit are rejected, whether or not their length is given up front
+func TestLimitBody(t *testing.T) {
+	server := httptest.NewServer(LimitBody(1 << 10)(newTestHandler(t, MakeTodoEndpoints(NewInmemTodoService()))))
+	defer server.Close()
+	large := `{"text": "` + strings.Repeat("a", 2<<10) + `"}`
+
+	for _, body := range []io.Reader{strings.NewReader(large), ioutil.NopCloser(strings.NewReader(large))} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/todos", body)
+		req.Header.Set("Authorization", newJWTToken(t))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode, "Content-Length %d", req.ContentLength)
+	}
+
+	res := newHTTPServerCall(t, http.MethodPost, server.URL+"/api/todos", map[string]string{"text": "Small"})
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
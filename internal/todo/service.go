@@ -2,8 +2,10 @@ package todo
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"math/rand"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,19 +17,60 @@ import (
 // TodoService for Todos
 type TodoService interface {
 	GetAllForUser(ctx context.Context, username string) ([]Todo, error)
-	GetByID(ctx context.Context, id string) (Todo, error)
+	// GetByID, Update & Delete act as username, who must own the Todo or have it shared with them
+	GetByID(ctx context.Context, username string, id string) (Todo, error)
 	Add(ctx context.Context, todo Todo) (Todo, error)
-	Update(ctx context.Context, id string, todo Todo) error
-	Delete(ctx context.Context, id string) error
+	Update(ctx context.Context, username string, id string, todo Todo) error
+	Delete(ctx context.Context, username string, id string) error
+	AddMany(ctx context.Context, todos []Todo) ([]Todo, error)
+	UpdateMany(ctx context.Context, username string, filter Filter, patch TodoPatch) ([]BulkResult, error)
+	DeleteMany(ctx context.Context, username string, filter Filter) ([]BulkResult, error)
+	Search(ctx context.Context, username string, query string) ([]SearchResult, error)
+	Query(ctx context.Context, username string, query Expr) ([]Todo, error)
+	GetViewsForUser(ctx context.Context, username string) ([]View, error)
+	GetView(ctx context.Context, username string, id string) (View, error)
+	AddView(ctx context.Context, view View) (View, error)
+	DeleteView(ctx context.Context, username string, id string) error
+	// GetUsers summarises every user with Todos or Views, for administrators
+	GetUsers(ctx context.Context) ([]UserSummary, error)
+	// DeleteUser deletes all of a user's Todos & Views, for administrators
+	DeleteUser(ctx context.Context, username string) (UserSummary, error)
+	// Share invites a user to a Todo or View owned by share.Owner
+	Share(ctx context.Context, share Share) (Share, error)
+	// GetShares gets the shares a user has granted, & those granted to them, including pending invitations
+	GetShares(ctx context.Context, username string) ([]Share, error)
+	AcceptShare(ctx context.Context, username string, id string) (Share, error)
+	// DeleteShare revokes a share, when username is its owner, or declines or leaves it, when it was granted to username
+	DeleteShare(ctx context.Context, username string, id string) error
+	// GetSharedWithUser gets the Todos other users have shared with a user
+	GetSharedWithUser(ctx context.Context, username string) ([]SharedTodo, error)
+	// AddToView adds a Todo, created by username, to the owner of a View username can edit. The Todo must match the View's query.
+	AddToView(ctx context.Context, username string, viewID string, todo Todo) (Todo, error)
+	// Assign makes assignee responsible for a Todo username can edit, or nobody when assignee is empty
+	Assign(ctx context.Context, username string, id string, assignee string) (Todo, error)
+	// GetAssignedToUser gets the Todos assigned to a user which they can still reach
+	GetAssignedToUser(ctx context.Context, username string) ([]Todo, error)
 }
 
 // *** Implementation ***
 
 var (
 	// ErrInconsistentIDs is when the ID of the Entity you are updating differs from the ID given
-	ErrInconsistentIDs = errors.New("Inconsistent IDs")
+	ErrInconsistentIDs = &ValidationError{Detail: "Inconsistent IDs"}
 	// ErrNotFound is when the Entity doesn't exist
-	ErrNotFound = errors.New("Not found")
+	ErrNotFound = &NotFoundError{Detail: "Not found"}
+	// ErrDuplicateViewName is when a user already has a View with the same name
+	ErrDuplicateViewName = &ConflictError{Detail: "A view with this name already exists"}
+	// ErrViewerCantEdit is when a user a Todo is shared with as a viewer tries to change it
+	ErrViewerCantEdit = &ForbiddenError{Detail: "This todo is shared with you as a viewer"}
+	// ErrOwnerOnly is when a user a Todo is shared with tries something only its owner can do
+	ErrOwnerOnly = &ForbiddenError{Detail: "Only the todo's owner can do this"}
+	// ErrAlreadyShared is when a Todo or View is already shared with the invited user
+	ErrAlreadyShared = &ConflictError{Detail: "Already shared with this user"}
+	// ErrInvalidAssignee is when a Todo is assigned to a user who can't edit it
+	ErrInvalidAssignee = &ValidationError{Detail: "Request failed validation", Fields: []FieldError{{"assignee", "must be the todo's owner or a user it's shared with as an editor"}}}
+	// ErrNotInView is when a Todo added to a View doesn't match its query, so wouldn't appear in it
+	ErrNotInView = &ValidationError{Detail: "Request failed validation", Fields: []FieldError{{"todo", "must match the view's query"}}}
 )
 
 // // NewPSQLTodoService creates a Todo service which uses Postgres for persistence
@@ -64,10 +107,14 @@ var (
 // 	return nil
 // }
 
-// NewInmemTodoService creates an in memory Todo service
-func NewInmemTodoService() TodoService {
+// NewInmemTodoService creates an in memory Todo service, which calls hooks after each change to a Todo
+func NewInmemTodoService(hooks ...EventHook) TodoService {
 	s := &inmemService{
-		m: map[string]Todo{},
+		m:       map[string]Todo{},
+		indexes: map[string]*searchIndex{},
+		views:   map[string]View{},
+		shares:  map[string]Share{},
+		hooks:   hooks,
 	}
 	rand.Seed(time.Now().UnixNano())
 	return s
@@ -77,6 +124,11 @@ func NewInmemTodoService() TodoService {
 type inmemService struct {
 	sync.RWMutex
 	m map[string]Todo
+	// indexes holds a full text search index for each user
+	indexes map[string]*searchIndex
+	views   map[string]View
+	shares  map[string]Share
+	hooks   []EventHook
 }
 
 // GetAllForUser gets Todos from memory for a user
@@ -94,12 +146,12 @@ func (s *inmemService) GetAllForUser(ctx context.Context, username string) ([]To
 	return todos, nil
 }
 
-// Get an Todos from the database
-func (s *inmemService) GetByID(ctx context.Context, id string) (Todo, error) {
-	s.Lock()
-	defer s.Unlock()
+// GetByID gets a Todo from memory which username owns or has been shared
+func (s *inmemService) GetByID(ctx context.Context, username string, id string) (Todo, error) {
+	s.RLock()
+	defer s.RUnlock()
 
-	if todo, ok := s.m[id]; ok {
+	if todo, ok := s.m[id]; ok && s.access(username, todo) >= accessViewer {
 		return todo, nil
 	}
 
@@ -108,18 +160,18 @@ func (s *inmemService) GetByID(ctx context.Context, id string) (Todo, error) {
 
 // Add a Todo to memory
 func (s *inmemService) Add(ctx context.Context, todo Todo) (Todo, error) {
+	var events []Event
+	defer func() { s.emit(ctx, events) }()
 	s.Lock()
 	defer s.Unlock()
 
-	todo.ID = xid.New().String()
-	todo.CreatedOn = time.Now()
-
-	s.m[todo.ID] = todo
-	return todo, nil
+	return s.add(todo, &events)
 }
 
-// Update a Todo in memory
-func (s *inmemService) Update(ctx context.Context, id string, todo Todo) error {
+// Update a Todo in memory, which username owns or has been shared as an editor
+func (s *inmemService) Update(ctx context.Context, username string, id string, todo Todo) error {
+	var events []Event
+	defer func() { s.emit(ctx, events) }()
 	s.Lock()
 	defer s.Unlock()
 
@@ -127,23 +179,578 @@ func (s *inmemService) Update(ctx context.Context, id string, todo Todo) error {
 		return ErrInconsistentIDs
 	}
 
-	if _, ok := s.m[id]; !ok {
+	existing, ok := s.m[id]
+	if !ok {
+		return ErrNotFound
+	}
+	switch access := s.access(username, existing); {
+	case access == accessNone:
 		return ErrNotFound
+	case access < accessEditor:
+		return ErrViewerCantEdit
+	}
+	if todo.Username != "" && todo.Username != existing.Username {
+		return &ValidationError{Detail: "Request failed validation", Fields: []FieldError{{"username", "must match the todo's owner"}}}
 	}
 
+	// ownership, creation & assignment can't be changed by an update
+	todo.Username = existing.Username
+	todo.CreatedBy = existing.CreatedBy
+	todo.CreatedOn = existing.CreatedOn
+	todo.Assignee = existing.Assignee
+
 	s.m[todo.ID] = todo
+	s.index(todo)
+	events = append(events, Event{Type: EventUpdated, Todo: todo, Previous: &existing, Actor: username, At: time.Now()})
 	return nil
 }
 
-// Delete a Todo from memory
-func (s *inmemService) Delete(ctx context.Context, id string) error {
+// Delete a Todo from memory. Only its owner can delete it.
+func (s *inmemService) Delete(ctx context.Context, username string, id string) error {
+	var events []Event
+	defer func() { s.emit(ctx, events) }()
 	s.Lock()
 	defer s.Unlock()
 
-	if _, ok := s.m[id]; !ok {
+	todo, ok := s.m[id]
+	switch access := s.access(username, todo); {
+	case !ok || access == accessNone:
 		return ErrNotFound
+	case access < accessOwner:
+		return ErrOwnerOnly
+	}
+
+	s.delete(todo)
+	events = append(events, Event{Type: EventDeleted, Todo: todo, Actor: username, At: time.Now()})
+	return nil
+}
+
+// AddMany adds several Todos to memory in one go
+func (s *inmemService) AddMany(ctx context.Context, todos []Todo) ([]Todo, error) {
+	var events []Event
+	defer func() { s.emit(ctx, events) }()
+	s.Lock()
+	defer s.Unlock()
+
+	// check every assignee first, so either all the Todos are added or none are
+	var errs []FieldError
+	for i, todo := range todos {
+		if todo.Assignee != "" && s.access(todo.Assignee, todo) < accessEditor {
+			errs = append(errs, FieldError{fmt.Sprintf("todos[%d].assignee", i), ErrInvalidAssignee.Fields[0].Message})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Detail: "Request failed validation", Fields: errs}
+	}
+
+	now := time.Now()
+	added := make([]Todo, 0, len(todos))
+	for _, todo := range todos {
+		if todo.CreatedBy == "" {
+			todo.CreatedBy = todo.Username
+		}
+		todo.ID = xid.New().String()
+		todo.CreatedOn = now
+		s.m[todo.ID] = todo
+		s.index(todo)
+		added = append(added, todo)
+		events = append(events, Event{Type: EventAdded, Todo: todo, Actor: todo.CreatedBy, At: now})
+	}
+	return added, nil
+}
+
+// UpdateMany applies a patch to each of a user's Todos matching the filter.
+// All matches are updated under a single lock, so readers never see a partial update.
+func (s *inmemService) UpdateMany(ctx context.Context, username string, filter Filter, patch TodoPatch) ([]BulkResult, error) {
+	var events []Event
+	defer func() { s.emit(ctx, events) }()
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	results := s.selectTodos(username, filter)
+	for i, result := range results {
+		if result.Todo == nil {
+			continue
+		}
+		todo := patch.Apply(*result.Todo)
+		s.m[todo.ID] = todo
+		s.index(todo)
+		results[i].Todo = &todo
+		events = append(events, Event{Type: EventUpdated, Todo: todo, Previous: result.Todo, Actor: username, At: now})
+	}
+	return results, nil
+}
+
+// DeleteMany deletes each of a user's Todos matching the filter.
+// All matches are deleted under a single lock, so readers never see a partial delete.
+func (s *inmemService) DeleteMany(ctx context.Context, username string, filter Filter) ([]BulkResult, error) {
+	var events []Event
+	defer func() { s.emit(ctx, events) }()
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	results := s.selectTodos(username, filter)
+	for i, result := range results {
+		if result.Todo == nil {
+			continue
+		}
+		s.delete(*result.Todo)
+		events = append(events, Event{Type: EventDeleted, Todo: *result.Todo, Actor: username, At: now})
+		results[i].Todo = nil
+	}
+	return results, nil
+}
+
+// Search finds a user's Todos matching a full text query, most relevant first
+func (s *inmemService) Search(ctx context.Context, username string, query string) ([]SearchResult, error) {
+	clauses, err := parseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	results := []SearchResult{}
+	idx, ok := s.indexes[username]
+	if !ok {
+		return results, nil
+	}
+	for id, score := range idx.search(clauses) {
+		results = append(results, SearchResult{Todo: s.m[id], Score: score})
+	}
+	rankSearchResults(results)
+	return results, nil
+}
+
+// Query finds a user's Todos matching a smart filter query, oldest first
+func (s *inmemService) Query(ctx context.Context, username string, query Expr) ([]Todo, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	now := time.Now()
+	todos := []Todo{}
+	for _, todo := range s.m {
+		if todo.Username == username && query.Match(todo, now) {
+			todos = append(todos, todo)
+		}
 	}
+	sort.Slice(todos, func(i, j int) bool { return todos[i].ID < todos[j].ID })
+	return todos, nil
+}
+
+// GetViewsForUser gets a user's Views from memory, ordered by name
+func (s *inmemService) GetViewsForUser(ctx context.Context, username string) ([]View, error) {
+	s.RLock()
+	defer s.RUnlock()
 
-	delete(s.m, id)
+	views := []View{}
+	for _, view := range s.views {
+		if view.Username == username {
+			views = append(views, view)
+		}
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	return views, nil
+}
+
+// GetView gets one of a user's Views from memory
+func (s *inmemService) GetView(ctx context.Context, username string, id string) (View, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if view, ok := s.views[id]; ok && view.Username == username {
+		return view, nil
+	}
+	return View{}, ErrNotFound
+}
+
+// AddView adds a View to memory. View names are unique per user.
+func (s *inmemService) AddView(ctx context.Context, view View) (View, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, v := range s.views {
+		if v.Username == view.Username && v.Name == view.Name {
+			return View{}, ErrDuplicateViewName
+		}
+	}
+
+	expr, err := ParseQuery(view.Query)
+	if err != nil {
+		return View{}, err
+	}
+	view.ID = xid.New().String()
+	view.CreatedOn = time.Now()
+	view.expr = expr
+	s.views[view.ID] = view
+	return view, nil
+}
+
+// DeleteView deletes one of a user's Views from memory
+func (s *inmemService) DeleteView(ctx context.Context, username string, id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if view, ok := s.views[id]; !ok || view.Username != username {
+		return ErrNotFound
+	}
+	delete(s.views, id)
+	for shareID, share := range s.shares {
+		if share.ViewID == id {
+			delete(s.shares, shareID)
+		}
+	}
 	return nil
 }
+
+// GetUsers summarises every user with Todos or Views, ordered by username
+func (s *inmemService) GetUsers(ctx context.Context) ([]UserSummary, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	summaries := map[string]*UserSummary{}
+	summary := func(username string) *UserSummary {
+		if _, ok := summaries[username]; !ok {
+			summaries[username] = &UserSummary{Username: username}
+		}
+		return summaries[username]
+	}
+	for _, todo := range s.m {
+		u := summary(todo.Username)
+		u.Todos++
+		if todo.Completed {
+			u.Completed++
+		}
+	}
+	for _, view := range s.views {
+		summary(view.Username).Views++
+	}
+
+	users := make([]UserSummary, 0, len(summaries))
+	for _, u := range summaries {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// DeleteUser deletes all of a user's Todos & Views from memory, returning what was deleted.
+// Other users' Todos assigned to the user are unassigned.
+func (s *inmemService) DeleteUser(ctx context.Context, username string) (UserSummary, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	deleted := UserSummary{Username: username}
+	for _, todo := range s.m {
+		if todo.Username == username {
+			s.delete(todo)
+			deleted.Todos++
+			if todo.Completed {
+				deleted.Completed++
+			}
+		}
+	}
+	for id, view := range s.views {
+		if view.Username == username {
+			delete(s.views, id)
+			deleted.Views++
+		}
+	}
+	for id, share := range s.shares {
+		if share.Owner == username || share.Username == username {
+			delete(s.shares, id)
+		}
+	}
+	unassigned := 0
+	for id, todo := range s.m {
+		if todo.Assignee == username {
+			todo.Assignee = ""
+			s.m[id] = todo
+			unassigned++
+		}
+	}
+	if deleted.Todos == 0 && deleted.Views == 0 && unassigned == 0 {
+		return UserSummary{}, ErrNotFound
+	}
+	delete(s.indexes, username)
+	return deleted, nil
+}
+
+// Share invites a user to a Todo or View, which must belong to share.Owner
+func (s *inmemService) Share(ctx context.Context, share Share) (Share, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if todo, ok := s.m[share.TodoID]; share.TodoID != "" && (!ok || todo.Username != share.Owner) {
+		return Share{}, ErrNotFound
+	}
+	if view, ok := s.views[share.ViewID]; share.ViewID != "" && (!ok || view.Username != share.Owner) {
+		return Share{}, ErrNotFound
+	}
+	for _, existing := range s.shares {
+		if existing.Username == share.Username && existing.TodoID == share.TodoID && existing.ViewID == share.ViewID {
+			return Share{}, ErrAlreadyShared
+		}
+	}
+
+	share.ID = xid.New().String()
+	share.Accepted = false
+	share.CreatedOn = time.Now()
+	s.shares[share.ID] = share
+	return share, nil
+}
+
+// GetShares gets the shares a user has granted or been granted, oldest first
+func (s *inmemService) GetShares(ctx context.Context, username string) ([]Share, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	shares := []Share{}
+	for _, share := range s.shares {
+		if share.Owner == username || share.Username == username {
+			shares = append(shares, share)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].ID < shares[j].ID })
+	return shares, nil
+}
+
+// AcceptShare accepts an invitation to a Todo or View
+func (s *inmemService) AcceptShare(ctx context.Context, username string, id string) (Share, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	share, ok := s.shares[id]
+	if !ok || share.Username != username {
+		return Share{}, ErrNotFound
+	}
+	share.Accepted = true
+	s.shares[id] = share
+	return share, nil
+}
+
+// DeleteShare deletes a share granted by or to a user
+func (s *inmemService) DeleteShare(ctx context.Context, username string, id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	share, ok := s.shares[id]
+	if !ok || (share.Owner != username && share.Username != username) {
+		return ErrNotFound
+	}
+	delete(s.shares, id)
+	return nil
+}
+
+// GetSharedWithUser gets the Todos shared with a user through accepted shares, oldest first
+func (s *inmemService) GetSharedWithUser(ctx context.Context, username string) ([]SharedTodo, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	owners := map[string]bool{}
+	for _, share := range s.shares {
+		if share.Accepted && share.Username == username {
+			owners[share.Owner] = true
+		}
+	}
+	shared := []SharedTodo{}
+	for _, todo := range s.m {
+		if !owners[todo.Username] {
+			continue
+		}
+		if a := s.access(username, todo); a >= accessViewer {
+			shared = append(shared, SharedTodo{Todo: todo, Access: a.shareAccess()})
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool { return shared[i].Todo.ID < shared[j].Todo.ID })
+	return shared, nil
+}
+
+// AddToView adds a Todo created by username to a View's owner, when username owns the View or has accepted it as an editor
+func (s *inmemService) AddToView(ctx context.Context, username string, viewID string, todo Todo) (Todo, error) {
+	var events []Event
+	defer func() { s.emit(ctx, events) }()
+	s.Lock()
+	defer s.Unlock()
+
+	view, ok := s.views[viewID]
+	allowed := ok && view.Username == username
+	for _, share := range s.shares {
+		if share.ViewID == viewID && share.Username == username && share.Accepted && share.Access == ShareEditor {
+			allowed = true
+		}
+	}
+	if !ok || !allowed {
+		return Todo{}, ErrNotFound
+	}
+
+	todo.Username = view.Username
+	todo.CreatedBy = username
+	if !view.expr.Match(todo, time.Now()) {
+		return Todo{}, ErrNotInView
+	}
+	return s.add(todo, &events)
+}
+
+// Assign sets the assignee of a Todo username can edit
+func (s *inmemService) Assign(ctx context.Context, username string, id string, assignee string) (Todo, error) {
+	var events []Event
+	defer func() { s.emit(ctx, events) }()
+	s.Lock()
+	defer s.Unlock()
+
+	existing, ok := s.m[id]
+	switch access := s.access(username, existing); {
+	case !ok || access == accessNone:
+		return Todo{}, ErrNotFound
+	case access < accessEditor:
+		return Todo{}, ErrViewerCantEdit
+	}
+	if assignee != "" && s.access(assignee, existing) < accessEditor {
+		return Todo{}, ErrInvalidAssignee
+	}
+	if existing.Assignee == assignee {
+		return existing, nil
+	}
+
+	todo := existing
+	todo.Assignee = assignee
+	s.m[todo.ID] = todo
+	events = append(events, Event{Type: EventAssigned, Todo: todo, Previous: &existing, Actor: username, At: time.Now()})
+	return todo, nil
+}
+
+// GetAssignedToUser gets the Todos assigned to a user, oldest first.
+// Todos the user can no longer reach, because a share was revoked, are left out.
+func (s *inmemService) GetAssignedToUser(ctx context.Context, username string) ([]Todo, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	todos := []Todo{}
+	for _, todo := range s.m {
+		if todo.Assignee == username && s.access(username, todo) >= accessViewer {
+			todos = append(todos, todo)
+		}
+	}
+	sort.Slice(todos, func(i, j int) bool { return todos[i].ID < todos[j].ID })
+	return todos, nil
+}
+
+// add stores a new Todo, created by its owner unless CreatedBy says otherwise, recording the event for the caller to emit.
+// The caller must hold the lock.
+func (s *inmemService) add(todo Todo, events *[]Event) (Todo, error) {
+	if todo.CreatedBy == "" {
+		todo.CreatedBy = todo.Username
+	}
+	if todo.Assignee != "" && s.access(todo.Assignee, todo) < accessEditor {
+		return Todo{}, ErrInvalidAssignee
+	}
+	todo.ID = xid.New().String()
+	todo.CreatedOn = time.Now()
+
+	s.m[todo.ID] = todo
+	s.index(todo)
+	*events = append(*events, Event{Type: EventAdded, Todo: todo, Actor: todo.CreatedBy, At: todo.CreatedOn})
+	return todo, nil
+}
+
+// emit calls the hooks with each event.
+// It's deferred before the lock is taken, so hooks run once it's released & may call back into the service.
+func (s *inmemService) emit(ctx context.Context, events []Event) {
+	for _, event := range events {
+		for _, hook := range s.hooks {
+			hook(ctx, event)
+		}
+	}
+}
+
+// access finds the most a user can do with a Todo, as its owner or through accepted shares of it or a View it's in.
+// The caller must hold the lock.
+func (s *inmemService) access(username string, todo Todo) access {
+	if todo.Username == username {
+		return accessOwner
+	}
+	now := time.Now()
+	best := accessNone
+	for _, share := range s.shares {
+		if !share.Accepted || share.Username != username || share.Owner != todo.Username || share.Access.level() <= best {
+			continue
+		}
+		switch {
+		case share.TodoID != "" && share.TodoID == todo.ID:
+			best = share.Access.level()
+		case share.ViewID != "":
+			if view, ok := s.views[share.ViewID]; ok && view.expr.Match(todo, now) {
+				best = share.Access.level()
+			}
+		}
+	}
+	return best
+}
+
+// delete removes a Todo & its shares.
+// The caller must hold the lock.
+func (s *inmemService) delete(todo Todo) {
+	delete(s.m, todo.ID)
+	s.unindex(todo)
+	for id, share := range s.shares {
+		if share.TodoID == todo.ID {
+			delete(s.shares, id)
+		}
+	}
+}
+
+// index adds a Todo to its user's search index.
+// The caller must hold the lock.
+func (s *inmemService) index(todo Todo) {
+	idx, ok := s.indexes[todo.Username]
+	if !ok {
+		idx = newSearchIndex()
+		s.indexes[todo.Username] = idx
+	}
+	idx.put(todo)
+}
+
+// unindex removes a Todo from its user's search index.
+// The caller must hold the lock.
+func (s *inmemService) unindex(todo Todo) {
+	if idx, ok := s.indexes[todo.Username]; ok {
+		idx.remove(todo.ID)
+	}
+}
+
+// selectTodos finds a user's Todos which match the filter.
+// When the filter lists IDs, there is a result for each distinct ID, with a Not found status for IDs which don't match,
+// so a repeated ID isn't changed, or reported to event hooks, twice.
+// The caller must hold the lock.
+func (s *inmemService) selectTodos(username string, filter Filter) []BulkResult {
+	var results []BulkResult
+	if len(filter.IDs) > 0 {
+		seen := make(map[string]bool, len(filter.IDs))
+		for _, id := range filter.IDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			todo, ok := s.m[id]
+			if !ok || todo.Username != username || !filter.Matches(todo) {
+				results = append(results, BulkResult{ID: id, Status: http.StatusNotFound, Error: ErrNotFound.Error()})
+				continue
+			}
+			results = append(results, BulkResult{ID: id, Status: http.StatusOK, Todo: &todo})
+		}
+		return results
+	}
+
+	for id, todo := range s.m {
+		if todo.Username == username && filter.Matches(todo) {
+			todo := todo
+			results = append(results, BulkResult{ID: id, Status: http.StatusOK, Todo: &todo})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results
+}
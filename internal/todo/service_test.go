@@ -2,6 +2,7 @@ package todo
 
 import (
 	"context"
+	"net/http"
 	"testing"
 
 	"github.com/rs/xid"
@@ -30,7 +31,7 @@ func TestAddTodo(t *testing.T) {
 	require.NoError(t, err, "Error reading back Todos")
 	require.Equal(t, addedTodo, todos[0], "Added Todo should be in list of Todos")
 
-	gottenTodo, err := todoService.GetByID(context.Background(), addedTodo.ID)
+	gottenTodo, err := todoService.GetByID(context.Background(), username, addedTodo.ID)
 	require.NoError(t, err, "Error getting Todo by ID")
 	require.Equal(t, addedTodo, gottenTodo, "Added Todo should be in list of Todos")
 }
@@ -56,14 +57,14 @@ func TestDeleteTodo(t *testing.T) {
 	require.Equal(t, 1, len(todos), "Should be only 1 todo")
 	require.Equal(t, addedTodo, todos[0], "Added Todo should be in list of Todos")
 
-	err = todoService.Delete(context.Background(), addedTodo.ID)
+	err = todoService.Delete(context.Background(), username, addedTodo.ID)
 	require.NoError(t, err, "Error deleting Todos")
 
 	todos, err = todoService.GetAllForUser(context.Background(), username)
 	require.NoError(t, err, "Error reading back Todos")
 	require.Equal(t, 0, len(todos), "Should be no Todos")
 
-	_, err = todoService.GetByID(context.Background(), addedTodo.ID)
+	_, err = todoService.GetByID(context.Background(), username, addedTodo.ID)
 	require.Error(t, err, "ErrNotFound expected")
 }
 
@@ -110,14 +111,14 @@ func TestUpdateTodo(t *testing.T) {
 	require.Equal(t, addedTodo, todos[0], "Added Todo should be in list of Todos")
 
 	addedTodo.Completed = true
-	err = todoService.Update(context.Background(), addedTodo.ID, addedTodo)
+	err = todoService.Update(context.Background(), username, addedTodo.ID, addedTodo)
 	require.NoError(t, err, "Error deleting Todos")
 
 	todos, err = todoService.GetAllForUser(context.Background(), username)
 	require.NoError(t, err, "Error reading back Todos")
 	require.Equal(t, 1, len(todos), "Should be no Todos")
 
-	gottenTodo, err := todoService.GetByID(context.Background(), addedTodo.ID)
+	gottenTodo, err := todoService.GetByID(context.Background(), username, addedTodo.ID)
 	require.NoError(t, err, "Error getting updated todo by ID")
 	require.Equal(t, addedTodo, gottenTodo, "Added Todo should be in list of Todos")
 }
@@ -126,7 +127,7 @@ func TestUpdateTodo(t *testing.T) {
 func TestDeleteNotFound(t *testing.T) {
 	todoService := NewInmemTodoService()
 	id := xid.New().String()
-	err := todoService.Delete(context.Background(), id)
+	err := todoService.Delete(context.Background(), "test@test.com", id)
 	require.EqualError(t, err, "Not found", "Not found error expected to be returned")
 }
 
@@ -143,7 +144,7 @@ func TestUpdateNotFound(t *testing.T) {
 		Completed: false,
 	}
 
-	err := todoService.Update(context.Background(), todo.ID, todo)
+	err := todoService.Update(context.Background(), username, todo.ID, todo)
 	require.EqualError(t, err, "Not found", "Not found error expected to be returned")
 }
 
@@ -158,6 +159,71 @@ func TestUpdateInconsistentIDs(t *testing.T) {
 		Completed: false,
 	}
 
-	err := todoService.Update(context.Background(), xid.New().String(), todo)
+	err := todoService.Update(context.Background(), "test@test.com", xid.New().String(), todo)
 	require.EqualError(t, err, "Inconsistent IDs", "Inconsistent IDs error expected to be returned")
 }
+
+// TestAddMany tests adding several Todos at once
+func TestAddMany(t *testing.T) {
+	todoService := NewInmemTodoService()
+
+	username := "test@test.com"
+
+	added, err := todoService.AddMany(context.Background(), []Todo{
+		{Username: username, Text: "First"},
+		{Username: username, Text: "Second"},
+	})
+	require.NoError(t, err, "Error adding Todos")
+	require.Equal(t, 2, len(added), "Should have added 2 Todos")
+	require.NotEqual(t, added[0].ID, added[1].ID, "Added Todos should have distinct IDs")
+
+	todos, err := todoService.GetAllForUser(context.Background(), username)
+	require.NoError(t, err, "Error reading back Todos")
+	require.Equal(t, 2, len(todos), "Should be 2 Todos")
+}
+
+// TestUpdateManyByIDs tests that only the named Todos owned by the user are updated
+func TestUpdateManyByIDs(t *testing.T) {
+	todoService := NewInmemTodoService()
+
+	mine, err := todoService.Add(context.Background(), Todo{Username: "test@test.com", Text: "Mine"})
+	require.NoError(t, err, "Error adding a Todo")
+	theirs, err := todoService.Add(context.Background(), Todo{Username: "testANOTHER@test.com", Text: "Theirs"})
+	require.NoError(t, err, "Error adding a Todo")
+
+	completed := true
+	filter := Filter{IDs: []string{mine.ID, theirs.ID}}
+	results, err := todoService.UpdateMany(context.Background(), "test@test.com", filter, TodoPatch{Completed: &completed})
+	require.NoError(t, err, "Error updating Todos")
+	require.Equal(t, http.StatusOK, results[0].Status, "Own Todo should be updated")
+	require.True(t, results[0].Todo.Completed, "Own Todo should be completed")
+	require.Equal(t, http.StatusNotFound, results[1].Status, "Another user's Todo should not be found")
+
+	gottenTodo, err := todoService.GetByID(context.Background(), "testANOTHER@test.com", theirs.ID)
+	require.NoError(t, err, "Error getting Todo by ID")
+	require.False(t, gottenTodo.Completed, "Another user's Todo should be unchanged")
+}
+
+// TestDeleteManyCompleted tests clearing completed Todos
+func TestDeleteManyCompleted(t *testing.T) {
+	todoService := NewInmemTodoService()
+
+	username := "test@test.com"
+
+	_, err := todoService.AddMany(context.Background(), []Todo{
+		{Username: username, Text: "Done", Completed: true},
+		{Username: username, Text: "Also done", Completed: true},
+		{Username: username, Text: "Not done"},
+	})
+	require.NoError(t, err, "Error adding Todos")
+
+	completed := true
+	results, err := todoService.DeleteMany(context.Background(), username, Filter{Completed: &completed})
+	require.NoError(t, err, "Error deleting Todos")
+	require.Equal(t, 2, len(results), "Should have deleted 2 Todos")
+
+	todos, err := todoService.GetAllForUser(context.Background(), username)
+	require.NoError(t, err, "Error reading back Todos")
+	require.Equal(t, 1, len(todos), "Should be 1 Todo left")
+	require.Equal(t, "Not done", todos[0].Text, "The incomplete Todo should be left")
+}
@@ -0,0 +1,153 @@
+package todo
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// ShareAccess is what a user a Todo or View is shared with can do
+type ShareAccess string
+
+const (
+	// ShareViewer can read the shared Todos
+	ShareViewer ShareAccess = "viewer"
+	// ShareEditor can read & update the shared Todos, but not delete them
+	ShareEditor ShareAccess = "editor"
+)
+
+// Share grants a user access to another user's Todo, or to every Todo in their View.
+// It's an invitation until the user accepts it.
+type Share struct {
+	ID string `json:"id"`
+	// Owner is the user sharing their Todo or View
+	Owner string `json:"owner"`
+	// Username is the user it's shared with
+	Username  string      `json:"username"`
+	TodoID    string      `json:"todo_id,omitempty"`
+	ViewID    string      `json:"view_id,omitempty"`
+	Access    ShareAccess `json:"access"`
+	Accepted  bool        `json:"accepted"`
+	CreatedOn time.Time   `json:"created_on"`
+}
+
+// SharedTodo is a Todo shared with the user, with the access they've been given
+type SharedTodo struct {
+	Todo   Todo        `json:"todo"`
+	Access ShareAccess `json:"access"`
+}
+
+// access ranks what a user can do with a Todo
+type access int
+
+const (
+	accessNone access = iota
+	accessViewer
+	accessEditor
+	accessOwner
+)
+
+func (a ShareAccess) level() access {
+	switch a {
+	case ShareViewer:
+		return accessViewer
+	case ShareEditor:
+		return accessEditor
+	}
+	return accessNone
+}
+
+// shareAccess is the ShareAccess granting a level of access
+func (a access) shareAccess() ShareAccess {
+	if a >= accessEditor {
+		return ShareEditor
+	}
+	return ShareViewer
+}
+
+type ShareRequest struct {
+	Username      string      `json:"username"`
+	Access        ShareAccess `json:"access"`
+	TodoID        string      `json:"-"`
+	ViewID        string      `json:"-"`
+	unknownFields []string
+}
+
+type ShareResponse struct {
+	Share Share `json:"share"`
+}
+
+// MakeShareEndpoint returns an endpoint which invites a user to one of the user's Todos or Views
+func MakeShareEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ShareRequest)
+		share, err := s.Share(ctx, Share{
+			Owner:    ctx.Value("username").(string),
+			Username: req.Username,
+			TodoID:   req.TodoID,
+			ViewID:   req.ViewID,
+			Access:   req.Access,
+		})
+		return ShareResponse{share}, err
+	}
+}
+
+type GetSharesRequest struct {
+}
+
+type GetSharesResponse struct {
+	Shares []Share `json:"shares"`
+}
+
+func MakeGetSharesEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		shares, err := s.GetShares(ctx, ctx.Value("username").(string))
+		return GetSharesResponse{shares}, err
+	}
+}
+
+type AcceptShareRequest struct {
+	ID string
+}
+
+type AcceptShareResponse struct {
+	Share Share `json:"share"`
+}
+
+func MakeAcceptShareEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AcceptShareRequest)
+		share, err := s.AcceptShare(ctx, ctx.Value("username").(string), req.ID)
+		return AcceptShareResponse{share}, err
+	}
+}
+
+type DeleteShareRequest struct {
+	ID string
+}
+
+type DeleteShareResponse struct {
+}
+
+func MakeDeleteShareEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeleteShareRequest)
+		err := s.DeleteShare(ctx, ctx.Value("username").(string), req.ID)
+		return DeleteShareResponse{}, err
+	}
+}
+
+type GetSharedWithMeRequest struct {
+}
+
+type GetSharedWithMeResponse struct {
+	Todos []SharedTodo `json:"todos"`
+}
+
+func MakeGetSharedWithMeEndpoint(s TodoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		todos, err := s.GetSharedWithUser(ctx, ctx.Value("username").(string))
+		return GetSharedWithMeResponse{todos}, err
+	}
+}
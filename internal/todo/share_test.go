@@ -0,0 +1,158 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTodosArePrivateByDefault tests a Todo can't be read or changed by ID by another user
+func TestTodosArePrivateByDefault(t *testing.T) {
+	s := NewInmemTodoService()
+	ctx := context.Background()
+	todo, _ := s.Add(ctx, Todo{Username: "owner@test.com", Text: "Private"})
+
+	_, err := s.GetByID(ctx, "other@test.com", todo.ID)
+	require.Equal(t, ErrNotFound, err)
+	require.Equal(t, ErrNotFound, s.Update(ctx, "other@test.com", todo.ID, todo))
+	require.Equal(t, ErrNotFound, s.Delete(ctx, "other@test.com", todo.ID))
+
+	todo.Username = "other@test.com"
+	require.Error(t, s.Update(ctx, "owner@test.com", todo.ID, todo), "Expected the owner not to give the Todo away")
+}
+
+// TestShareTodo tests a shared Todo is only reachable once accepted, according to its access
+func TestShareTodo(t *testing.T) {
+	s := NewInmemTodoService()
+	ctx := context.Background()
+	todo, _ := s.Add(ctx, Todo{Username: "owner@test.com", Text: "Milk"})
+
+	_, err := s.Share(ctx, Share{Owner: "other@test.com", Username: "friend@test.com", TodoID: todo.ID, Access: ShareViewer})
+	require.Equal(t, ErrNotFound, err, "Expected only the owner to share a Todo")
+
+	share, err := s.Share(ctx, Share{Owner: "owner@test.com", Username: "friend@test.com", TodoID: todo.ID, Access: ShareViewer})
+	require.NoError(t, err)
+	_, err = s.Share(ctx, Share{Owner: "owner@test.com", Username: "friend@test.com", TodoID: todo.ID, Access: ShareEditor})
+	require.Equal(t, ErrAlreadyShared, err)
+
+	_, err = s.GetByID(ctx, "friend@test.com", todo.ID)
+	require.Equal(t, ErrNotFound, err, "Expected an invitation to grant nothing until accepted")
+
+	_, err = s.AcceptShare(ctx, "owner@test.com", share.ID)
+	require.Equal(t, ErrNotFound, err, "Expected only the invited user to accept")
+	_, err = s.AcceptShare(ctx, "friend@test.com", share.ID)
+	require.NoError(t, err)
+
+	got, err := s.GetByID(ctx, "friend@test.com", todo.ID)
+	require.NoError(t, err)
+	require.Equal(t, todo, got)
+	got.Completed = true
+	require.Equal(t, ErrViewerCantEdit, s.Update(ctx, "friend@test.com", todo.ID, got))
+
+	require.NoError(t, s.DeleteShare(ctx, "owner@test.com", share.ID))
+	share, _ = s.Share(ctx, Share{Owner: "owner@test.com", Username: "friend@test.com", TodoID: todo.ID, Access: ShareEditor})
+	s.AcceptShare(ctx, "friend@test.com", share.ID)
+
+	require.NoError(t, s.Update(ctx, "friend@test.com", todo.ID, got))
+	updated, _ := s.GetByID(ctx, "owner@test.com", todo.ID)
+	require.True(t, updated.Completed)
+	require.Equal(t, "owner@test.com", updated.Username, "Expected the owner to stay the same")
+	require.Equal(t, ErrOwnerOnly, s.Delete(ctx, "friend@test.com", todo.ID))
+
+	// leaving the share
+	require.NoError(t, s.DeleteShare(ctx, "friend@test.com", share.ID))
+	_, err = s.GetByID(ctx, "friend@test.com", todo.ID)
+	require.Equal(t, ErrNotFound, err)
+}
+
+// TestShareView tests sharing a View shares every Todo it matches, now & later
+func TestShareView(t *testing.T) {
+	s := NewInmemTodoService()
+	ctx := context.Background()
+	milk, _ := s.Add(ctx, Todo{Username: "owner@test.com", Text: "Milk", Tags: []string{"groceries"}})
+	s.Add(ctx, Todo{Username: "owner@test.com", Text: "Tax return"})
+	view, err := s.AddView(ctx, View{Username: "owner@test.com", Name: "Groceries", Query: "tag:groceries"})
+	require.NoError(t, err)
+	require.NotNil(t, view.expr, "Expected the View's query to be parsed once, when it's added")
+	got, _ := s.GetView(ctx, "owner@test.com", view.ID)
+	query, err := got.parsedQuery()
+	require.NoError(t, err)
+	require.Equal(t, view.expr, query, "Expected the View's stored query to be used")
+	_, err = s.AddView(ctx, View{Username: "owner@test.com", Name: "Broken", Query: "due<"})
+	require.IsType(t, &ValidationError{}, err)
+
+	share, err := s.Share(ctx, Share{Owner: "owner@test.com", Username: "friend@test.com", ViewID: view.ID, Access: ShareEditor})
+	require.NoError(t, err)
+	s.AcceptShare(ctx, "friend@test.com", share.ID)
+
+	bread, _ := s.Add(ctx, Todo{Username: "owner@test.com", Text: "Bread", Tags: []string{"groceries"}})
+	shared, err := s.GetSharedWithUser(ctx, "friend@test.com")
+	require.NoError(t, err)
+	require.Equal(t, []SharedTodo{{milk, ShareEditor}, {bread, ShareEditor}}, shared)
+
+	shares, _ := s.GetShares(ctx, "owner@test.com")
+	require.Len(t, shares, 1)
+
+	// deleting the View revokes its shares
+	require.NoError(t, s.DeleteView(ctx, "owner@test.com", view.ID))
+	shared, _ = s.GetSharedWithUser(ctx, "friend@test.com")
+	require.Empty(t, shared)
+	shares, _ = s.GetShares(ctx, "friend@test.com")
+	require.Empty(t, shares)
+}
+
+// TestShareHTTP tests inviting a user to a Todo, who accepts & edits it
+func TestShareHTTP(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+	owner := newToken(t, jwt.MapClaims{"username": "owner@test.com"})
+	friend := newToken(t, jwt.MapClaims{"username": "friend@test.com"})
+	todo, _ := todoService.Add(context.Background(), Todo{Username: "owner@test.com", Text: "Sprint planning"})
+
+	res := newCall(t, http.MethodGet, server.URL+"/api/todos/"+todo.ID, "JWT", friend, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode, "Expected other users' Todos to be hidden")
+
+	res = newCall(t, http.MethodPost, server.URL+"/api/todos/"+todo.ID+"/shares", "JWT", owner, map[string]string{"username": "owner@test.com", "access": "admin"})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = newCall(t, http.MethodPost, server.URL+"/api/todos/"+todo.ID+"/shares", "JWT", owner, map[string]string{"username": "friend@test.com", "access": "editor"})
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var invited ShareResponse
+	json.NewDecoder(res.Body).Decode(&invited)
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/shares", "JWT", friend, nil)
+	defer res.Body.Close()
+	var invitations GetSharesResponse
+	json.NewDecoder(res.Body).Decode(&invitations)
+	require.Len(t, invitations.Shares, 1)
+	require.False(t, invitations.Shares[0].Accepted)
+
+	res = newCall(t, http.MethodPost, server.URL+"/api/shares/"+invited.Share.ID+"/accept", "JWT", friend, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/shared", "JWT", friend, nil)
+	defer res.Body.Close()
+	var shared GetSharedWithMeResponse
+	json.NewDecoder(res.Body).Decode(&shared)
+	require.Len(t, shared.Todos, 1)
+	require.Equal(t, ShareEditor, shared.Todos[0].Access)
+
+	edited := shared.Todos[0].Todo
+	edited.Completed = true
+	res = newCall(t, http.MethodPut, server.URL+"/api/todos/"+todo.ID, "JWT", friend, edited)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = newCall(t, http.MethodDelete, server.URL+"/api/todos/"+todo.ID, "JWT", friend, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+}
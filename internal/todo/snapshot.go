@@ -0,0 +1,376 @@
+package todo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshotter is implemented by in memory stores, which save their contents on shutdown & are restored from them at startup
+type Snapshotter interface {
+	// Snapshot encodes the store's contents
+	Snapshot() ([]byte, error)
+	// Restore replaces the store's contents with a snapshot's
+	Restore(data []byte) error
+}
+
+// Snapshots are Snapshotters by name, e.g. todos, which are saved to the same file
+type Snapshots map[string]Snapshotter
+
+// Save writes every store's snapshot to file. Snapshots hold password hashes & calendar tokens, so only the owner can read it.
+// The file is replaced once the snapshot is complete, so a failed save leaves the last snapshot intact.
+func (s Snapshots) Save(file string) error {
+	stores := make(map[string]json.RawMessage, len(s))
+	for name, store := range s {
+		data, err := store.Snapshot()
+		if err != nil {
+			return fmt.Errorf("snapshotting %s: %w", name, err)
+		}
+		stores[name] = data
+	}
+	data, err := json.Marshal(stores)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Restore restores each store from its snapshot in file.
+// There's nothing to restore on the first start, when file doesn't exist, or for stores which weren't saved.
+func (s Snapshots) Restore(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stores map[string]json.RawMessage
+	if err := json.Unmarshal(data, &stores); err != nil {
+		return fmt.Errorf("reading snapshot %s: %w", file, err)
+	}
+	for name, store := range s {
+		if data, ok := stores[name]; ok {
+			if err := store.Restore(data); err != nil {
+				return fmt.Errorf("restoring %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Saver returns a ShutdownFunc which saves the snapshots to file, once requests have drained
+func (s Snapshots) Saver(file string) ShutdownFunc {
+	return func(ctx context.Context) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return s.Save(file)
+	}
+}
+
+// inmemSnapshot is the contents of an inmemService. Search indexes & parsed queries are rebuilt on restore.
+type inmemSnapshot struct {
+	Todos  []Todo  `json:"todos"`
+	Views  []View  `json:"views"`
+	Shares []Share `json:"shares"`
+}
+
+// Snapshot implements Snapshotter
+func (s *inmemService) Snapshot() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	snapshot := inmemSnapshot{Todos: []Todo{}, Views: []View{}, Shares: []Share{}}
+	for _, todo := range s.m {
+		snapshot.Todos = append(snapshot.Todos, todo)
+	}
+	for _, view := range s.views {
+		snapshot.Views = append(snapshot.Views, view)
+	}
+	for _, share := range s.shares {
+		snapshot.Shares = append(snapshot.Shares, share)
+	}
+	return json.Marshal(snapshot)
+}
+
+// Restore implements Snapshotter. Hooks aren't called, as nothing has changed since the snapshot.
+func (s *inmemService) Restore(data []byte) error {
+	var snapshot inmemSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	views := make(map[string]View, len(snapshot.Views))
+	for _, view := range snapshot.Views {
+		expr, err := ParseQuery(view.Query)
+		if err != nil {
+			return fmt.Errorf("view %s: %w", view.ID, err)
+		}
+		view.expr = expr
+		views[view.ID] = view
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.m = make(map[string]Todo, len(snapshot.Todos))
+	s.indexes = map[string]*searchIndex{}
+	for _, todo := range snapshot.Todos {
+		s.m[todo.ID] = todo
+		s.index(todo)
+	}
+	s.views = views
+	s.shares = make(map[string]Share, len(snapshot.Shares))
+	for _, share := range snapshot.Shares {
+		s.shares[share.ID] = share
+	}
+	return nil
+}
+
+// Snapshot implements Snapshotter, snapshotting the service of each tenant which has been used
+func (s *tenantService) Snapshot() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	tenants := map[string]json.RawMessage{}
+	for tenant, t := range s.services {
+		if snapshotter, ok := t.TodoService.(Snapshotter); ok {
+			data, err := snapshotter.Snapshot()
+			if err != nil {
+				return nil, fmt.Errorf("tenant %q: %w", tenant, err)
+			}
+			tenants[tenant] = data
+		}
+	}
+	return json.Marshal(tenants)
+}
+
+// Restore implements Snapshotter, making the service of each tenant in the snapshot.
+// Tenants which are no longer hosted are kept, though they can't be reached, so they're saved again rather than lost.
+func (s *tenantService) Restore(data []byte) error {
+	var tenants map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for tenant, data := range tenants {
+		t := &tenantTodos{TodoService: s.newService(tenant), config: s.tenants[tenant]}
+		snapshotter, ok := t.TodoService.(Snapshotter)
+		if !ok {
+			return fmt.Errorf("tenant %q: service can't be restored", tenant)
+		}
+		if err := snapshotter.Restore(data); err != nil {
+			return fmt.Errorf("tenant %q: %w", tenant, err)
+		}
+		s.services[tenant] = t
+	}
+	return nil
+}
+
+// apiKeySnapshot is an APIKey with the hash of its secret, which is otherwise never encoded
+type apiKeySnapshot struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// Snapshot implements Snapshotter
+func (s *inmemAPIKeyService) Snapshot() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	keys := make([]apiKeySnapshot, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, apiKeySnapshot{key, key.hash})
+	}
+	return json.Marshal(keys)
+}
+
+// Restore implements Snapshotter
+func (s *inmemAPIKeyService) Restore(data []byte) error {
+	var keys []apiKeySnapshot
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.keys = make(map[string]APIKey, len(keys))
+	s.byHash = make(map[string]string, len(keys))
+	for _, key := range keys {
+		key.APIKey.hash = key.Hash
+		s.keys[key.ID] = key.APIKey
+		s.byHash[key.Hash] = key.ID
+	}
+	return nil
+}
+
+// calendarTokenSnapshot is a user's calendar token
+type calendarTokenSnapshot struct {
+	Tenant   string `json:"tenant,omitempty"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// Snapshot implements Snapshotter
+func (s *inmemCalendarTokenService) Snapshot() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	tokens := make([]calendarTokenSnapshot, 0, len(s.tokens))
+	for user, token := range s.tokens {
+		tokens = append(tokens, calendarTokenSnapshot{user.tenant, user.username, token})
+	}
+	return json.Marshal(tokens)
+}
+
+// Restore implements Snapshotter
+func (s *inmemCalendarTokenService) Restore(data []byte) error {
+	var tokens []calendarTokenSnapshot
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.tokens = make(map[calendarUser]string, len(tokens))
+	s.users = make(map[string]calendarUser, len(tokens))
+	for _, t := range tokens {
+		user := calendarUser{t.Tenant, t.Username}
+		s.tokens[user] = t.Token
+		s.users[t.Token] = user
+	}
+	return nil
+}
+
+// accountSnapshot is the contents of an inmemAccountService
+type accountSnapshot struct {
+	Accounts      []accountWithHash      `json:"accounts"`
+	RefreshTokens []refreshTokenSnapshot `json:"refresh_tokens"`
+}
+
+// accountWithHash is an Account with its password hash, which is otherwise never encoded
+type accountWithHash struct {
+	Account
+	PasswordHash []byte `json:"password_hash"`
+}
+
+// refreshTokenSnapshot is an issued refresh token, by its hash
+type refreshTokenSnapshot struct {
+	Hash     string    `json:"hash"`
+	Username string    `json:"username"`
+	Family   string    `json:"family"`
+	Expires  time.Time `json:"expires"`
+	Used     bool      `json:"used"`
+}
+
+// Snapshot implements Snapshotter. Expired refresh tokens are left out.
+func (s *inmemAccountService) Snapshot() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	snapshot := accountSnapshot{
+		Accounts:      make([]accountWithHash, 0, len(s.accounts)),
+		RefreshTokens: []refreshTokenSnapshot{},
+	}
+	for _, account := range s.accounts {
+		snapshot.Accounts = append(snapshot.Accounts, accountWithHash{account, account.PasswordHash})
+	}
+	now := time.Now()
+	for hash, token := range s.refreshTokens {
+		if now.Before(token.expires) {
+			snapshot.RefreshTokens = append(snapshot.RefreshTokens, refreshTokenSnapshot{hash, token.username, token.family, token.expires, token.used})
+		}
+	}
+	return json.Marshal(snapshot)
+}
+
+// Restore implements Snapshotter
+func (s *inmemAccountService) Restore(data []byte) error {
+	var snapshot accountSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.accounts = make(map[string]Account, len(snapshot.Accounts))
+	for _, account := range snapshot.Accounts {
+		account.Account.PasswordHash = account.PasswordHash
+		s.accounts[account.Username] = account.Account
+	}
+	s.refreshTokens = make(map[string]*refreshToken, len(snapshot.RefreshTokens))
+	for _, t := range snapshot.RefreshTokens {
+		s.refreshTokens[t.Hash] = &refreshToken{username: t.Username, family: t.Family, expires: t.Expires, used: t.Used}
+	}
+	return nil
+}
+
+// idempotencySnapshot is a stored response, by its key
+type idempotencySnapshot struct {
+	Key         string      `json:"key"`
+	Fingerprint []byte      `json:"fingerprint"`
+	Expires     time.Time   `json:"expires"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// Snapshot implements Snapshotter. Responses still being produced, or which have expired, are left out.
+func (s *IdempotencyStore) Snapshot() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	responses := []idempotencySnapshot{}
+	for key, res := range s.m {
+		if res.status != 0 && now.Before(res.expires) {
+			responses = append(responses, idempotencySnapshot{key, res.fingerprint[:], res.expires, res.status, res.header, res.body})
+		}
+	}
+	return json.Marshal(responses)
+}
+
+// Restore implements Snapshotter
+func (s *IdempotencyStore) Restore(data []byte) error {
+	var responses []idempotencySnapshot
+	if err := json.Unmarshal(data, &responses); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.m = make(map[string]*storedResponse, len(responses))
+	for _, r := range responses {
+		if len(r.Fingerprint) != sha256.Size {
+			return fmt.Errorf("response to %q has a malformed fingerprint", r.Key)
+		}
+		res := &storedResponse{expires: r.Expires, status: r.Status, header: r.Header, body: r.Body}
+		copy(res.fingerprint[:], r.Fingerprint)
+		s.m[r.Key] = res
+	}
+	return nil
+}
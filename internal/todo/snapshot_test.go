@@ -0,0 +1,91 @@
+package todo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSnapshotRestore tests every in memory store can be saved to a file & restored, as it is across a restart
+func TestSnapshotRestore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	ctx := tenantContext("acme")
+	todos, apiKeys, calendars, accounts, idempotency := newTenantService(nil), NewInmemAPIKeyService(), NewInmemCalendarTokenService(), newTestAccountService(t), NewIdempotencyStore(time.Hour)
+
+	todo, err := todos.Add(ctx, Todo{Username: "same@test.com", Text: "Paint the fence", Tags: []string{"chores"}})
+	require.NoError(t, err)
+	view, err := todos.AddView(ctx, View{Username: "same@test.com", Name: "Chores", Query: "tag:chores"})
+	require.NoError(t, err)
+	share, err := todos.Share(ctx, Share{Owner: "same@test.com", Username: "friend@test.com", TodoID: todo.ID, Access: ShareViewer})
+	require.NoError(t, err)
+	_, err = todos.AcceptShare(ctx, "friend@test.com", share.ID)
+	require.NoError(t, err)
+	key, secret, err := apiKeys.Create(ctx, APIKey{Username: "same@test.com", Name: "CI", Scopes: []string{ScopeTodosRead}})
+	require.NoError(t, err)
+	calendarToken, err := calendars.Get(ctx, "same@test.com")
+	require.NoError(t, err)
+	_, err = accounts.Register(ctx, "same@test.com", "correct horse")
+	require.NoError(t, err)
+	tokens, err := accounts.Login(ctx, "same@test.com", "correct horse")
+	require.NoError(t, err)
+	fingerprint := [32]byte{1}
+	idempotency.begin("key", fingerprint)
+	idempotency.finish("key", http.StatusCreated, http.Header{"Content-Type": {"application/json"}}, []byte("{}"))
+	idempotency.begin("in-progress", fingerprint)
+
+	snapshots := Snapshots{"todos": todos.(Snapshotter), "api_keys": apiKeys.(Snapshotter), "calendar_tokens": calendars.(Snapshotter), "accounts": accounts, "idempotency": idempotency}
+	require.NoError(t, snapshots.Saver(file)(context.Background()))
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Expected the snapshot to be private")
+
+	todos, apiKeys, calendars, accounts, idempotency = newTenantService(nil), NewInmemAPIKeyService(), NewInmemCalendarTokenService(), newTestAccountService(t), NewIdempotencyStore(time.Hour)
+	snapshots = Snapshots{"todos": todos.(Snapshotter), "api_keys": apiKeys.(Snapshotter), "calendar_tokens": calendars.(Snapshotter), "accounts": accounts, "idempotency": idempotency}
+	require.NoError(t, snapshots.Restore(file))
+
+	got, err := todos.GetByID(ctx, "friend@test.com", todo.ID)
+	require.NoError(t, err, "Expected the share to be restored")
+	require.Equal(t, todo.Text, got.Text)
+	_, err = todos.GetByID(tenantContext("globex"), "same@test.com", todo.ID)
+	require.Equal(t, ErrNotFound, err, "Expected the Todo to stay in its tenant")
+	results, err := todos.Search(ctx, "same@test.com", "fence")
+	require.NoError(t, err)
+	require.Len(t, results, 1, "Expected the search index to be rebuilt")
+	gotView, err := todos.GetView(ctx, "same@test.com", view.ID)
+	require.NoError(t, err)
+	require.NotNil(t, gotView.expr, "Expected the View's query to be parsed")
+
+	gotKey, err := apiKeys.Authenticate(ctx, secret)
+	require.NoError(t, err)
+	require.Equal(t, key.ID, gotKey.ID)
+	tenant, username, err := calendars.Authenticate(ctx, calendarToken)
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme", "same@test.com"}, []string{tenant, username})
+	_, err = accounts.Login(ctx, "same@test.com", "correct horse")
+	require.NoError(t, err, "Expected the password hash to be restored")
+	_, err = accounts.Refresh(ctx, tokens.RefreshToken)
+	require.NoError(t, err, "Expected the refresh token to be restored")
+
+	stored, isNew := idempotency.begin("key", fingerprint)
+	require.False(t, isNew)
+	require.Equal(t, http.StatusCreated, stored.status)
+	require.Equal(t, "application/json", stored.header.Get("Content-Type"))
+	_, isNew = idempotency.begin("in-progress", fingerprint)
+	require.True(t, isNew, "Expected a response which was never finished not to be restored")
+}
+
+// TestSnapshotRestoreFirstStart tests there's nothing to restore before a snapshot has been saved, but a corrupt one fails
+func TestSnapshotRestoreFirstStart(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	snapshots := Snapshots{"idempotency": NewIdempotencyStore(time.Hour)}
+	require.NoError(t, snapshots.Restore(file))
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"idempotency": `), 0600))
+	require.Error(t, snapshots.Restore(file))
+}
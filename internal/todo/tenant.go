@@ -0,0 +1,336 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// TenantConfig configures an organisation hosted by the service
+type TenantConfig struct {
+	// MaxTodos limits how many Todos the tenant's users can have between them. Zero is unlimited.
+	MaxTodos int `json:"max_todos"`
+}
+
+// Tenants configures each organisation by its ID, the value of the JWT's tenant claim.
+// When it's nil, any tenant is accepted with the default configuration.
+type Tenants map[string]TenantConfig
+
+// LoadTenants reads tenant configuration from a JSON file, e.g. {"acme": {"max_todos": 1000}}
+func LoadTenants(path string) (Tenants, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tenants := Tenants{}
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, err
+	}
+	for id, config := range tenants {
+		if config.MaxTodos < 0 {
+			return nil, fmt.Errorf("tenant %q has a negative max_todos", id)
+		}
+	}
+	return tenants, nil
+}
+
+// ErrUnknownTenant is when a user's tenant isn't one of the configured Tenants
+var ErrUnknownTenant = &ForbiddenError{Detail: "Your organisation isn't hosted by this service"}
+
+// tenantFromClaims reads the tenant a JWT was issued for. Tokens without the claim belong to the default tenant, "".
+// A claim which is present but isn't a non-empty string is an error, rather than a way into the default tenant.
+func tenantFromClaims(claims jwt.MapClaims, claim string) (string, error) {
+	value, ok := claims[claim]
+	if !ok {
+		return "", nil
+	}
+	tenant, ok := value.(string)
+	if !ok || tenant == "" {
+		return "", fmt.Errorf("Invalid %s claim", claim)
+	}
+	return tenant, nil
+}
+
+// tenantFromContext returns the authenticated user's tenant
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(contextKeyTenant).(string)
+	return tenant
+}
+
+// NewTenantTodoService creates a Todo service which keeps each tenant's data in its own service, made by newService.
+// Every call goes to the service of the tenant in the context, so one tenant's users can't reach another's data,
+// whatever the IDs or usernames they use.
+func NewTenantTodoService(tenants Tenants, newService func(tenant string) TodoService) TodoService {
+	return &tenantService{
+		tenants:    tenants,
+		newService: newService,
+		services:   map[string]*tenantTodos{},
+	}
+}
+
+type tenantService struct {
+	sync.Mutex
+	tenants    Tenants
+	newService func(tenant string) TodoService
+	services   map[string]*tenantTodos
+}
+
+// tenantTodos is a tenant's service & configuration
+type tenantTodos struct {
+	TodoService
+	config TenantConfig
+	// adding serialises adds while the tenant has a MaxTodos, so its limit can't be overshot
+	adding sync.Mutex
+}
+
+// service gets the service for the context's tenant, making it on first use
+func (s *tenantService) service(ctx context.Context) (*tenantTodos, error) {
+	tenant := tenantFromContext(ctx)
+	config, ok := s.tenants[tenant]
+	if s.tenants != nil && !ok {
+		return nil, ErrUnknownTenant
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	t, ok := s.services[tenant]
+	if !ok {
+		t = &tenantTodos{TodoService: s.newService(tenant), config: config}
+		s.services[tenant] = t
+	}
+	return t, nil
+}
+
+// reserve checks the tenant has room for n more Todos, returning a func to call once they're added
+func (t *tenantTodos) reserve(ctx context.Context, n int) (func(), error) {
+	if t.config.MaxTodos == 0 {
+		return func() {}, nil
+	}
+	t.adding.Lock()
+	users, err := t.GetUsers(ctx)
+	if err != nil {
+		t.adding.Unlock()
+		return nil, err
+	}
+	count := 0
+	for _, user := range users {
+		count += user.Todos
+	}
+	if count+n > t.config.MaxTodos {
+		t.adding.Unlock()
+		return nil, &ForbiddenError{Detail: fmt.Sprintf("Your organisation can have at most %d todos", t.config.MaxTodos)}
+	}
+	return t.adding.Unlock, nil
+}
+
+func (s *tenantService) GetAllForUser(ctx context.Context, username string) ([]Todo, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.GetAllForUser(ctx, username)
+}
+
+func (s *tenantService) GetByID(ctx context.Context, username string, id string) (Todo, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+	return t.GetByID(ctx, username, id)
+}
+
+func (s *tenantService) Add(ctx context.Context, todo Todo) (Todo, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+	done, err := t.reserve(ctx, 1)
+	if err != nil {
+		return Todo{}, err
+	}
+	defer done()
+	return t.Add(ctx, todo)
+}
+
+func (s *tenantService) Update(ctx context.Context, username string, id string, todo Todo) error {
+	t, err := s.service(ctx)
+	if err != nil {
+		return err
+	}
+	return t.Update(ctx, username, id, todo)
+}
+
+func (s *tenantService) Delete(ctx context.Context, username string, id string) error {
+	t, err := s.service(ctx)
+	if err != nil {
+		return err
+	}
+	return t.Delete(ctx, username, id)
+}
+
+func (s *tenantService) AddMany(ctx context.Context, todos []Todo) ([]Todo, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done, err := t.reserve(ctx, len(todos))
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return t.AddMany(ctx, todos)
+}
+
+func (s *tenantService) UpdateMany(ctx context.Context, username string, filter Filter, patch TodoPatch) ([]BulkResult, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.UpdateMany(ctx, username, filter, patch)
+}
+
+func (s *tenantService) DeleteMany(ctx context.Context, username string, filter Filter) ([]BulkResult, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.DeleteMany(ctx, username, filter)
+}
+
+func (s *tenantService) Search(ctx context.Context, username string, query string) ([]SearchResult, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.Search(ctx, username, query)
+}
+
+func (s *tenantService) Query(ctx context.Context, username string, query Expr) ([]Todo, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.Query(ctx, username, query)
+}
+
+func (s *tenantService) GetViewsForUser(ctx context.Context, username string) ([]View, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.GetViewsForUser(ctx, username)
+}
+
+func (s *tenantService) GetView(ctx context.Context, username string, id string) (View, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return View{}, err
+	}
+	return t.GetView(ctx, username, id)
+}
+
+func (s *tenantService) AddView(ctx context.Context, view View) (View, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return View{}, err
+	}
+	return t.AddView(ctx, view)
+}
+
+func (s *tenantService) DeleteView(ctx context.Context, username string, id string) error {
+	t, err := s.service(ctx)
+	if err != nil {
+		return err
+	}
+	return t.DeleteView(ctx, username, id)
+}
+
+// GetUsers summarises the users of the administrator's tenant
+func (s *tenantService) GetUsers(ctx context.Context) ([]UserSummary, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.GetUsers(ctx)
+}
+
+func (s *tenantService) DeleteUser(ctx context.Context, username string) (UserSummary, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return UserSummary{}, err
+	}
+	return t.DeleteUser(ctx, username)
+}
+
+func (s *tenantService) Share(ctx context.Context, share Share) (Share, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return Share{}, err
+	}
+	return t.Share(ctx, share)
+}
+
+func (s *tenantService) GetShares(ctx context.Context, username string) ([]Share, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.GetShares(ctx, username)
+}
+
+func (s *tenantService) AcceptShare(ctx context.Context, username string, id string) (Share, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return Share{}, err
+	}
+	return t.AcceptShare(ctx, username, id)
+}
+
+func (s *tenantService) DeleteShare(ctx context.Context, username string, id string) error {
+	t, err := s.service(ctx)
+	if err != nil {
+		return err
+	}
+	return t.DeleteShare(ctx, username, id)
+}
+
+func (s *tenantService) GetSharedWithUser(ctx context.Context, username string) ([]SharedTodo, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.GetSharedWithUser(ctx, username)
+}
+
+func (s *tenantService) AddToView(ctx context.Context, username string, viewID string, todo Todo) (Todo, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+	done, err := t.reserve(ctx, 1)
+	if err != nil {
+		return Todo{}, err
+	}
+	defer done()
+	return t.AddToView(ctx, username, viewID, todo)
+}
+
+func (s *tenantService) Assign(ctx context.Context, username string, id string, assignee string) (Todo, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+	return t.Assign(ctx, username, id, assignee)
+}
+
+func (s *tenantService) GetAssignedToUser(ctx context.Context, username string) ([]Todo, error) {
+	t, err := s.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.GetAssignedToUser(ctx, username)
+}
@@ -0,0 +1,157 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+// tenantContext returns a context authenticated as a user of tenant
+func tenantContext(tenant string) context.Context {
+	ctx := context.WithValue(context.Background(), contextKeyTenant, tenant)
+	return context.WithValue(ctx, "username", "same@test.com")
+}
+
+func newTenantService(tenants Tenants) TodoService {
+	return NewTenantTodoService(tenants, func(tenant string) TodoService { return NewInmemTodoService() })
+}
+
+// TestTenantIsolation tests a tenant's users can't read another tenant's data, even with the same username & the data's IDs
+func TestTenantIsolation(t *testing.T) {
+	s := newTenantService(nil)
+	acme, globex := tenantContext("acme"), tenantContext("globex")
+
+	todo, err := s.Add(acme, Todo{Username: "same@test.com", Text: "Acme secret plans", Tags: []string{"plans"}})
+	require.NoError(t, err)
+	view, _ := s.AddView(acme, View{Username: "same@test.com", Name: "Plans", Query: "tag:plans"})
+	share, _ := s.Share(acme, Share{Owner: "same@test.com", Username: "friend@test.com", TodoID: todo.ID, Access: ShareEditor})
+
+	_, err = s.GetByID(globex, "same@test.com", todo.ID)
+	require.Equal(t, ErrNotFound, err)
+	todos, _ := s.GetAllForUser(globex, "same@test.com")
+	require.Empty(t, todos)
+	results, _ := s.Search(globex, "same@test.com", "secret")
+	require.Empty(t, results)
+	query, _ := ParseQuery("tag:plans")
+	todos, _ = s.Query(globex, "same@test.com", query)
+	require.Empty(t, todos)
+	_, err = s.GetView(globex, "same@test.com", view.ID)
+	require.Equal(t, ErrNotFound, err)
+	_, err = s.AcceptShare(tenantContext("globex"), "friend@test.com", share.ID)
+	require.Equal(t, ErrNotFound, err)
+	users, _ := s.GetUsers(globex)
+	require.Empty(t, users, "Expected administrators to only see their own tenant")
+
+	todo.Text = "Stolen"
+	require.Equal(t, ErrNotFound, s.Update(globex, "same@test.com", todo.ID, todo))
+	require.Equal(t, ErrNotFound, s.Delete(globex, "same@test.com", todo.ID))
+	results, _ = s.Search(acme, "same@test.com", "secret")
+	require.Len(t, results, 1, "Expected the Todo to be untouched")
+
+	// users without a tenant are a tenant of their own
+	todos, _ = s.GetAllForUser(context.Background(), "same@test.com")
+	require.Empty(t, todos)
+
+	// but a malformed tenant claim doesn't put a token in the default tenant
+	for _, claim := range []interface{}{42, "", []interface{}{"acme"}, nil} {
+		_, err := tenantFromClaims(jwt.MapClaims{"tenant": claim}, "tenant")
+		require.Error(t, err, "%v", claim)
+	}
+	tenant, err := tenantFromClaims(jwt.MapClaims{}, "tenant")
+	require.NoError(t, err)
+	require.Equal(t, "", tenant)
+}
+
+// TestTenantConfig tests only configured tenants are hosted, within their limits
+func TestTenantConfig(t *testing.T) {
+	s := newTenantService(Tenants{"acme": {MaxTodos: 2}, "globex": {}})
+
+	_, err := s.GetAllForUser(tenantContext("initech"), "same@test.com")
+	require.Equal(t, ErrUnknownTenant, err)
+	_, err = s.GetAllForUser(context.Background(), "same@test.com")
+	require.Equal(t, ErrUnknownTenant, err, "Expected users without a tenant to be refused")
+
+	acme := tenantContext("acme")
+	_, err = s.AddMany(acme, []Todo{{Username: "same@test.com", Text: "One"}, {Username: "other@test.com", Text: "Two"}})
+	require.NoError(t, err)
+	_, err = s.Add(acme, Todo{Username: "same@test.com", Text: "Three"})
+	require.IsType(t, &ForbiddenError{}, err, "Expected the tenant's limit to count every user's Todos")
+	_, err = s.Add(tenantContext("globex"), Todo{Username: "same@test.com", Text: "Three"})
+	require.NoError(t, err, "Expected other tenants to have their own limit")
+}
+
+// TestLoadTenants tests tenants are read from a JSON file
+func TestLoadTenants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenants")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tenants.json")
+
+	ioutil.WriteFile(path, []byte(`{"acme": {"max_todos": 100}, "globex": {}}`), 0600)
+	tenants, err := LoadTenants(path)
+	require.NoError(t, err)
+	require.Equal(t, Tenants{"acme": {MaxTodos: 100}, "globex": {}}, tenants)
+
+	ioutil.WriteFile(path, []byte(`{"acme": {"max_todos": -1}}`), 0600)
+	_, err = LoadTenants(path)
+	require.Error(t, err)
+}
+
+// TestTenantHTTP tests the tenant claim, API keys & calendar links all keep users in their own tenant
+func TestTenantHTTP(t *testing.T) {
+	server := newTestServer(t, newTenantService(nil))
+	defer server.Close()
+	acme := newToken(t, jwt.MapClaims{"username": "same@test.com", "tenant": "acme"})
+	globex := newToken(t, jwt.MapClaims{"username": "same@test.com", "tenant": "globex"})
+
+	res := newCall(t, http.MethodPost, server.URL+"/api/todos", "JWT", acme, map[string]string{"text": "Acme secret plans"})
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var added AddResponse
+	json.NewDecoder(res.Body).Decode(&added)
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/todos/"+added.Todo.ID, "JWT", globex, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = newCall(t, http.MethodPost, server.URL+"/api/keys", "JWT", globex, map[string]interface{}{"name": "Script", "scopes": []string{ScopeTodosRead}})
+	defer res.Body.Close()
+	var created CreateAPIKeyResponse
+	json.NewDecoder(res.Body).Decode(&created)
+	require.Equal(t, "globex", created.APIKey.Tenant)
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/todos", "ApiKey", created.Secret, nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var todos GetAllForUserResponse
+	json.NewDecoder(res.Body).Decode(&todos)
+	require.Empty(t, todos.Todos, "Expected the API key to act in the tenant it was created in")
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/keys", "JWT", acme, nil)
+	defer res.Body.Close()
+	var keys GetAPIKeysResponse
+	json.NewDecoder(res.Body).Decode(&keys)
+	require.Empty(t, keys.APIKeys, "Expected another tenant's keys to be hidden")
+
+	res = newCall(t, http.MethodGet, server.URL+"/api/calendar", "JWT", globex, nil)
+	defer res.Body.Close()
+	var links CalendarLinksResponse
+	json.NewDecoder(res.Body).Decode(&links)
+	require.NotEmpty(t, links.CollectionURL)
+	res, err := http.Get(server.URL + links.CollectionURL + added.Todo.ID + ".ics")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode, "Expected the calendar link to stay in its tenant")
+
+	numeric := newToken(t, jwt.MapClaims{"username": "same@test.com", "tenant": 42})
+	res = newCall(t, http.MethodGet, server.URL+"/api/todos", "JWT", numeric, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode, "Expected a malformed tenant claim to be refused")
+}
@@ -0,0 +1,236 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName names the instrumentation creating the service's spans
+const TracerName = "github.com/sinnott74/TodoService/internal/todo"
+
+// propagator reads the W3C trace context & baggage of the request chain a request is part of
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// NewTracerProvider creates a TracerProvider exporting spans over OTLP/HTTP to endpointURL, e.g. http://collector:4318.
+// A ratio of new traces are sampled, while requests continuing a trace follow their caller's decision.
+func NewTracerProvider(ctx context.Context, endpointURL string, serviceName string, ratio float64) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpointURL))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	), nil
+}
+
+// TraceContext is middleware which continues the trace in a request's traceparent header, if any.
+// Spans started while handling the request are children of the caller's.
+func TraceContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// TracingEndpoint is endpoint middleware wrapping each request to the named endpoint in a server span
+func TracingEndpoint(tracer trace.Tracer, name string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx, span := tracer.Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(attribute.String("request.id", requestIDFromContext(ctx))),
+			)
+			defer func() { endSpan(span, err) }()
+			return next(ctx, request)
+		}
+	}
+}
+
+// TraceEndpoints wraps every endpoint with TracingEndpoint, named after its field, e.g. GetByID
+func TraceEndpoints(e TodoEndpoints, tracer trace.Tracer) TodoEndpoints {
+	return wrapEndpoints(e, func(name string) endpoint.Middleware { return TracingEndpoint(tracer, name) })
+}
+
+// TracingMiddleware wraps each call to a TodoService in a span, e.g. TodoService.GetByID
+func TracingMiddleware(tracer trace.Tracer) ServiceMiddleware {
+	return func(next TodoService) TodoService {
+		return &tracingService{tracer: tracer, next: next}
+	}
+}
+
+// endSpan ends a span, recording its error.
+// Client errors are expected, so only server errors mark the span as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		if codeFrom(err) >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+type tracingService struct {
+	tracer trace.Tracer
+	next   TodoService
+}
+
+// start starts the span of a call to method, labelled with its tenant
+func (s *tracingService) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "TodoService."+method, trace.WithAttributes(attribute.String("tenant", tenantFromContext(ctx))))
+}
+
+func (s *tracingService) GetAllForUser(ctx context.Context, username string) (todos []Todo, err error) {
+	ctx, span := s.start(ctx, "GetAllForUser")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetAllForUser(ctx, username)
+}
+
+func (s *tracingService) GetByID(ctx context.Context, username string, id string) (todo Todo, err error) {
+	ctx, span := s.start(ctx, "GetByID")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetByID(ctx, username, id)
+}
+
+func (s *tracingService) Add(ctx context.Context, todo Todo) (added Todo, err error) {
+	ctx, span := s.start(ctx, "Add")
+	defer func() { endSpan(span, err) }()
+	return s.next.Add(ctx, todo)
+}
+
+func (s *tracingService) Update(ctx context.Context, username string, id string, todo Todo) (err error) {
+	ctx, span := s.start(ctx, "Update")
+	defer func() { endSpan(span, err) }()
+	return s.next.Update(ctx, username, id, todo)
+}
+
+func (s *tracingService) Delete(ctx context.Context, username string, id string) (err error) {
+	ctx, span := s.start(ctx, "Delete")
+	defer func() { endSpan(span, err) }()
+	return s.next.Delete(ctx, username, id)
+}
+
+func (s *tracingService) AddMany(ctx context.Context, todos []Todo) (added []Todo, err error) {
+	ctx, span := s.start(ctx, "AddMany")
+	defer func() { endSpan(span, err) }()
+	return s.next.AddMany(ctx, todos)
+}
+
+func (s *tracingService) UpdateMany(ctx context.Context, username string, filter Filter, patch TodoPatch) (results []BulkResult, err error) {
+	ctx, span := s.start(ctx, "UpdateMany")
+	defer func() { endSpan(span, err) }()
+	return s.next.UpdateMany(ctx, username, filter, patch)
+}
+
+func (s *tracingService) DeleteMany(ctx context.Context, username string, filter Filter) (results []BulkResult, err error) {
+	ctx, span := s.start(ctx, "DeleteMany")
+	defer func() { endSpan(span, err) }()
+	return s.next.DeleteMany(ctx, username, filter)
+}
+
+func (s *tracingService) Search(ctx context.Context, username string, query string) (results []SearchResult, err error) {
+	ctx, span := s.start(ctx, "Search")
+	defer func() { endSpan(span, err) }()
+	return s.next.Search(ctx, username, query)
+}
+
+func (s *tracingService) Query(ctx context.Context, username string, query Expr) (todos []Todo, err error) {
+	ctx, span := s.start(ctx, "Query")
+	defer func() { endSpan(span, err) }()
+	return s.next.Query(ctx, username, query)
+}
+
+func (s *tracingService) GetViewsForUser(ctx context.Context, username string) (views []View, err error) {
+	ctx, span := s.start(ctx, "GetViewsForUser")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetViewsForUser(ctx, username)
+}
+
+func (s *tracingService) GetView(ctx context.Context, username string, id string) (view View, err error) {
+	ctx, span := s.start(ctx, "GetView")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetView(ctx, username, id)
+}
+
+func (s *tracingService) AddView(ctx context.Context, view View) (added View, err error) {
+	ctx, span := s.start(ctx, "AddView")
+	defer func() { endSpan(span, err) }()
+	return s.next.AddView(ctx, view)
+}
+
+func (s *tracingService) DeleteView(ctx context.Context, username string, id string) (err error) {
+	ctx, span := s.start(ctx, "DeleteView")
+	defer func() { endSpan(span, err) }()
+	return s.next.DeleteView(ctx, username, id)
+}
+
+func (s *tracingService) GetUsers(ctx context.Context) (users []UserSummary, err error) {
+	ctx, span := s.start(ctx, "GetUsers")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetUsers(ctx)
+}
+
+func (s *tracingService) DeleteUser(ctx context.Context, username string) (deleted UserSummary, err error) {
+	ctx, span := s.start(ctx, "DeleteUser")
+	defer func() { endSpan(span, err) }()
+	return s.next.DeleteUser(ctx, username)
+}
+
+func (s *tracingService) Share(ctx context.Context, share Share) (shared Share, err error) {
+	ctx, span := s.start(ctx, "Share")
+	defer func() { endSpan(span, err) }()
+	return s.next.Share(ctx, share)
+}
+
+func (s *tracingService) GetShares(ctx context.Context, username string) (shares []Share, err error) {
+	ctx, span := s.start(ctx, "GetShares")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetShares(ctx, username)
+}
+
+func (s *tracingService) AcceptShare(ctx context.Context, username string, id string) (share Share, err error) {
+	ctx, span := s.start(ctx, "AcceptShare")
+	defer func() { endSpan(span, err) }()
+	return s.next.AcceptShare(ctx, username, id)
+}
+
+func (s *tracingService) DeleteShare(ctx context.Context, username string, id string) (err error) {
+	ctx, span := s.start(ctx, "DeleteShare")
+	defer func() { endSpan(span, err) }()
+	return s.next.DeleteShare(ctx, username, id)
+}
+
+func (s *tracingService) GetSharedWithUser(ctx context.Context, username string) (todos []SharedTodo, err error) {
+	ctx, span := s.start(ctx, "GetSharedWithUser")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetSharedWithUser(ctx, username)
+}
+
+func (s *tracingService) AddToView(ctx context.Context, username string, viewID string, todo Todo) (added Todo, err error) {
+	ctx, span := s.start(ctx, "AddToView")
+	defer func() { endSpan(span, err) }()
+	return s.next.AddToView(ctx, username, viewID, todo)
+}
+
+func (s *tracingService) Assign(ctx context.Context, username string, id string, assignee string) (todo Todo, err error) {
+	ctx, span := s.start(ctx, "Assign")
+	defer func() { endSpan(span, err) }()
+	return s.next.Assign(ctx, username, id, assignee)
+}
+
+func (s *tracingService) GetAssignedToUser(ctx context.Context, username string) (todos []Todo, err error) {
+	ctx, span := s.start(ctx, "GetAssignedToUser")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetAssignedToUser(ctx, username)
+}
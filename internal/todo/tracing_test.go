@@ -0,0 +1,80 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTracer creates a tracer whose spans are kept in memory, once they've ended
+func newTracer() (*tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	exporter := tracetest.NewInMemoryExporter()
+	return exporter, sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+}
+
+// TestTracing tests a request continues its caller's trace, with a span for the endpoint & the service call within it
+func TestTracing(t *testing.T) {
+	exporter, provider := newTracer()
+	tracer := provider.Tracer(TracerName)
+	service := TracingMiddleware(tracer)(NewInmemTodoService())
+	server := httptest.NewServer(newTestHandler(t, TraceEndpoints(MakeTodoEndpoints(service), tracer)))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/todos/missing", nil)
+	req.Header.Set("Authorization", newJWTToken(t))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(RequestIDHeader, "req-42")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	call, request := spans[0], spans[1]
+
+	require.Equal(t, "GetByID", request.Name)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext.TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", request.Parent.SpanID().String(), "Expected the caller's span to be the parent")
+	require.True(t, request.Parent.IsRemote())
+	require.Contains(t, request.Attributes, attribute.String("request.id", "req-42"))
+
+	require.Equal(t, "TodoService.GetByID", call.Name)
+	require.Equal(t, request.SpanContext.SpanID(), call.Parent.SpanID())
+	require.Len(t, call.Events, 1, "Expected the error to be recorded")
+	require.Equal(t, codes.Unset, call.Status.Code, "Expected a client error not to fail the span")
+}
+
+// TestTracingNewTrace tests requests without a trace context start a new trace
+func TestTracingNewTrace(t *testing.T) {
+	exporter, provider := newTracer()
+	tracer := provider.Tracer(TracerName)
+	endpoints := TraceEndpoints(MakeTodoEndpoints(TracingMiddleware(tracer)(NewInmemTodoService())), tracer)
+	server := httptest.NewServer(newTestHandler(t, endpoints))
+	defer server.Close()
+
+	newHTTPServerCall(t, http.MethodGet, server.URL+"/api/todos", nil).Body.Close()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	require.Equal(t, "GetAllForUser", spans[1].Name)
+	require.False(t, spans[1].Parent.IsValid())
+	require.Equal(t, spans[1].SpanContext.TraceID(), spans[0].SpanContext.TraceID())
+}
+
+// TestEndSpan tests server errors fail a span
+func TestEndSpan(t *testing.T) {
+	exporter, provider := newTracer()
+	_, span := provider.Tracer(TracerName).Start(context.Background(), "test")
+	endSpan(span, context.DeadlineExceeded)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.Equal(t, codes.Error, spans[0].Status.Code)
+}
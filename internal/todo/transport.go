@@ -1,94 +1,602 @@
 package todo
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-
-	jwt "github.com/dgrijalva/jwt-go"
-	"github.com/go-chi/render"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi"
 	chiMiddleware "github.com/go-chi/chi/middleware"
+	"github.com/go-kit/kit/log"
 	httptransport "github.com/go-kit/kit/transport/http"
 	middleware "github.com/sinnott74/go-http-middleware"
 )
 
 // ErrMissingParam is thrown when an http request is missing a URL Parameter
-var ErrMissingParam = errors.New("Missing parameter")
+var ErrMissingParam = &ValidationError{Detail: "Missing parameter"}
 
-// MakeHTTPHandler creates http transport layer for the Todo service
-func MakeHTTPHandler(endpoints TodoEndpoints) http.Handler {
+type contextKey int
 
-	options := []httptransport.ServerOption{
-		// httptransport.ServerErrorLogger(logger),
-		httptransport.ServerErrorEncoder(encodeError),
-	}
+const (
+	// contextKeyDepth holds a WebDAV request's Depth header
+	contextKeyDepth contextKey = iota
+	// contextKeyRequestCodec holds the codec decoding the request's body
+	contextKeyRequestCodec
+	// contextKeyResponseCodec holds the codec encoding the response
+	contextKeyResponseCodec
+	// contextKeyAPIKey holds the APIKey a request was authenticated with
+	contextKeyAPIKey
+	// contextKeyRoles holds the authenticated user's Roles
+	contextKeyRoles
+	// contextKeyTenant holds the authenticated user's tenant
+	contextKeyTenant
+	// contextKeyRequestID holds the ID of the request being handled
+	contextKeyRequestID
+)
 
-	jwtOptions := middleware.JWTOptions{
-		Secret: JWTSecret(),
-		AuthFunc: func(ctx context.Context, claims jwt.MapClaims) (context.Context, error) {
-			// verify claims
-			if username, ok := claims["username"]; ok {
-				userCtx := context.WithValue(ctx, "username", username)
-				return userCtx, nil
-			}
-			return ctx, errors.New("No username")
-		},
+func init() {
+	// CalDAV clients list a calendar collection with PROPFIND, which chi only routes once registered
+	chi.RegisterMethod("PROPFIND")
+}
+
+// MakeHTTPHandler creates http transport layer for the Todo service.
+// API requests are authenticated by JWTs accepted by jwtConfig, or by API keys from apiKeys.
+// Calendar requests are authenticated by the tokens in calendars.
+// Responses to requests with an Idempotency-Key are kept in idempotency.
+// Request bodies, including uploads, larger than maxBodyBytes are rejected with 413 Request Entity Too Large.
+// Requests & errors are logged to logger with their request ID.
+func MakeHTTPHandler(endpoints TodoEndpoints, jwtConfig JWTConfig, apiKeys APIKeyService, calendars CalendarTokenService, idempotency *IdempotencyStore, maxBodyBytes int64, logger log.Logger) http.Handler {
+
+	options := []httptransport.ServerOption{
+		// errors are logged by the error encoder, as unlike ServerErrorLogger it has the request's context
+		httptransport.ServerBefore(httptransport.PopulateRequestContext),
+		httptransport.ServerErrorEncoder(logErrors(logger, encodeError)),
+		httptransport.ServerErrorLogger(transportErrorLogger(logger)),
 	}
 
 	r := chi.NewRouter()
-	r.Use(chiMiddleware.Logger)
+	r.Use(RequestID)
+	r.Use(TraceContext)
+	r.Use(AccessLog(logger))
 	r.Use(chiMiddleware.StripSlashes)
-	r.Use(middleware.JWT(jwtOptions))
-	r.Use(middleware.DefaultEtag)
-	r.Use(chiMiddleware.DefaultCompress)
+	r.Use(LimitBody(maxBodyBytes))
+
+	api := chi.NewRouter()
+	api.Use(Authenticate(jwtConfig, apiKeys))
+	api.Use(middleware.DefaultEtag)
+	api.Use(chiMiddleware.DefaultCompress)
+
+	// routes taking a negotiated body replay repeated requests with an Idempotency-Key, as do uploads
+	upload := Idempotency(idempotency, maxBodyBytes)
+	negotiated := chi.Chain(Negotiate, upload)
 
 	todoRouter := chi.NewRouter()
+	// exports & imports are files with their own formats, everything else negotiates its encoding
+	todos := todoRouter.With(negotiated...)
 
-	todoRouter.Get("/", httptransport.NewServer(
+	todos.Get("/", httptransport.NewServer(
 		endpoints.GetAllForUserEndPoint,
 		decodeGetRequest,
 		encodeResponse,
 		options...,
 	).ServeHTTP)
 
-	todoRouter.Get("/{id}", httptransport.NewServer(
+	todoRouter.Get("/export", httptransport.NewServer(
+		endpoints.ExportEndpoint,
+		decodeExportRequest,
+		encodeExportResponse,
+		options...,
+	).ServeHTTP)
+
+	// uploads have room for the multipart encoding's other parts around the file
+	todoRouter.With(upload).Post("/import", httptransport.NewServer(
+		endpoints.ImportEndpoint,
+		decodeImportRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	todos.Get("/search", httptransport.NewServer(
+		endpoints.SearchEndpoint,
+		decodeSearchRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	todos.Get("/{id}", httptransport.NewServer(
 		endpoints.GetByIDEndpoint,
 		decodeGetByIDRequest,
 		encodeResponse,
 		options...,
 	).ServeHTTP)
 
-	todoRouter.Post("/", httptransport.NewServer(
+	todos.Post("/", httptransport.NewServer(
 		endpoints.AddEndpoint,
 		decodeAddRequest,
 		encodeResponse,
 		options...,
 	).ServeHTTP)
 
-	todoRouter.Put("/{id}", httptransport.NewServer(
+	todos.Put("/{id}", httptransport.NewServer(
 		endpoints.UpdateEndpoint,
 		decodeUpdateRequest,
 		encodeResponse,
 		options...,
 	).ServeHTTP)
 
-	todoRouter.Delete("/{id}", httptransport.NewServer(
+	todos.Delete("/{id}", httptransport.NewServer(
 		endpoints.DeleteEndpoint,
 		decodeDeleteRequest,
 		encodeResponse,
 		options...,
 	).ServeHTTP)
 
-	r.Mount("/api/todos", todoRouter)
+	todos.Post("/bulk", httptransport.NewServer(
+		endpoints.BulkAddEndpoint,
+		decodeBulkAddRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	todos.Patch("/bulk", httptransport.NewServer(
+		endpoints.BulkUpdateEndpoint,
+		decodeBulkUpdateRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	todos.Delete("/bulk", httptransport.NewServer(
+		endpoints.BulkDeleteEndpoint,
+		decodeBulkDeleteRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	todos.Post("/{id}/shares", httptransport.NewServer(
+		endpoints.ShareEndpoint,
+		decodeShareTodoRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	todos.Get("/assigned", httptransport.NewServer(
+		endpoints.AssignedToMeEndpoint,
+		decodeGetAssignedToMeRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	todos.Put("/{id}/assignee", httptransport.NewServer(
+		endpoints.AssignEndpoint,
+		decodeAssignRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	api.Mount("/todos", todoRouter)
+
+	viewRouter := chi.NewRouter()
+	viewRouter.Use(negotiated...)
+
+	viewRouter.Get("/", httptransport.NewServer(
+		endpoints.GetViewsEndpoint,
+		decodeGetViewsRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	viewRouter.Get("/{id}", httptransport.NewServer(
+		endpoints.GetViewEndpoint,
+		decodeGetViewRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	viewRouter.Post("/", httptransport.NewServer(
+		endpoints.AddViewEndpoint,
+		decodeAddViewRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	viewRouter.Delete("/{id}", httptransport.NewServer(
+		endpoints.DeleteViewEndpoint,
+		decodeDeleteViewRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	viewRouter.Post("/{id}/shares", httptransport.NewServer(
+		endpoints.ShareEndpoint,
+		decodeShareViewRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	viewRouter.Post("/{id}/todos", httptransport.NewServer(
+		endpoints.AddToViewEndpoint,
+		decodeAddToViewRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	api.Mount("/views", viewRouter)
+
+	shareRouter := chi.NewRouter()
+	shareRouter.Use(negotiated...)
+
+	shareRouter.Get("/", httptransport.NewServer(
+		endpoints.GetSharesEndpoint,
+		decodeGetSharesRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	shareRouter.Post("/{id}/accept", httptransport.NewServer(
+		endpoints.AcceptShareEndpoint,
+		decodeAcceptShareRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	shareRouter.Delete("/{id}", httptransport.NewServer(
+		endpoints.DeleteShareEndpoint,
+		decodeDeleteShareRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	api.Mount("/shares", shareRouter)
+
+	api.With(negotiated...).Get("/shared", httptransport.NewServer(
+		endpoints.SharedWithMeEndpoint,
+		decodeGetSharedWithMeRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	api.With(negotiated...).Post("/batch", httptransport.NewServer(
+		endpoints.BatchEndpoint,
+		decodeBatchRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	// a calendar token can change Todos, so API keys can't get or change one
+	api.With(negotiated...).Get("/calendar", httptransport.NewServer(
+		RequireSession()(makeCalendarLinksEndpoint(calendars)),
+		decodeCalendarLinksRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	api.With(negotiated...).Post("/calendar/token", httptransport.NewServer(
+		RequireSession()(makeRegenerateCalendarTokenEndpoint(calendars)),
+		decodeRegenerateCalendarTokenRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	api.With(negotiated...).Delete("/calendar/token", httptransport.NewServer(
+		RequireSession()(makeRevokeCalendarTokenEndpoint(calendars)),
+		decodeRevokeCalendarTokenRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	keyEndpoints := MakeAPIKeyEndpoints(apiKeys)
+	keyRouter := chi.NewRouter()
+	keyRouter.Use(negotiated...)
+
+	keyRouter.Get("/", httptransport.NewServer(
+		keyEndpoints.GetAPIKeysEndpoint,
+		decodeGetAPIKeysRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	keyRouter.Post("/", httptransport.NewServer(
+		keyEndpoints.CreateAPIKeyEndpoint,
+		decodeCreateAPIKeyRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	keyRouter.Delete("/{id}", httptransport.NewServer(
+		keyEndpoints.RevokeAPIKeyEndpoint,
+		decodeRevokeAPIKeyRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	api.Mount("/keys", keyRouter)
+
+	adminRouter := chi.NewRouter()
+	adminRouter.Use(negotiated...)
+
+	adminRouter.Get("/users", httptransport.NewServer(
+		endpoints.GetUsersEndpoint,
+		decodeGetUsersRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	adminRouter.Get("/users/{username}", httptransport.NewServer(
+		endpoints.GetUserEndpoint,
+		decodeGetUserRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	adminRouter.Delete("/users/{username}", httptransport.NewServer(
+		endpoints.DeleteUserEndpoint,
+		decodeDeleteUserRequest,
+		encodeResponse,
+		options...,
+	).ServeHTTP)
+
+	api.Mount("/admin", adminRouter)
+
+	r.Mount("/api", api)
+	r.Mount("/calendar/{token}", makeCalendarHandler(endpoints, calendars, options))
 
 	return r
 }
 
+// makeCalendarHandler creates the routes calendar apps use: a subscribable feed & a minimal CalDAV collection.
+// They're authenticated by the calendar token in their URL rather than a JWT.
+func makeCalendarHandler(endpoints TodoEndpoints, calendars CalendarTokenService, options []httptransport.ServerOption) http.Handler {
+	r := chi.NewRouter()
+	r.Use(calendarAuth(calendars))
+	r.Use(chiMiddleware.DefaultCompress)
+
+	r.With(middleware.DefaultEtag).Get("/todos.ics", httptransport.NewServer(
+		endpoints.GetAllForUserEndPoint,
+		decodeGetRequest,
+		encodeICalendarResponse,
+		options...,
+	).ServeHTTP)
+
+	r.Method("PROPFIND", "/todos", httptransport.NewServer(
+		endpoints.GetAllForUserEndPoint,
+		decodeGetRequest,
+		encodePropfindResponse,
+		append(options, httptransport.ServerBefore(populateDepth))...,
+	))
+
+	r.Get("/todos/{resource}", httptransport.NewServer(
+		endpoints.CalendarGetEndpoint,
+		decodeCalendarGetRequest,
+		encodeCalendarGetResponse,
+		options...,
+	).ServeHTTP)
+
+	r.Put("/todos/{resource}", httptransport.NewServer(
+		endpoints.CalendarPutEndpoint,
+		decodeCalendarPutRequest,
+		encodeCalendarPutResponse,
+		options...,
+	).ServeHTTP)
+
+	r.Delete("/todos/{resource}", httptransport.NewServer(
+		endpoints.CalendarDeleteEndpoint,
+		decodeCalendarDeleteRequest,
+		encodeCalendarDeleteResponse,
+		options...,
+	).ServeHTTP)
+
+	return r
+}
+
+// calendarAuth authenticates a request by its calendar token, putting the token's user in the context.
+// An invalid token is reported as not found, so tokens can't be probed.
+func calendarAuth(calendars CalendarTokenService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant, username, err := calendars.Authenticate(r.Context(), chi.URLParam(r, "token"))
+			if err != nil {
+				encodeError(httptransport.PopulateRequestContext(r.Context(), r), ErrNotFound, w)
+				return
+			}
+			ctx := context.WithValue(r.Context(), contextKeyTenant, tenant)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, "username", username)))
+		})
+	}
+}
+
+// decodeGetRequest reads an optional smart filter query, e.g. ?query=completed:false AND tag:work
 func decodeGetRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
-	return GetAllForUserRequest{}, err
+	query, err := decodeQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	return GetAllForUserRequest{query}, err
+}
+
+// decodeQuery parses the request's query parameter, returning nil if there isn't one
+func decodeQuery(r *http.Request) (Expr, error) {
+	q := r.URL.Query().Get("query")
+	if strings.TrimSpace(q) == "" {
+		return nil, nil
+	}
+	if utf8.RuneCountInString(q) > MaxQueryLength {
+		return nil, &ValidationError{
+			Detail: "Invalid query",
+			Fields: []FieldError{{"query", fmt.Sprintf("must be at most %d characters", MaxQueryLength)}},
+		}
+	}
+	return ParseQuery(q)
+}
+
+func decodeSearchRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return SearchRequest{r.URL.Query().Get("q")}, err
+}
+
+// decodeExportRequest reads the format & an optional smart filter query, e.g. ?format=csv&query=completed:false
+func decodeExportRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	query, err := decodeQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	return ExportRequest{Format: r.URL.Query().Get("format"), Query: query}, err
+}
+
+// uploadError reports a failure reading a multipart upload as malformed, unless the upload was cut off by LimitBody
+func uploadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return &ValidationError{Detail: "Malformed multipart upload: " + err.Error()}
+}
+
+// decodeImportRequest reads a multipart upload with the file in its file field.
+// The format & dry_run options can be given as form fields or query parameters.
+// Without a format, it's worked out from the file's extension.
+func decodeImportRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, &ValidationError{Detail: "Request must be a multipart/form-data upload"}
+	}
+
+	options := r.URL.Query()
+	var filename string
+	var data []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, uploadError(err)
+		}
+
+		value, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, uploadError(err)
+		}
+
+		switch part.FormName() {
+		case "file":
+			filename, data = part.FileName(), value
+		case "format", "dry_run":
+			options.Set(part.FormName(), string(value))
+		}
+	}
+
+	if data == nil {
+		return nil, &ValidationError{Detail: "Request failed validation", Fields: []FieldError{{"file", "is required"}}}
+	}
+
+	formatName := options.Get("format")
+	if formatName == "" {
+		formatName = formatFromFilename(filename)
+	}
+	format, ok := fileFormats[formatName]
+	if !ok {
+		return nil, &ValidationError{
+			Detail: "Request failed validation",
+			Fields: []FieldError{{"format", "must be one of " + formatNames()}},
+		}
+	}
+
+	var dryRun bool
+	if v := options.Get("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			return nil, &ValidationError{
+				Detail: "Request failed validation",
+				Fields: []FieldError{{"dry_run", "must be true or false"}},
+			}
+		}
+	}
+
+	items, err := format.decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &ValidationError{Detail: "Unable to read file: " + err.Error()}
+	}
+	return ImportRequest{Items: items, DryRun: dryRun}, nil
+}
+
+// formatFromFilename works out a file's format from its extension
+func formatFromFilename(filename string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv":
+		return "csv"
+	case ".json", ".ndjson", ".jsonl":
+		return "json"
+	case ".md", ".markdown":
+		return "markdown"
+	case ".txt":
+		return "todotxt"
+	}
+	return ""
+}
+
+func decodeCalendarLinksRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return CalendarLinksRequest{}, err
+}
+
+func decodeRegenerateCalendarTokenRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return RegenerateCalendarTokenRequest{}, nil
+}
+
+func decodeRevokeCalendarTokenRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return RevokeCalendarTokenRequest{}, nil
+}
+
+// calendarResourceID is the Todo ID named by a calendar resource, e.g. /todos/{id}.ics
+func calendarResourceID(r *http.Request) (string, error) {
+	id := strings.TrimSuffix(chi.URLParam(r, "resource"), ".ics")
+	if id == "" {
+		return "", ErrMissingParam
+	}
+	return id, nil
+}
+
+func decodeCalendarGetRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id, err := calendarResourceID(r)
+	if err != nil {
+		return nil, err
+	}
+	return CalendarGetRequest{id}, err
+}
+
+// decodeCalendarPutRequest reads a Todo from the VTODO in an iCalendar body
+func decodeCalendarPutRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id, err := calendarResourceID(r)
+	if err != nil {
+		return nil, err
+	}
+	// the body is limited by LimitBody
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	todo, err := decodeVTODO(bytes.NewReader(data))
+	if err != nil {
+		return nil, &ValidationError{Detail: "Malformed iCalendar body: " + err.Error()}
+	}
+	return CalendarPutRequest{ID: id, Todo: todo}, nil
+}
+
+func decodeCalendarDeleteRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id, err := calendarResourceID(r)
+	if err != nil {
+		return nil, err
+	}
+	return CalendarDeleteRequest{id}, err
 }
 
 func decodeGetByIDRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
@@ -101,11 +609,11 @@ func decodeGetByIDRequest(ctx context.Context, r *http.Request) (request interfa
 
 func decodeAddRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
 	var todo Todo
-	err = render.Decode(r, &todo)
+	unknown, err := decodeBody(r, &todo)
 	if err != nil {
 		return nil, err
 	}
-	return AddRequest{todo}, err
+	return AddRequest{Todo: todo, unknownFields: unknown}, err
 }
 
 func decodeUpdateRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
@@ -114,11 +622,11 @@ func decodeUpdateRequest(ctx context.Context, r *http.Request) (request interfac
 		return nil, ErrMissingParam
 	}
 	var todo Todo
-	err = render.Decode(r, &todo)
+	unknown, err := decodeBody(r, &todo)
 	if err != nil {
 		return nil, err
 	}
-	return UpdateRequest{id, todo}, err
+	return UpdateRequest{ID: id, Todo: todo, unknownFields: unknown}, err
 }
 
 func decodeDeleteRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
@@ -129,33 +637,431 @@ func decodeDeleteRequest(ctx context.Context, r *http.Request) (request interfac
 	return DeleteRequest{id}, err
 }
 
+// bulkAddBody is the body of a bulk add, whose Todos are checked for unknown fields one by one
+type bulkAddBody struct {
+	Todos []json.RawMessage `json:"todos"`
+}
+
+func decodeBulkAddRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	var body bulkAddBody
+	unknown, err := decodeBody(r, &body)
+	if err != nil {
+		return nil, err
+	}
+	todos := make([]Todo, len(body.Todos))
+	for i, raw := range body.Todos {
+		if err := json.Unmarshal(raw, &todos[i]); err != nil {
+			return nil, &ValidationError{Detail: fmt.Sprintf("Malformed todo at index %d: %s", i, err)}
+		}
+		for _, field := range unknownJSONFields(raw, &todos[i]) {
+			unknown = append(unknown, fmt.Sprintf("todos[%d].%s", i, field))
+		}
+	}
+	return BulkAddRequest{Todos: todos, unknownFields: unknown}, nil
+}
+
+// bulkUpdateBody is the body of a bulk update
+type bulkUpdateBody struct {
+	Filter Filter    `json:"filter"`
+	Set    TodoPatch `json:"set"`
+}
+
+func decodeBulkUpdateRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	var body bulkUpdateBody
+	unknown, err := decodeBody(r, &body)
+	if err != nil {
+		return nil, err
+	}
+	return BulkUpdateRequest{Filter: body.Filter, Set: body.Set, unknownFields: unknown}, nil
+}
+
+// decodeBulkDeleteRequest reads the filter from the query string, e.g. ?completed=true or ?id=a&id=b
+func decodeBulkDeleteRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	filter, err := decodeFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	return BulkDeleteRequest{filter}, nil
+}
+
+// batchBody is the body of a batch, whose operations are decoded one by one
+type batchBody struct {
+	Operations []json.RawMessage `json:"operations"`
+}
+
+func decodeBatchRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	var body batchBody
+	unknown, err := decodeBody(r, &body)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		return nil, &ValidationError{Detail: "Request failed validation", Fields: unknownFieldErrors(unknown)}
+	}
+	operations := make([]BatchOperation, len(body.Operations))
+	for i, raw := range body.Operations {
+		var op struct {
+			BatchOperation
+			Todo json.RawMessage `json:"todo"`
+		}
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return nil, &ValidationError{Detail: fmt.Sprintf("Malformed operation at index %d: %s", i, err)}
+		}
+		operations[i] = op.BatchOperation
+		if len(op.Todo) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(op.Todo, &operations[i].Todo); err != nil {
+			return nil, &ValidationError{Detail: fmt.Sprintf("Malformed todo at index %d: %s", i, err)}
+		}
+		operations[i].unknownFields = unknownJSONFields(op.Todo, &operations[i].Todo)
+	}
+	return BatchRequest{operations}, nil
+}
+
+// decodeFilter reads a Filter from the request's query string
+func decodeFilter(r *http.Request) (Filter, error) {
+	query := r.URL.Query()
+	filter := Filter{IDs: query["id"]}
+	if completed := query.Get("completed"); completed != "" {
+		b, err := strconv.ParseBool(completed)
+		if err != nil {
+			return Filter{}, &ValidationError{
+				Detail: "Invalid query parameter",
+				Fields: []FieldError{{"completed", "must be true or false"}},
+			}
+		}
+		filter.Completed = &b
+	}
+	return filter, nil
+}
+
+func decodeGetViewsRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return GetViewsRequest{}, err
+}
+
+func decodeGetViewRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return nil, ErrMissingParam
+	}
+	return GetViewRequest{id}, err
+}
+
+func decodeAddViewRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	var view View
+	unknown, err := decodeBody(r, &view)
+	if err != nil {
+		return nil, err
+	}
+	return AddViewRequest{View: view, unknownFields: unknown}, err
+}
+
+func decodeDeleteViewRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return nil, ErrMissingParam
+	}
+	return DeleteViewRequest{id}, err
+}
+
+func decodeShareTodoRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return nil, ErrMissingParam
+	}
+	var req ShareRequest
+	unknown, err := decodeBody(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.TodoID = id
+	req.unknownFields = unknown
+	return req, nil
+}
+
+func decodeShareViewRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return nil, ErrMissingParam
+	}
+	var req ShareRequest
+	unknown, err := decodeBody(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.ViewID = id
+	req.unknownFields = unknown
+	return req, nil
+}
+
+func decodeGetSharesRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return GetSharesRequest{}, nil
+}
+
+func decodeAcceptShareRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return nil, ErrMissingParam
+	}
+	return AcceptShareRequest{id}, nil
+}
+
+func decodeDeleteShareRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return nil, ErrMissingParam
+	}
+	return DeleteShareRequest{id}, nil
+}
+
+func decodeGetSharedWithMeRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return GetSharedWithMeRequest{}, nil
+}
+
+func decodeGetAssignedToMeRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return GetAssignedToMeRequest{}, nil
+}
+
+func decodeAssignRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return nil, ErrMissingParam
+	}
+	var req AssignRequest
+	unknown, err := decodeBody(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.ID = id
+	req.unknownFields = unknown
+	return req, nil
+}
+
+func decodeAddToViewRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return nil, ErrMissingParam
+	}
+	var todo Todo
+	unknown, err := decodeBody(r, &todo)
+	if err != nil {
+		return nil, err
+	}
+	return AddToViewRequest{ViewID: id, Todo: todo, unknownFields: unknown}, nil
+}
+
+func decodeGetAPIKeysRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return GetAPIKeysRequest{}, nil
+}
+
+func decodeCreateAPIKeyRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	var req CreateAPIKeyRequest
+	unknown, err := decodeBody(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.unknownFields = unknown
+	return req, nil
+}
+
+func decodeRevokeAPIKeyRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return nil, ErrMissingParam
+	}
+	return RevokeAPIKeyRequest{id}, nil
+}
+
+func decodeGetUsersRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	return GetUsersRequest{}, nil
+}
+
+func decodeGetUserRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		return nil, ErrMissingParam
+	}
+	return GetUserRequest{username}, nil
+}
+
+func decodeDeleteUserRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		return nil, ErrMissingParam
+	}
+	return DeleteUserRequest{username}, nil
+}
+
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	if err, ok := response.(error); ok && err != nil {
 		encodeError(ctx, err, w)
 		return nil
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	return json.NewEncoder(w).Encode(response)
+	var doc bytes.Buffer
+	if err := json.NewEncoder(&doc).Encode(response); err != nil {
+		return err
+	}
+	c := responseCodec(ctx)
+	data, err := c.marshal(response, doc.Bytes())
+	if err != nil {
+		return err
+	}
+	if c.contentType == JSONContentType {
+		w.Header().Set("Content-Type", JSONContentType+"; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", c.contentType)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// encodeExportResponse streams the Todos as a file download in the requested format
+func encodeExportResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	export := response.(ExportResponse)
+	format := fileFormats[export.Format]
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="todos.%s"`, format.extension))
+	return format.encode(w, export.Todos)
+}
+
+// encodeICalendarResponse writes the Todos as an iCalendar feed, oldest first
+func encodeICalendarResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	todos := response.(GetAllForUserResponse).Todos
+	sortByCreatedOn(todos)
+	w.Header().Set("Content-Type", ICalendarContentType)
+	return encodeICalendar(w, todos)
+}
+
+func encodeCalendarGetResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	todo := response.(CalendarGetResponse).Todo
+	w.Header().Set("Content-Type", ICalendarContentType)
+	w.Header().Set("ETag", vtodoETag(todo))
+	return encodeICalendar(w, []Todo{todo})
+}
+
+// encodeCalendarPutResponse reports where a new Todo was created, as its ID can differ from the resource PUT to
+func encodeCalendarPutResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	put := response.(CalendarPutResponse)
+	w.Header().Set("ETag", vtodoETag(put.Todo))
+	if !put.Created {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if requestPath, ok := ctx.Value(httptransport.ContextKeyRequestPath).(string); ok {
+		w.Header().Set("Location", path.Join(path.Dir(requestPath), put.Todo.ID+".ics"))
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func encodeCalendarDeleteResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func populateDepth(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, contextKeyDepth, r.Header.Get("Depth"))
+}
+
+// encodePropfindResponse describes the calendar collection, & unless the Depth is 0 its resources, as a WebDAV multistatus.
+// Every property is returned whatever was asked for, which is enough for calendar apps to sync.
+func encodePropfindResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	collection, _ := ctx.Value(httptransport.ContextKeyRequestPath).(string)
+	collection = strings.TrimSuffix(collection, "/") + "/"
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`)
+	writePropfindResponse(&b, collection, `<d:resourcetype><d:collection/><c:calendar/></d:resourcetype>`+
+		`<d:displayname>Todos</d:displayname>`+
+		`<c:supported-calendar-component-set><c:comp name="VTODO"/></c:supported-calendar-component-set>`)
+	if depth, _ := ctx.Value(contextKeyDepth).(string); depth != "0" {
+		for _, todo := range response.(GetAllForUserResponse).Todos {
+			var etag bytes.Buffer
+			xml.EscapeText(&etag, []byte(vtodoETag(todo)))
+			writePropfindResponse(&b, collection+todo.ID+".ics", `<d:resourcetype/>`+
+				`<d:getetag>`+etag.String()+`</d:getetag>`+
+				`<d:getcontenttype>text/calendar; charset=utf-8; component=VTODO</d:getcontenttype>`)
+		}
+	}
+	b.WriteString(`</d:multistatus>`)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("DAV", "1, calendar-access")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, err := b.WriteTo(w)
+	return err
 }
 
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+func writePropfindResponse(b *bytes.Buffer, href, props string) {
+	b.WriteString(`<d:response><d:href>`)
+	xml.EscapeText(b, []byte(href))
+	b.WriteString(`</d:href><d:propstat><d:prop>` + props + `</d:prop>`)
+	b.WriteString(`<d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+}
+
+// vtodoETag is a strong ETag of a Todo's iCalendar representation
+func vtodoETag(todo Todo) string {
+	h := sha256.New()
+	encodeICalendar(h, []Todo{todo})
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
 	if err == nil {
 		panic("encodeError with nil error")
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(codeFrom(err))
-	json.NewEncoder(w).Encode(map[string]string{
-		"error": err.Error(),
-	})
+	var headerer httptransport.Headerer
+	if errors.As(err, &headerer) {
+		for k, values := range headerer.Headers() {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+	}
+	problem := newProblem(err, codeFrom(err))
+	if path, ok := ctx.Value(httptransport.ContextKeyRequestPath).(string); ok {
+		problem.Instance = path
+	}
+	w.Header().Set("Content-Type", ProblemContentType+"; charset=utf-8")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
 }
 
+// codeFrom maps an error to a HTTP status code.
+// Domain errors know their own status, as does a body cut off by LimitBody, anything else is an internal error.
 func codeFrom(err error) int {
-	switch err {
-	case ErrNotFound:
-		return http.StatusNotFound
-	case ErrInconsistentIDs, ErrMissingParam:
-		return http.StatusBadRequest
-	default:
-		return http.StatusInternalServerError
+	var sc httptransport.StatusCoder
+	if errors.As(err, &sc) {
+		return sc.StatusCode()
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// decodeBody decodes the request body, in its negotiated encoding, into v, returning any fields in the body v doesn't have.
+// A body which can't be decoded is the client's fault, so it's reported as a ValidationError.
+func decodeBody(r *http.Request, v interface{}) ([]string, error) {
+	// the body is limited by LimitBody
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := requestCodec(r.Context()).unmarshal(v, data)
+	var unsupported *UnsupportedMediaTypeError
+	if errors.As(err, &unsupported) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &ValidationError{Detail: "Malformed request body: " + err.Error()}
+	}
+	if err := json.Unmarshal(doc, v); err != nil {
+		return nil, &ValidationError{Detail: "Malformed request body: " + err.Error()}
 	}
+	return unknownJSONFields(doc, v), nil
 }
@@ -2,12 +2,16 @@ package todo
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,8 +20,7 @@ import (
 func TestCreatingATodoThenUpdatingTheDeleting(t *testing.T) {
 
 	todoService := NewInmemTodoService()
-	endpoints := MakeTodoEndpoints(todoService)
-	server := httptest.NewServer(MakeHTTPHandler(endpoints))
+	server := newTestServer(t, todoService)
 	defer server.Close()
 
 	// Create Todo
@@ -67,38 +70,152 @@ func TestCreatingATodoThenUpdatingTheDeleting(t *testing.T) {
 	defer res.Body.Close()
 	require.Equalf(t, http.StatusNotFound, res.StatusCode, "Expecting 404 when reading deleted Todo by ID")
 
-	var errorMap map[string]string
-	json.NewDecoder(res.Body).Decode(&errorMap)
-	require.EqualValuesf(t, "Not found", errorMap["error"], "Expected Not found error reading deleted Todo")
+	var problem Problem
+	json.NewDecoder(res.Body).Decode(&problem)
+	require.Equalf(t, "application/problem+json; charset=utf-8", res.Header.Get("Content-Type"), "Expected a problem+json response")
+	require.EqualValuesf(t, "Not found", problem.Detail, "Expected Not found error reading deleted Todo")
+	require.Equalf(t, http.StatusNotFound, problem.Status, "Expected problem status to match response status")
+	require.Equalf(t, "/api/todos/"+todo.ID, problem.Instance, "Expected problem instance to be the request path")
+}
+
+// TestMalformedBodyIsBadRequest tests that a body which isn't valid JSON is reported as a validation problem
+func TestMalformedBodyIsBadRequest(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/todos", strings.NewReader("{not json"))
+	require.NoError(t, err, "Error creating request")
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", newJWTToken(t))
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "Error doing request")
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusBadRequest, res.StatusCode, "Expecting 400 for malformed JSON")
+
+	var problem Problem
+	json.NewDecoder(res.Body).Decode(&problem)
+	require.Equal(t, http.StatusBadRequest, problem.Status)
+	require.Equal(t, "Bad Request", problem.Title)
+}
+
+// newToken signs a JWT with claims, which expires in an hour unless claims has an exp
+func newToken(t *testing.T, claims jwt.MapClaims) string {
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(DefaultJWTSecret))
+	require.NoError(t, err, "Error creating JWT token")
+	return token
 }
 
 // newJWTToken creates A JWT token to be used in a request
 func newJWTToken(t *testing.T) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": "test@test.com",
-	})
-	tokenString, err := token.SignedString(JWTSecret())
-	require.NoError(t, err, "Error creating JWT token")
-	return "JWT " + tokenString
+	return "JWT " + newToken(t, jwt.MapClaims{"username": "test@test.com"})
 }
 
-// NewHTTPServerCall performs a http call
-// It sets the request with all required headers. i.e. JWT token
-func newHTTPServerCall(t *testing.T, httpMethod, url string, payload interface{}) *http.Response {
-	var req *http.Request
-	var err error
+// newJWTConfig returns the configuration accepting newJWTToken's tokens
+func newJWTConfig(t *testing.T) JWTConfig {
+	config, err := NewJWTConfig(context.Background(), DefaultConfig(), log.NewNopLogger())
+	require.NoError(t, err, "Error reading JWT configuration")
+	return config
+}
+
+// testMaxBodyBytes is the largest request body test handlers accept
+const testMaxBodyBytes = 64 << 10
+
+// newTestHandler creates the http transport layer for endpoints, accepting newToken's tokens
+func newTestHandler(t *testing.T, endpoints TodoEndpoints) http.Handler {
+	return MakeHTTPHandler(endpoints, newJWTConfig(t), NewInmemAPIKeyService(), NewInmemCalendarTokenService(), NewIdempotencyStore(time.Hour), testMaxBodyBytes, log.NewNopLogger())
+}
 
-	if httpMethod == http.MethodGet || httpMethod == http.MethodDelete {
-		req, err = http.NewRequest(httpMethod, url, nil)
-	} else {
-		b := &bytes.Buffer{}
+// newTestServer serves the http transport layer for service
+func newTestServer(t *testing.T, service TodoService) *httptest.Server {
+	return httptest.NewServer(newTestHandler(t, MakeTodoEndpoints(service)))
+}
+
+// newCall performs a http call authenticated by scheme, i.e. JWT or ApiKey, & its credentials.
+// payload, unless it's nil, is sent as JSON.
+func newCall(t *testing.T, method, url, scheme, credentials string, payload interface{}) *http.Response {
+	b := &bytes.Buffer{}
+	if payload != nil {
 		json.NewEncoder(b).Encode(payload)
-		req, err = http.NewRequest(httpMethod, url, b)
 	}
+	req, err := http.NewRequest(method, url, b)
+	require.NoErrorf(t, err, "Error creating %s request", method)
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	req.Header.Set("Authorization", newJWTToken(t))
-	require.NoErrorf(t, err, "Error creating %s request", httpMethod)
+	req.Header.Set("Authorization", scheme+" "+credentials)
 	res, err := http.DefaultClient.Do(req)
-	require.NoErrorf(t, err, "Error doing %s request to %s with payload %v", httpMethod, url, payload)
+	require.NoErrorf(t, err, "Error doing %s request to %s with payload %v", method, url, payload)
 	return res
 }
+
+// NewHTTPServerCall performs a http call as test@test.com
+func newHTTPServerCall(t *testing.T, httpMethod, url string, payload interface{}) *http.Response {
+	return newCall(t, httpMethod, url, "JWT", newToken(t, jwt.MapClaims{"username": "test@test.com"}), payload)
+}
+
+// TestBulkCompleteThenClear tests creating Todos in bulk, completing them, then clearing completed Todos
+func TestBulkCompleteThenClear(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+
+	// Create Todos
+	payload := map[string][]Todo{"todos": {{Text: "First"}, {Text: "Second"}, {Text: "Third"}}}
+	res := newHTTPServerCall(t, http.MethodPost, server.URL+"/api/todos/bulk", payload)
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusOK, res.StatusCode, "Expecting StatusOK when bulk creating Todos")
+
+	var bulkResponse BulkResponse
+	json.NewDecoder(res.Body).Decode(&bulkResponse)
+	require.Equalf(t, 3, len(bulkResponse.Results), "Expecting a result per created Todo")
+
+	// Complete the first two
+	ids := []string{bulkResponse.Results[0].ID, bulkResponse.Results[1].ID}
+	update := map[string]interface{}{
+		"filter": map[string]interface{}{"ids": ids},
+		"set":    map[string]interface{}{"completed": true},
+	}
+	res = newHTTPServerCall(t, http.MethodPatch, server.URL+"/api/todos/bulk", update)
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusOK, res.StatusCode, "Expecting StatusOK when bulk updating Todos")
+
+	// Clear completed
+	res = newHTTPServerCall(t, http.MethodDelete, server.URL+"/api/todos/bulk?completed=true", nil)
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusOK, res.StatusCode, "Expecting StatusOK when bulk deleting Todos")
+
+	json.NewDecoder(res.Body).Decode(&bulkResponse)
+	require.ElementsMatchf(t, ids, []string{bulkResponse.Results[0].ID, bulkResponse.Results[1].ID}, "Expecting the completed Todos to be deleted")
+
+	todos, err := todoService.GetAllForUser(context.Background(), "test@test.com")
+	require.NoError(t, err, "Error reading back Todos")
+	require.Equal(t, 1, len(todos), "Should be 1 Todo left")
+}
+
+// TestBulkDeleteRequiresFilter tests a bulk delete can't accidentally remove everything
+func TestBulkDeleteRequiresFilter(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	res := newHTTPServerCall(t, http.MethodDelete, server.URL+"/api/todos/bulk", nil)
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusBadRequest, res.StatusCode, "Expecting 400 for a bulk delete without a filter")
+}
+
+// TestBulkUpdateRequiresFilter tests a bulk update can't accidentally change everything
+func TestBulkUpdateRequiresFilter(t *testing.T) {
+	todoService := NewInmemTodoService()
+	server := newTestServer(t, todoService)
+	defer server.Close()
+	todoService.Add(context.Background(), Todo{Username: "test@test.com", Text: "First"})
+
+	update := map[string]interface{}{"set": map[string]interface{}{"completed": true}}
+	res := newHTTPServerCall(t, http.MethodPatch, server.URL+"/api/todos/bulk", update)
+	defer res.Body.Close()
+	require.Equalf(t, http.StatusBadRequest, res.StatusCode, "Expecting 400 for a bulk update without a filter")
+
+	todos, _ := todoService.GetAllForUser(context.Background(), "test@test.com")
+	require.False(t, todos[0].Completed, "Expected the Todo to be untouched")
+}
@@ -0,0 +1,437 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+const (
+	// MaxTextLength is the maximum number of characters allowed in a Todo's text
+	MaxTextLength = 1000
+	// MaxBulkItems is the maximum number of Todos a bulk operation can name
+	MaxBulkItems = 100
+	// MaxTags is the maximum number of tags on a Todo
+	MaxTags = 20
+	// MaxTagLength is the maximum number of characters in a tag
+	MaxTagLength = 50
+	// MaxViewNameLength is the maximum number of characters in a View's name
+	MaxViewNameLength = 100
+	// MaxQueryLength is the maximum number of characters in a View's query
+	MaxQueryLength = 1000
+	// MaxSearchQueryLength is the maximum number of characters allowed in a search query
+	MaxSearchQueryLength = 256
+	// MaxImportItems is the maximum number of Todos in an imported file
+	MaxImportItems = 1000
+	// MaxAPIKeyNameLength is the maximum number of characters in an APIKey's name
+	MaxAPIKeyNameLength = 100
+	// MaxUsernameLength is the maximum number of characters in an Account's username
+	MaxUsernameLength = 254
+	// MinPasswordLength is the minimum number of characters in an Account's password
+	MinPasswordLength = 8
+	// MaxPasswordBytes is the longest password bcrypt can hash
+	MaxPasswordBytes = 72
+)
+
+// validator is implemented by requests which can check their own contents.
+// It returns every violation found rather than stopping at the first.
+type validator interface {
+	validate(ctx context.Context) []FieldError
+}
+
+// ValidatingMiddleware rejects requests which fail validation before they reach the service.
+// Requests which don't implement validator are passed straight through.
+func ValidatingMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if v, ok := request.(validator); ok {
+				if errs := v.validate(ctx); len(errs) > 0 {
+					return nil, &ValidationError{Detail: "Request failed validation", Fields: errs}
+				}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+func (r AddRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	if r.Todo.ID != "" {
+		errs = append(errs, FieldError{"id", "is assigned by the server"})
+	}
+	if !r.Todo.CreatedOn.IsZero() {
+		errs = append(errs, FieldError{"created_on", "is assigned by the server"})
+	}
+	if r.Todo.CreatedBy != "" {
+		errs = append(errs, FieldError{"created_by", "is assigned by the server"})
+	}
+	errs = append(errs, validateUsername(ctx, r.Todo.Username)...)
+	return append(errs, validateTodo(r.Todo)...)
+}
+
+func (r UpdateRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	if r.Todo.ID != r.ID {
+		errs = append(errs, FieldError{"id", "must match the ID in the URL"})
+	}
+	// the Todo may be shared by another user, so the service checks its username against the owner
+	return append(errs, validateTodo(r.Todo)...)
+}
+
+func (r BulkAddRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	switch {
+	case len(r.Todos) == 0:
+		errs = append(errs, FieldError{"todos", "must contain at least one todo"})
+	case len(r.Todos) > MaxBulkItems:
+		errs = append(errs, FieldError{"todos", fmt.Sprintf("must contain at most %d todos", MaxBulkItems)})
+	default:
+		for i, todo := range r.Todos {
+			for _, err := range (AddRequest{Todo: todo}).validate(ctx) {
+				errs = append(errs, FieldError{fmt.Sprintf("todos[%d].%s", i, err.Field), err.Message})
+			}
+		}
+	}
+	return errs
+}
+
+func (r BulkUpdateRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	errs = append(errs, validateFilter(r.Filter)...)
+	if r.Filter.Empty() {
+		errs = append(errs, FieldError{"filter", "must have at least one criterion"})
+	}
+	if r.Set.Empty() {
+		errs = append(errs, FieldError{"set", "must change at least one field"})
+	}
+	if r.Set.Text != nil {
+		for _, err := range validateTodo(Todo{Text: *r.Set.Text}) {
+			errs = append(errs, FieldError{"set." + err.Field, err.Message})
+		}
+	}
+	return errs
+}
+
+func (r BulkDeleteRequest) validate(ctx context.Context) []FieldError {
+	errs := validateFilter(r.Filter)
+	if r.Filter.Empty() {
+		errs = append(errs, FieldError{"filter", "must have at least one criterion"})
+	}
+	return errs
+}
+
+func (r BatchRequest) validate(ctx context.Context) []FieldError {
+	switch {
+	case len(r.Operations) == 0:
+		return []FieldError{{"operations", "must contain at least one operation"}}
+	case len(r.Operations) > MaxBulkItems:
+		return []FieldError{{"operations", fmt.Sprintf("must contain at most %d operations", MaxBulkItems)}}
+	}
+
+	var errs []FieldError
+	refs := map[string]bool{}
+	for i, op := range r.Operations {
+		field := fmt.Sprintf("operations[%d]", i)
+		switch op.Op {
+		case BatchAdd:
+		case BatchGet, BatchUpdate, BatchDelete:
+			if op.ID == "" {
+				errs = append(errs, FieldError{field + ".id", "is required"})
+			}
+		default:
+			errs = append(errs, FieldError{field + ".op", "must be one of get, add, update or delete"})
+		}
+		if strings.HasPrefix(op.ID, "$") && !refs[strings.TrimPrefix(op.ID, "$")] {
+			errs = append(errs, FieldError{field + ".id", "must refer to an earlier operation"})
+		}
+		if op.Ref != "" {
+			if refs[op.Ref] {
+				errs = append(errs, FieldError{field + ".ref", "must be unique"})
+			}
+			refs[op.Ref] = true
+		}
+	}
+	return errs
+}
+
+func (r SearchRequest) validate(ctx context.Context) []FieldError {
+	switch {
+	case strings.TrimSpace(r.Query) == "":
+		return []FieldError{{"q", "is required"}}
+	case utf8.RuneCountInString(r.Query) > MaxSearchQueryLength:
+		return []FieldError{{"q", fmt.Sprintf("must be at most %d characters", MaxSearchQueryLength)}}
+	}
+	return nil
+}
+
+func (r AddViewRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	if r.View.ID != "" {
+		errs = append(errs, FieldError{"id", "is assigned by the server"})
+	}
+	if !r.View.CreatedOn.IsZero() {
+		errs = append(errs, FieldError{"created_on", "is assigned by the server"})
+	}
+	errs = append(errs, validateUsername(ctx, r.View.Username)...)
+	name := strings.TrimSpace(r.View.Name)
+	switch {
+	case name == "":
+		errs = append(errs, FieldError{"name", "is required"})
+	case utf8.RuneCountInString(r.View.Name) > MaxViewNameLength:
+		errs = append(errs, FieldError{"name", fmt.Sprintf("must be at most %d characters", MaxViewNameLength)})
+	case !allowedText(r.View.Name) || strings.ContainsAny(r.View.Name, "\n\t"):
+		errs = append(errs, FieldError{"name", "contains invalid characters"})
+	}
+
+	switch {
+	case strings.TrimSpace(r.View.Query) == "":
+		errs = append(errs, FieldError{"query", "is required"})
+	case utf8.RuneCountInString(r.View.Query) > MaxQueryLength:
+		errs = append(errs, FieldError{"query", fmt.Sprintf("must be at most %d characters", MaxQueryLength)})
+	default:
+		if _, err := ParseQuery(r.View.Query); err != nil {
+			if verr, ok := err.(*ValidationError); ok {
+				errs = append(errs, verr.Fields...)
+			}
+		}
+	}
+	return errs
+}
+
+func (r ExportRequest) validate(ctx context.Context) []FieldError {
+	if _, ok := fileFormats[r.Format]; !ok {
+		return []FieldError{{"format", "must be one of " + formatNames()}}
+	}
+	return nil
+}
+
+func (r ImportRequest) validate(ctx context.Context) []FieldError {
+	if len(r.Items) > MaxImportItems {
+		return []FieldError{{"file", fmt.Sprintf("must contain at most %d todos", MaxImportItems)}}
+	}
+	return nil
+}
+
+func (r ShareRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	switch current, _ := ctx.Value("username").(string); {
+	case r.Username == "":
+		errs = append(errs, FieldError{"username", "is required"})
+	case r.Username == current:
+		errs = append(errs, FieldError{"username", "must be another user"})
+	}
+	if r.Access.level() == accessNone {
+		errs = append(errs, FieldError{"access", "must be viewer or editor"})
+	}
+	return errs
+}
+
+func (r AssignRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	if utf8.RuneCountInString(r.Assignee) > MaxUsernameLength {
+		errs = append(errs, FieldError{"assignee", fmt.Sprintf("must be at most %d characters", MaxUsernameLength)})
+	}
+	return errs
+}
+
+func (r AddToViewRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	if r.Todo.ID != "" {
+		errs = append(errs, FieldError{"id", "is assigned by the server"})
+	}
+	if !r.Todo.CreatedOn.IsZero() {
+		errs = append(errs, FieldError{"created_on", "is assigned by the server"})
+	}
+	if r.Todo.CreatedBy != "" {
+		errs = append(errs, FieldError{"created_by", "is assigned by the server"})
+	}
+	// the View may be shared by another user, who the Todo will belong to
+	if r.Todo.Username != "" {
+		errs = append(errs, FieldError{"username", "is the view's owner"})
+	}
+	return append(errs, validateTodo(r.Todo)...)
+}
+
+func (r CreateAPIKeyRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	name := strings.TrimSpace(r.Name)
+	switch {
+	case name == "":
+		errs = append(errs, FieldError{"name", "is required"})
+	case utf8.RuneCountInString(r.Name) > MaxAPIKeyNameLength:
+		errs = append(errs, FieldError{"name", fmt.Sprintf("must be at most %d characters", MaxAPIKeyNameLength)})
+	case !allowedText(r.Name) || strings.ContainsAny(r.Name, "\n\t"):
+		errs = append(errs, FieldError{"name", "contains invalid characters"})
+	}
+
+	if len(r.Scopes) == 0 {
+		errs = append(errs, FieldError{"scopes", "must contain at least one scope"})
+	}
+	seen := map[string]bool{}
+	for i, scope := range r.Scopes {
+		switch {
+		case !(APIKey{Scopes: APIKeyScopes}).HasScope(scope):
+			errs = append(errs, FieldError{fmt.Sprintf("scopes[%d]", i), "must be one of " + strings.Join(APIKeyScopes, ", ")})
+		case seen[scope]:
+			errs = append(errs, FieldError{fmt.Sprintf("scopes[%d]", i), "is repeated"})
+		}
+		seen[scope] = true
+	}
+	return errs
+}
+
+func (r RegisterRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	switch {
+	case r.Username == "":
+		errs = append(errs, FieldError{"username", "is required"})
+	case utf8.RuneCountInString(r.Username) > MaxUsernameLength:
+		errs = append(errs, FieldError{"username", fmt.Sprintf("must be at most %d characters", MaxUsernameLength)})
+	case !utf8.ValidString(r.Username) || strings.IndexFunc(r.Username, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0:
+		errs = append(errs, FieldError{"username", "contains invalid characters"})
+	}
+
+	switch {
+	case utf8.RuneCountInString(r.Password) < MinPasswordLength:
+		errs = append(errs, FieldError{"password", fmt.Sprintf("must be at least %d characters", MinPasswordLength)})
+	case len(r.Password) > MaxPasswordBytes:
+		errs = append(errs, FieldError{"password", fmt.Sprintf("must be at most %d bytes", MaxPasswordBytes)})
+	}
+	return errs
+}
+
+func (r LoginRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	if r.Username == "" {
+		errs = append(errs, FieldError{"username", "is required"})
+	}
+	if r.Password == "" {
+		errs = append(errs, FieldError{"password", "is required"})
+	}
+	return errs
+}
+
+func (r RefreshRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	if r.RefreshToken == "" {
+		errs = append(errs, FieldError{"refresh_token", "is required"})
+	}
+	return errs
+}
+
+func (r LogoutRequest) validate(ctx context.Context) []FieldError {
+	errs := unknownFieldErrors(r.unknownFields)
+	if r.RefreshToken == "" {
+		errs = append(errs, FieldError{"refresh_token", "is required"})
+	}
+	return errs
+}
+
+func validateFilter(filter Filter) []FieldError {
+	if len(filter.IDs) > MaxBulkItems {
+		return []FieldError{{"filter.ids", fmt.Sprintf("must contain at most %d IDs", MaxBulkItems)}}
+	}
+	return nil
+}
+
+// validateTodo checks the user editable fields of a Todo
+func validateTodo(todo Todo) []FieldError {
+	var errs []FieldError
+	text := strings.TrimSpace(todo.Text)
+	switch {
+	case text == "":
+		errs = append(errs, FieldError{"text", "is required"})
+	case utf8.RuneCountInString(todo.Text) > MaxTextLength:
+		errs = append(errs, FieldError{"text", fmt.Sprintf("must be at most %d characters", MaxTextLength)})
+	case !allowedText(todo.Text):
+		errs = append(errs, FieldError{"text", "contains invalid characters"})
+	}
+
+	if len(todo.Tags) > MaxTags {
+		errs = append(errs, FieldError{"tags", fmt.Sprintf("must contain at most %d tags", MaxTags)})
+	}
+	for i, tag := range todo.Tags {
+		if !validTag(tag) {
+			errs = append(errs, FieldError{fmt.Sprintf("tags[%d]", i), fmt.Sprintf("must be 1 to %d letters, digits, - or _", MaxTagLength)})
+		}
+	}
+	return errs
+}
+
+// validTag reports whether a tag is made of letters, digits, - & _
+func validTag(tag string) bool {
+	if tag == "" || utf8.RuneCountInString(tag) > MaxTagLength {
+		return false
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// validateUsername checks a Todo isn't being created or moved on behalf of another user
+func validateUsername(ctx context.Context, username string) []FieldError {
+	if current, _ := ctx.Value("username").(string); username != "" && username != current {
+		return []FieldError{{"username", "must match the authenticated user"}}
+	}
+	return nil
+}
+
+// allowedText reports whether s is valid UTF-8 without control characters, other than tabs & newlines
+func allowedText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r == utf8.RuneError || (unicode.IsControl(r) && r != '\n' && r != '\t') {
+			return false
+		}
+	}
+	return true
+}
+
+func unknownFieldErrors(fields []string) []FieldError {
+	var errs []FieldError
+	for _, f := range fields {
+		errs = append(errs, FieldError{f, "is not a known field"})
+	}
+	return errs
+}
+
+// unknownJSONFields returns the top level keys of a JSON object which don't map onto a field of v.
+// v must be a pointer to a struct.
+func unknownJSONFields(data []byte, v interface{}) []string {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil
+	}
+
+	known := map[string]bool{}
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		known[strings.ToLower(name)] = true
+	}
+
+	var unknown []string
+	for k := range raw {
+		// encoding/json matches keys case insensitively
+		if !known[strings.ToLower(k)] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,79 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidatingMiddlewareReturnsAllViolations checks every problem with a request is reported together
+func TestValidatingMiddlewareReturnsAllViolations(t *testing.T) {
+	endpoints := MakeTodoEndpoints(NewInmemTodoService())
+	ctx := context.WithValue(context.Background(), "username", "test@test.com")
+
+	_, err := endpoints.AddEndpoint(ctx, AddRequest{
+		Todo: Todo{
+			ID:        "abc",
+			Username:  "someone@else.com",
+			CreatedOn: time.Now(),
+		},
+		unknownFields: []string{"priority"},
+	})
+
+	verr, ok := err.(*ValidationError)
+	require.Truef(t, ok, "Expected a ValidationError, got %v", err)
+	require.Equal(t, []FieldError{
+		{"priority", "is not a known field"},
+		{"id", "is assigned by the server"},
+		{"created_on", "is assigned by the server"},
+		{"username", "must match the authenticated user"},
+		{"text", "is required"},
+	}, verr.Fields)
+}
+
+// TestValidateTodoText checks the limits on a Todo's text
+func TestValidateTodoText(t *testing.T) {
+	cases := []struct {
+		text  string
+		valid bool
+	}{
+		{"Buy milk", true},
+		{"Line one\nLine two\twith tab", true},
+		{"   ", false},
+		{strings.Repeat("a", MaxTextLength), true},
+		{strings.Repeat("a", MaxTextLength+1), false},
+		{"bell\a", false},
+		{"bad \xff utf8", false},
+	}
+	for _, c := range cases {
+		errs := validateTodo(Todo{Text: c.text})
+		require.Equalf(t, c.valid, len(errs) == 0, "Unexpected validation result for %q: %v", c.text, errs)
+	}
+}
+
+// TestUpdateIDMustMatchURL checks a Todo can't be updated through another Todo's URL
+func TestUpdateIDMustMatchURL(t *testing.T) {
+	errs := UpdateRequest{ID: "a", Todo: Todo{ID: "b", Text: "text"}}.validate(context.Background())
+	require.Equal(t, []FieldError{{"id", "must match the ID in the URL"}}, errs)
+}
+
+// TestUnknownJSONFields checks keys which don't map onto a Todo are found
+func TestUnknownJSONFields(t *testing.T) {
+	unknown := unknownJSONFields([]byte(`{"text": "a", "Completed": true, "priority": 1, "colour": null}`), &Todo{})
+	require.Equal(t, []string{"colour", "priority"}, unknown)
+}
+
+// TestOversizedBodyIsRejected checks huge payloads aren't read into memory
+func TestOversizedBodyIsRejected(t *testing.T) {
+	server := newTestServer(t, NewInmemTodoService())
+	defer server.Close()
+
+	todo := Todo{Text: strings.Repeat("a", testMaxBodyBytes)}
+	res := newHTTPServerCall(t, http.MethodPost, server.URL+"/api/todos", todo)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
@@ -1,19 +1,166 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/sinnott74/TodoService/internal/todo"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func main() {
 
-	service := todo.NewInmemTodoService()
+	args := os.Args[1:]
+	// config print shows the effective configuration, without starting the service
+	printConfig := len(args) >= 2 && args[0] == "config" && args[1] == "print"
+	if printConfig {
+		args = args[2:]
+	}
+	config, err := todo.LoadConfig(args, os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if err := config.Print(os.Stdout); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	logger, err := todo.NewLogger(os.Stderr, config.LogFormat, config.Debug)
+	if err != nil {
+		panic(err)
+	}
 
-	endpoints := todo.MakeTodoEndpoints(service)
+	// SIGTERM, or Ctrl+C in development, starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	var hooks []todo.ShutdownFunc
 
-	err := http.ListenAndServe(":"+todo.Port(), todo.MakeHTTPHandler(endpoints))
+	jwtConfig, err := todo.NewJWTConfig(ctx, config, logger)
 	if err != nil {
 		panic(err)
 	}
+	if err := jwtConfig.Check(config.DevMode); err != nil {
+		panic(err)
+	}
+
+	var tenants todo.Tenants
+	if file := config.TenantsFile; file != "" {
+		tenants, err = todo.LoadTenants(file)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var tracerProvider trace.TracerProvider = noop.NewTracerProvider()
+	if endpoint := config.OTLPEndpoint; endpoint != "" {
+		provider, err := todo.NewTracerProvider(ctx, endpoint, config.ServiceName, config.TraceSampleRatio)
+		if err != nil {
+			panic(err)
+		}
+		// spans are exported in batches, so the last batch is flushed on shutdown
+		hooks = append(hooks, provider.Shutdown)
+		tracerProvider = provider
+	}
+	tracer := tracerProvider.Tracer(todo.TracerName)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	notifier := todo.AssigneeNotifier(todo.LogNotifications(logger))
+	storage := todo.NewTenantTodoService(tenants, func(tenant string) todo.TodoService {
+		return todo.NewInmemTodoService(notifier)
+	})
+	if stats, ok := storage.(todo.StatsReporter); ok {
+		todo.RegisterStorageMetrics(registry, "inmem", stats)
+	}
+	service := todo.TracingMiddleware(tracer)(storage)
+	service = todo.LoggingMiddleware(logger)(service)
+	service = todo.InstrumentingMiddleware(todo.NewMetrics(registry, "service", "method"))(service)
+
+	// deleting a user deletes their credentials too
+	apiKeys := todo.NewInmemAPIKeyService()
+	calendars := todo.NewInmemCalendarTokenService()
+	users := []todo.UserDeleter{apiKeys, calendars}
+	var accounts todo.AccountService
+	if config.AccountsEnabled {
+		issuer, err := todo.NewTokenIssuer(jwtConfig, config.AccessTokenTTL)
+		if err != nil {
+			panic(err)
+		}
+		accounts = todo.NewInmemAccountService(issuer, config.RefreshTokenTTL)
+		users = append(users, accounts)
+	}
+
+	// the in memory stores are saved once requests have drained, so they survive restarts & deploys
+	idempotency := todo.NewIdempotencyStore(config.IdempotencyTTL)
+	if file := config.SnapshotFile; file != "" {
+		snapshots := todo.Snapshots{"idempotency": idempotency}
+		stores := map[string]interface{}{"todos": storage, "api_keys": apiKeys, "calendar_tokens": calendars}
+		if accounts != nil {
+			stores["accounts"] = accounts
+		}
+		for name, store := range stores {
+			if snapshotter, ok := store.(todo.Snapshotter); ok {
+				snapshots[name] = snapshotter
+			}
+		}
+		if err := snapshots.Restore(file); err != nil {
+			panic(err)
+		}
+		// saving straight away fails now, rather than on shutdown, if the file can't be written
+		if err := snapshots.Save(file); err != nil {
+			panic(err)
+		}
+		hooks = append(hooks, snapshots.Saver(file))
+	}
+
+	endpoints := todo.MakeTodoEndpoints(service, users...)
+	endpoints = todo.InstrumentEndpoints(endpoints, todo.NewMetrics(registry, "endpoint", "endpoint"))
+	endpoints = todo.TraceEndpoints(endpoints, tracer)
+
+	handler := todo.MakeHTTPHandler(endpoints, jwtConfig, apiKeys, calendars, idempotency, config.MaxBodyBytes, logger)
+	if accounts != nil {
+		handler = todo.MakeAccountHTTPHandler(todo.MakeAccountEndpoints(accounts), handler, logger)
+	}
+
+	// the process is alive while its storage can be locked, as a deadlock needs a restart to clear.
+	// It's ready once the keys it verifies JWTs with have been fetched too.
+	liveness, readiness := todo.Checks{}, todo.Checks{}
+	if checker, ok := storage.(todo.Checker); ok {
+		liveness["storage"] = checker
+		readiness["storage"] = checker
+	}
+	if jwks, ok := jwtConfig.Keys.(*todo.JWKS); ok {
+		readiness["jwks"] = jwks
+	}
+	handler = todo.MakeHealthHTTPHandler(liveness, readiness, handler)
+
+	serverConfig := todo.ServerConfig{
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.WriteTimeout,
+		IdleTimeout:    config.IdleTimeout,
+		MaxHeaderBytes: config.MaxHeaderBytes,
+		MaxBodyBytes:   config.MaxBodyBytes,
+	}
+	servers := []*http.Server{
+		todo.NewServer(":"+config.Port, handler, serverConfig),
+		todo.NewServer(":"+config.AdminPort, todo.MakeAdminHandler(registry), serverConfig),
+	}
+	if err := todo.Serve(ctx, logger, config.ShutdownTimeout, servers, hooks...); err != nil {
+		os.Exit(1)
+	}
 }